@@ -1,55 +1,281 @@
 package main
 
 import (
+	"context"
+	"expvar"
 	"fmt"
+	"net"
 	"net/http"
 	"time"
 
+	userv1 "github.com/Ollub/user_service/api/user/v1"
 	"github.com/Ollub/user_service/config"
+	audit_delivery "github.com/Ollub/user_service/internal/audit/delivery"
+	audit_repo "github.com/Ollub/user_service/internal/audit/repo"
+	audit_usecase "github.com/Ollub/user_service/internal/audit/usecase"
+	"github.com/Ollub/user_service/internal/dataexport"
+	export_delivery "github.com/Ollub/user_service/internal/dataexport/delivery"
+	export_repo "github.com/Ollub/user_service/internal/dataexport/repo"
+	export_usecase "github.com/Ollub/user_service/internal/dataexport/usecase"
+	"github.com/Ollub/user_service/internal/ldap"
+	lockout_repo "github.com/Ollub/user_service/internal/lockout/repo"
+	lockout_usecase "github.com/Ollub/user_service/internal/lockout/usecase"
+	login_delivery "github.com/Ollub/user_service/internal/logins/delivery"
+	login_repo "github.com/Ollub/user_service/internal/logins/repo"
+	login_usecase "github.com/Ollub/user_service/internal/logins/usecase"
 	"github.com/Ollub/user_service/internal/middleware"
+	"github.com/Ollub/user_service/internal/oauth"
+	oauth_delivery "github.com/Ollub/user_service/internal/oauth/delivery"
+	oauth_repo "github.com/Ollub/user_service/internal/oauth/repo"
+	oauth_usecase "github.com/Ollub/user_service/internal/oauth/usecase"
+	scim_delivery "github.com/Ollub/user_service/internal/scim/delivery"
 	"github.com/Ollub/user_service/internal/session"
+	session_delivery "github.com/Ollub/user_service/internal/session/delivery"
+	"github.com/Ollub/user_service/internal/sso"
+	sso_delivery "github.com/Ollub/user_service/internal/sso/delivery"
+	sso_repo "github.com/Ollub/user_service/internal/sso/repo"
+	sso_usecase "github.com/Ollub/user_service/internal/sso/usecase"
+	"github.com/Ollub/user_service/internal/users"
 	"github.com/Ollub/user_service/internal/users/delivery"
 	"github.com/Ollub/user_service/internal/users/repo"
 	"github.com/Ollub/user_service/internal/users/usecase"
 	"github.com/Ollub/user_service/pkg/db"
+	"github.com/Ollub/user_service/pkg/i18n"
 	"github.com/Ollub/user_service/pkg/log"
+	"github.com/Ollub/user_service/pkg/ratelimit"
+	"github.com/Ollub/user_service/pkg/utils/http_utils"
+	"github.com/Ollub/user_service/pkg/utils/password"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
 	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
 )
 
-func NewServer(cfg config.Config) http.Server {
+func NewServer(cfg config.Config) (http.Server, *grpc.Server, *grpc.Server) {
 	conn, err := db.GetPostgres(cfg.DbConf)
 	if err != nil {
 		panic(err)
 	}
+	audit_manager := audit_usecase.NewManager(audit_repo.NewPgRepository(conn))
 	user_repo := repo.NewPgRepository(conn)
-	user_manager := usecase.NewManager(user_repo)
-	session_manager := session.NewSessionsJWTVer(cfg.JwtKey, cfg.TokenTTLDays, user_manager)
+	if err := password.SetupPeppers(cfg.Pepper); err != nil {
+		panic(err)
+	}
+	if err := i18n.Setup(cfg.Locales); err != nil {
+		panic(err)
+	}
+	breach_checker, err := password.NewBreachChecker(cfg.PwnedPasswords)
+	if err != nil {
+		panic(err)
+	}
+	cfg.PasswordPolicy.Breached = breach_checker
+	hash_pool := password.NewPool("password_hashing", cfg.HashConcurrency, cfg.HashQueueTimeout)
+	var authenticators []usecase.Authenticator
+	if cfg.LDAP.URL != "" {
+		authenticators = append(authenticators, ldap.NewAuthenticator(cfg.LDAP))
+	}
+	user_manager := usecase.NewManager(
+		user_repo,
+		audit_manager,
+		hash_pool,
+		cfg.Argon,
+		cfg.PasswordHistorySize,
+		time.Duration(cfg.PasswordMaxAgeDays)*24*time.Hour,
+		authenticators...,
+	)
+	session_manager := session.NewSessionsJWTVer(cfg.JwtKey, cfg.TokenTTLDays, user_manager, audit_manager)
+	login_manager := login_usecase.NewManager(login_repo.NewPgRepository(conn))
+	lockout_manager := lockout_usecase.NewManager(lockout_repo.NewPgRepository(conn), audit_manager, cfg.Lockout)
+	oidc_keys, err := oauth.LoadKeySet(cfg.OAuth.SigningKeyPath)
+	if err != nil {
+		panic(err)
+	}
+	oauth_manager := oauth_usecase.NewManager(oauth_repo.NewPgRepository(conn), user_manager, session_manager, oidc_keys, audit_manager, cfg.OAuth)
+	session_manager.Clients = oauth_manager
+	go oauth_manager.Run(context.Background(), time.Hour)
 
-	u := delivery.NewHandler(session_manager, user_manager)
+	export_manager := export_usecase.NewManager(
+		export_repo.NewPgRepository(conn),
+		time.Duration(cfg.DataExportTTLHours)*time.Hour,
+		time.Duration(cfg.DataExportTimeoutMinutes)*time.Minute,
+		dataexport.SectionFunc("profile", func(ctx context.Context, userID uint32) (interface{}, error) {
+			return user_manager.GetProfile(ctx, userID)
+		}),
+		dataexport.SectionFunc("sessions", func(ctx context.Context, userID uint32) (interface{}, error) {
+			return oauth_manager.Sessions(ctx, userID)
+		}),
+		dataexport.SectionFunc("login_history", func(ctx context.Context, userID uint32) (interface{}, error) {
+			return login_manager.History(ctx, userID)
+		}),
+		dataexport.SectionFunc("audit_events", func(ctx context.Context, userID uint32) (interface{}, error) {
+			return audit_manager.UserEvents(ctx, userID)
+		}),
+	)
+	go export_manager.Run(context.Background(), time.Duration(cfg.DataExportPollSeconds)*time.Second)
+
+	sso_providers, err := sso.LoadProviders(cfg.SSO.Providers)
+	if err != nil {
+		panic(err)
+	}
+	if cfg.SSO.BaseURL == "" {
+		cfg.SSO.BaseURL = cfg.OAuth.Issuer
+	}
+	sso_manager := sso_usecase.NewManager(sso_repo.NewPgRepository(conn), user_manager, audit_manager, sso_providers, cfg.SSO)
+	go sso_manager.Run(context.Background(), time.Hour)
+
+	u := delivery.NewHandler(session_manager, user_manager, login_manager, lockout_manager, cfg.PasswordPolicy)
+	loginHistory := login_delivery.NewHandler(login_manager)
+	exports := export_delivery.NewHandler(export_manager)
+	events := audit_delivery.NewHandler(audit_manager)
+	oauthHandler := oauth_delivery.NewHandler(oauth_manager, u)
+	ssoHandler := sso_delivery.NewHandler(
+		sso_manager,
+		session_manager,
+		login_manager,
+		cfg.SSO,
+		cfg.AuthCookieName,
+		time.Duration(cfg.TokenTTLDays)*24*time.Hour,
+	)
+	if cfg.SCIM.BaseURL == "" {
+		cfg.SCIM.BaseURL = cfg.OAuth.Issuer
+	}
+	scimHandler := scim_delivery.NewHandler(user_manager, cfg.PasswordPolicy, cfg.SCIM)
+	auth := session_delivery.NewHandler(session_manager, cfg.IntrospectionClients, cfg.AuthCookieName)
+
+	var limitStore ratelimit.Store
+	switch cfg.RateLimit.Backend {
+	case "memory":
+		store := ratelimit.NewMemoryStore()
+		go ratelimit.RunCleanup(context.Background(), store, time.Minute, time.Hour)
+		limitStore = store
+	case "postgres":
+		store := ratelimit.NewPostgresStore(conn)
+		go ratelimit.RunCleanup(context.Background(), store, time.Minute, time.Hour)
+		limitStore = store
+	default:
+		panic(fmt.Sprintf("unknown rate limit backend %q", cfg.RateLimit.Backend))
+	}
+	signupLimit := middleware.RateLimit(
+		limitStore,
+		ratelimit.PerMinute(cfg.RateLimit.SignupPerMinute, cfg.RateLimit.SignupBurst),
+		middleware.ByRoute,
+		middleware.ByIP,
+	)
+	loginLimit := middleware.RateLimit(
+		limitStore,
+		ratelimit.PerMinute(cfg.RateLimit.LoginPerMinute, cfg.RateLimit.LoginBurst),
+		middleware.ByRoute,
+		middleware.ByIP,
+	)
 
 	apiHandler := mux.NewRouter()
 
-	apiHandler.HandleFunc("/signup", u.Register).Methods("POST")
-	apiHandler.HandleFunc("/login", u.Login).Methods("POST")
+	apiHandler.Handle("/signup", signupLimit(http.HandlerFunc(u.Register))).Methods("POST")
+	apiHandler.Handle("/login", loginLimit(http.HandlerFunc(u.Login))).Methods("POST")
+	apiHandler.HandleFunc("/login/{provider}", ssoHandler.Login).Methods("GET")
+	apiHandler.Handle("/login/{provider}/callback", loginLimit(http.HandlerFunc(ssoHandler.Callback))).Methods("GET")
+	apiHandler.HandleFunc("/introspect", auth.Introspect).Methods("POST")
+	apiHandler.HandleFunc("/authorize", oauthHandler.Authorize).Methods("GET")
+	apiHandler.Handle("/authorize", loginLimit(http.HandlerFunc(oauthHandler.AuthorizeSubmit))).Methods("POST")
+	apiHandler.HandleFunc("/token", oauthHandler.Token).Methods("POST")
+	apiHandler.HandleFunc("/userinfo", oauthHandler.UserInfo).Methods("GET", "POST")
+	apiHandler.HandleFunc("/.well-known/openid-configuration", oauthHandler.Discovery).Methods("GET")
+	apiHandler.HandleFunc("/.well-known/jwks.json", oauthHandler.JWKS).Methods("GET")
 	apiHandler.HandleFunc("/users", u.List).Methods("GET")
 	apiHandler.HandleFunc("/users/{id}", u.Update).Methods("PUT")
+	apiHandler.HandleFunc("/me/password", u.ChangePassword).Methods("PUT")
+	apiHandler.HandleFunc("/me/logins", loginHistory.List).Methods("GET")
+	apiHandler.HandleFunc("/me/data-export", exports.Create).Methods("POST")
+	apiHandler.HandleFunc("/me/data-export", exports.List).Methods("GET")
+	apiHandler.HandleFunc("/me/data-export/{id}", exports.Status).Methods("GET")
+	apiHandler.HandleFunc("/me/data-export/{id}/archive", exports.Download).Methods("GET")
+
+	adminHandler := apiHandler.PathPrefix("/admin").Subrouter()
+	adminHandler.HandleFunc("/users/export", u.Export).Methods("GET")
+	adminHandler.HandleFunc("/users/import", u.Import).Methods("POST")
+	adminHandler.HandleFunc("/users/{id}/unlock", u.Unlock).Methods("POST")
+	adminHandler.HandleFunc("/users/{id}/require-password-change", u.RequirePasswordChange).Methods("POST")
+	adminHandler.HandleFunc("/audit-events", events.List).Methods("GET")
+	adminHandler.HandleFunc("/oauth/clients", oauthHandler.CreateClient).Methods("POST")
+	adminHandler.HandleFunc("/oauth/clients", oauthHandler.ListClients).Methods("GET")
+	adminHandler.HandleFunc("/oauth/clients/{id}", oauthHandler.DeleteClient).Methods("DELETE")
+	adminHandler.Use(middleware.RequireRole(users.RoleAdmin))
+
+	// authenticated by SCIM_TOKENS
+	scimRouter := apiHandler.PathPrefix("/scim/v2").Subrouter()
+	scimRouter.HandleFunc("/ServiceProviderConfig", scimHandler.ServiceProviderConfig).Methods("GET")
+	scimRouter.HandleFunc("/ResourceTypes", scimHandler.ResourceTypes).Methods("GET")
+	scimRouter.HandleFunc("/Schemas", scimHandler.Schemas).Methods("GET")
+	scimRouter.HandleFunc("/Users", scimHandler.ListUsers).Methods("GET")
+	scimRouter.HandleFunc("/Users", scimHandler.CreateUser).Methods("POST")
+	scimRouter.HandleFunc("/Users/{id}", scimHandler.GetUser).Methods("GET")
+	scimRouter.HandleFunc("/Users/{id}", scimHandler.ReplaceUser).Methods("PUT")
+	scimRouter.HandleFunc("/Users/{id}", scimHandler.PatchUser).Methods("PATCH")
+	scimRouter.HandleFunc("/Users/{id}", scimHandler.DeleteUser).Methods("DELETE")
+	scimRouter.Use(scimHandler.Authenticate)
 
 	apiHandler.Use(
+		middleware.SetupReqID,
+		middleware.InjectLogger,
+		middleware.SetupClientInfo(cfg.ProxyHops()),
+		middleware.SetupAccessLog,
 		middleware.Authentication(session_manager),
+		middleware.RateLimit(
+			limitStore,
+			ratelimit.PerMinute(cfg.RateLimit.APIPerMinute, cfg.RateLimit.APIBurst),
+			middleware.ByUser,
+		),
+	)
+
+	// forward-auth is called by proxies for every request, so it is not rate limited
+	verifyHandler := mux.NewRouter()
+	verifyHandler.HandleFunc("/auth/verify", auth.Verify)
+	verifyHandler.Use(
 		middleware.SetupReqID,
 		middleware.InjectLogger,
+		middleware.SetupClientInfo(cfg.ProxyHops()),
 		middleware.SetupAccessLog,
 	)
 
 	siteMux := http.NewServeMux()
 	siteMux.Handle("/", apiHandler)
+	siteMux.Handle("/auth/verify", verifyHandler)
+
+	grpcServer := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		middleware.UnaryReqID,
+		middleware.UnaryInjectLogger,
+		middleware.UnaryClientInfo(cfg.ProxyHops()),
+		middleware.UnaryAccessLog,
+		middleware.UnaryRecover,
+		middleware.UnaryAuthentication(session_manager, delivery.NoAuthMethods...),
+		middleware.UnaryRateLimit(
+			limitStore,
+			ratelimit.PerMinute(cfg.RateLimit.APIPerMinute, cfg.RateLimit.APIBurst),
+			map[string]ratelimit.Limit{
+				userv1.UserService_Signup_FullMethodName: ratelimit.PerMinute(cfg.RateLimit.SignupPerMinute, cfg.RateLimit.SignupBurst),
+				userv1.UserService_Login_FullMethodName:  ratelimit.PerMinute(cfg.RateLimit.LoginPerMinute, cfg.RateLimit.LoginBurst),
+			},
+		),
+	))
+	userv1.RegisterUserServiceServer(grpcServer, delivery.NewGrpcServer(u))
+
+	// Envoy calls ext_authz for every request, so it has neither authentication nor rate limits
+	extAuthzServer := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		middleware.UnaryReqID,
+		middleware.UnaryInjectLogger,
+		middleware.UnaryClientInfo(cfg.ProxyHops()),
+		middleware.UnaryAccessLog,
+		middleware.UnaryRecover,
+	))
+	authv3.RegisterAuthorizationServer(extAuthzServer, session_delivery.NewExtAuthzServer(auth))
 
 	return http.Server{
 		Addr:         fmt.Sprintf(":%d", cfg.ServerPort),
 		Handler:      siteMux,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
-	}
+		// the streaming users export lifts the write timeout with the connection of the context
+		ConnContext: http_utils.ConnContext,
+	}, grpcServer, extAuthzServer
 }
 
 func Init() {
@@ -62,9 +288,50 @@ func Init() {
 	log.SetupLogger(logLevel)
 }
 
+func serveGrpc(name string, srv *grpc.Server, port int) {
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		panic(err)
+	}
+	go func() {
+		log.Info("Start "+name+" server", log.Fields{"port": port})
+		if err := srv.Serve(lis); err != nil {
+			panic(err)
+		}
+	}()
+}
+
+// serveMetrics serves expvar on its own port, it exposes the command line and runtime internals
+// and must not be reachable from outside of the cluster.
+func serveMetrics(port int) {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	srv := &http.Server{
+		Addr:         fmt.Sprintf(":%d", port),
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+	go func() {
+		log.Info("Start metrics server", log.Fields{"port": port})
+		if err := srv.ListenAndServe(); err != nil {
+			panic(err)
+		}
+	}()
+}
+
 func main() {
 	Init()
-	server := NewServer(config.Cfg)
+	server, grpcServer, extAuthzServer := NewServer(config.Cfg)
+
+	serveGrpc("gRPC", grpcServer, config.Cfg.GrpcPort)
+	if config.Cfg.ExtAuthzPort != 0 {
+		serveGrpc("ext_authz", extAuthzServer, config.Cfg.ExtAuthzPort)
+	}
+	if config.Cfg.MetricsPort != 0 {
+		serveMetrics(config.Cfg.MetricsPort)
+	}
+
 	log.Info("Start server")
 	if err := server.ListenAndServe(); err != nil {
 		panic(err)
@@ -1,7 +1,17 @@
 package config
 
 import (
+	"time"
+
+	"github.com/Ollub/user_service/internal/ldap"
+	"github.com/Ollub/user_service/internal/lockout"
+	"github.com/Ollub/user_service/internal/oauth"
+	"github.com/Ollub/user_service/internal/scim"
+	"github.com/Ollub/user_service/internal/sso"
 	"github.com/Ollub/user_service/pkg/db"
+	"github.com/Ollub/user_service/pkg/i18n"
+	"github.com/Ollub/user_service/pkg/ratelimit"
+	"github.com/Ollub/user_service/pkg/utils/password"
 	"github.com/kelseyhightower/envconfig"
 )
 
@@ -9,14 +19,73 @@ var Cfg Config
 
 type Config struct {
 	ServerPort int `envconfig:"SERVER_PORT" default:"8080"`
+	GrpcPort   int `envconfig:"GRPC_PORT" default:"9090"`
+	// Envoy external authorization gRPC service, 0 disables it
+	ExtAuthzPort int `envconfig:"EXT_AUTHZ_PORT" default:"9191"`
+	// Internal port of the expvar metrics, 0 disables them
+	MetricsPort int `envconfig:"METRICS_PORT" default:"9100"`
+	// Trust X-Forwarded-For/X-Real-IP headers, enable only behind a reverse proxy
+	TrustProxy bool `envconfig:"TRUST_PROXY" default:"false"`
+	// Number of trusted proxies appending to X-Forwarded-For in front of the service
+	TrustProxyHops int `envconfig:"TRUST_PROXY_HOPS" default:"1"`
 
 	Debug        bool   `envconfig:"DEBUG" default:"true"`
 	JwtKey       []byte `envconfig:"JWT_KEY" default:"super secret"`
 	TokenTTLDays int    `envconfig:"TokenTTL" default:"90"`
+	// Resource servers allowed to introspect tokens, `id1:secret1,id2:secret2`
+	IntrospectionClients map[string]string `envconfig:"INTROSPECTION_CLIENTS"`
+	// Cookie with the token checked by the forward-auth endpoint, empty disables it
+	AuthCookieName string `envconfig:"AUTH_COOKIE_NAME" default:"auth_token"`
+
+	// Every password hash takes 64 MB, so the number of parallel ones is limited
+	HashConcurrency  int           `envconfig:"HASH_CONCURRENCY" default:"4"`
+	HashQueueTimeout time.Duration `envconfig:"HASH_QUEUE_TIMEOUT" default:"2s"`
+	// Argon2id parameters of new hashes, existing weaker hashes are upgraded on login
+	Argon *password.ArgonParams
+	// Rules for new passwords
+	PasswordPolicy *password.Policy
+	// Number of previous passwords which can not be reused on password change
+	PasswordHistorySize int `envconfig:"PASSWORD_HISTORY_SIZE" default:"5"`
+	// Passwords older than that have to be changed on the next login, 0 disables the expiry
+	PasswordMaxAgeDays int `envconfig:"PASSWORD_MAX_AGE_DAYS" default:"0"`
+	// Offline "pwned passwords" dataset, new passwords found in it are rejected
+	PwnedPasswords *password.BreachCfg
+	// HMAC peppers applied to passwords before hashing
+	Pepper *password.PepperCfg
+
+	DataExportTTLHours    int `envconfig:"DATA_EXPORT_TTL_HOURS" default:"72"`
+	DataExportPollSeconds int `envconfig:"DATA_EXPORT_POLL_SECONDS" default:"5"`
+	// Exports running longer than that are retried, their worker is assumed dead
+	DataExportTimeoutMinutes int `envconfig:"DATA_EXPORT_TIMEOUT_MINUTES" default:"15"`
+	// Translations of error messages
+	Locales *i18n.Config
+	// Failed logins throttling config
+	Lockout *lockout.Config
+	// OAuth 2.0 authorization server tokens lifetime
+	OAuth *oauth.Config
+	// Sign in with external OpenID providers
+	SSO *sso.Config
+	// Password login against an LDAP or Active Directory server
+	LDAP *ldap.Config
+	// SCIM 2.0 provisioning API
+	SCIM *scim.Config
+	// Requests rate limiting config
+	RateLimit *ratelimit.Config
 	// Postgres config
 	DbConf *db.PgCfg
 }
 
+// ProxyHops is the number of trusted proxies the client ip is taken behind, 0 if they are not trusted.
+func (c *Config) ProxyHops() int {
+	if !c.TrustProxy {
+		return 0
+	}
+	return c.TrustProxyHops
+}
+
 func Load() {
 	envconfig.MustProcess("", &Cfg)
+	if err := Cfg.RateLimit.Validate(); err != nil {
+		panic(err)
+	}
 }
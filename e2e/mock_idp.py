@@ -0,0 +1,96 @@
+"""Minimal OpenID provider for the e2e tests of the sso login.
+
+/authorize approves at once and takes the user claims (sub, email, email_verified, given_name,
+family_name, name) from its query params, so the tests choose the identity they sign in with.
+"""
+import base64
+import hashlib
+import json
+import os
+import secrets
+import time
+from http.server import BaseHTTPRequestHandler, ThreadingHTTPServer
+from urllib.parse import parse_qs, urlencode, urlparse
+
+import jwt
+from cryptography.hazmat.primitives.asymmetric import rsa
+
+ISSUER = os.environ.get("MOCK_IDP_ISSUER", "http://localhost:8081")
+PORT = int(os.environ.get("MOCK_IDP_PORT", "8081"))
+CLIENT_ID = os.environ.get("MOCK_IDP_CLIENT_ID", "user-service")
+CLIENT_SECRET = os.environ.get("MOCK_IDP_CLIENT_SECRET", "user-service-secret")
+USER_CLAIMS = ("sub", "email", "given_name", "family_name", "name")
+
+KEY = rsa.generate_private_key(public_exponent=65537, key_size=2048)
+KID = "mock-idp"
+CODES = {}
+
+
+def b64_uint(n):
+    return base64.urlsafe_b64encode(n.to_bytes((n.bit_length() + 7) // 8, "big")).rstrip(b"=").decode()
+
+
+class Handler(BaseHTTPRequestHandler):
+    def do_GET(self):
+        url = urlparse(self.path)
+        params = {k: v[0] for k, v in parse_qs(url.query).items()}
+        if url.path == "/.well-known/openid-configuration":
+            return self.send_json(200, {
+                "issuer": ISSUER,
+                "authorization_endpoint": f"{ISSUER}/authorize",
+                "token_endpoint": f"{ISSUER}/token",
+                "jwks_uri": f"{ISSUER}/jwks",
+                "response_types_supported": ["code"],
+                "subject_types_supported": ["public"],
+                "id_token_signing_alg_values_supported": ["RS256"],
+                "code_challenge_methods_supported": ["S256"],
+            })
+        if url.path == "/jwks":
+            pub = KEY.public_key().public_numbers()
+            return self.send_json(200, {"keys": [
+                {"kty": "RSA", "use": "sig", "alg": "RS256", "kid": KID, "n": b64_uint(pub.n), "e": b64_uint(pub.e)},
+            ]})
+        if url.path == "/authorize":
+            if params.get("client_id") != CLIENT_ID:
+                return self.send_json(400, {"error": "invalid_request"})
+            code = secrets.token_urlsafe(16)
+            CODES[code] = params
+            self.send_response(302)
+            self.send_header("Location", params["redirect_uri"] + "?" + urlencode({"code": code, "state": params["state"]}))
+            self.end_headers()
+            return
+        self.send_json(404, {"error": "not_found"})
+
+    def do_POST(self):
+        if self.path != "/token":
+            return self.send_json(404, {"error": "not_found"})
+        expected = base64.b64encode(f"{CLIENT_ID}:{CLIENT_SECRET}".encode()).decode()
+        if self.headers.get("Authorization") != f"Basic {expected}":
+            return self.send_json(401, {"error": "invalid_client"})
+        form = {k: v[0] for k, v in parse_qs(self.rfile.read(int(self.headers["Content-Length"])).decode()).items()}
+        params = CODES.pop(form.get("code"), None)
+        if params is None or params["redirect_uri"] != form.get("redirect_uri"):
+            return self.send_json(400, {"error": "invalid_grant"})
+        digest = hashlib.sha256(form.get("code_verifier", "").encode()).digest()
+        if base64.urlsafe_b64encode(digest).rstrip(b"=").decode() != params.get("code_challenge"):
+            return self.send_json(400, {"error": "invalid_grant"})
+
+        now = int(time.time())
+        claims = {"iss": ISSUER, "aud": CLIENT_ID, "iat": now, "exp": now + 300, "nonce": params.get("nonce")}
+        claims.update({k: params[k] for k in USER_CLAIMS if k in params})
+        if "email_verified" in params:
+            claims["email_verified"] = params["email_verified"] == "true"
+        id_token = jwt.encode(claims, KEY, algorithm="RS256", headers={"kid": KID})
+        self.send_json(200, {"access_token": secrets.token_urlsafe(16), "token_type": "Bearer", "id_token": id_token})
+
+    def send_json(self, status, body):
+        data = json.dumps(body).encode()
+        self.send_response(status)
+        self.send_header("Content-Type", "application/json")
+        self.send_header("Content-Length", str(len(data)))
+        self.end_headers()
+        self.wfile.write(data)
+
+
+if __name__ == "__main__":
+    ThreadingHTTPServer(("", PORT), Handler).serve_forever()
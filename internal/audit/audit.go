@@ -0,0 +1,81 @@
+package audit
+
+import (
+	"reflect"
+	"time"
+)
+
+const (
+	ActionUserCreated            = "user.created"
+	ActionUserUpdated            = "user.updated"
+	ActionUserImported           = "user.imported"
+	ActionUserProvisioned        = "user.provisioned"
+	ActionUserDeleted            = "user.deleted"
+	ActionPasswordRehashed       = "user.password.rehashed"
+	ActionPasswordChanged        = "user.password.changed"
+	ActionPasswordChangeRequired = "user.password.change_required"
+	ActionLoginSucceeded         = "auth.login.succeeded"
+	ActionLoginFailed            = "auth.login.failed"
+	ActionLoginLocked            = "auth.login.locked"
+	ActionLoginUnlocked          = "auth.login.unlocked"
+	ActionTokenIssued            = "session.token.issued"
+	ActionTokenRejected          = "session.token.rejected"
+	ActionOAuthClientCreated     = "oauth.client.created"
+	ActionOAuthClientDeleted     = "oauth.client.deleted"
+	ActionOAuthAuthorized        = "oauth.authorized"
+	ActionRefreshTokenReused     = "oauth.refresh_token.reused"
+	ActionIdentityLinked         = "sso.identity.linked"
+)
+
+type Event struct {
+	ID        int64                  `json:"id"`
+	ActorID   *uint32                `json:"actorId"`
+	TargetID  *uint32                `json:"targetId"`
+	Action    string                 `json:"action"`
+	IP        string                 `json:"ip"`
+	UserAgent string                 `json:"userAgent"`
+	RequestID string                 `json:"requestId"`
+	Changes   map[string]Change      `json:"changes,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	CreatedAt time.Time              `json:"createdAt"`
+}
+
+// Change is the before/after value of a single changed field.
+type Change struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// Filter for the events query. Zero values are ignored.
+type Filter struct {
+	ActorID  uint32
+	TargetID uint32
+	// UserID matches events where the user is either the actor or the target.
+	UserID uint32
+	Action string
+	From   time.Time
+	To     time.Time
+	Limit  int
+	Offset int
+}
+
+// Diff returns the fields which differ between before and after.
+func Diff(before, after map[string]interface{}) map[string]Change {
+	changes := map[string]Change{}
+	for k, b := range before {
+		if a := after[k]; !reflect.DeepEqual(a, b) {
+			changes[k] = Change{Before: b, After: a}
+		}
+	}
+	for k, a := range after {
+		if _, ok := before[k]; !ok {
+			changes[k] = Change{After: a}
+		}
+	}
+	return changes
+}
+
+// UserRef is a shortcut to fill ActorID/TargetID.
+func UserRef(id uint32) *uint32 {
+	return &id
+}
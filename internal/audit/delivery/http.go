@@ -0,0 +1,70 @@
+package delivery
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Ollub/user_service/internal/audit"
+	"github.com/Ollub/user_service/internal/audit/usecase"
+	"github.com/Ollub/user_service/pkg/utils/http_utils"
+)
+
+type Handler struct {
+	events *usecase.Manager
+}
+
+func NewHandler(auditManager *usecase.Manager) *Handler {
+	return &Handler{auditManager}
+}
+
+type ListEventsResp struct {
+	Events []*audit.Event `json:"events"`
+	Limit  int            `json:"limit"`
+	Offset int            `json:"offset"`
+}
+
+// List returns audit events, newest first.
+// Supported query params: actorId, targetId, action, from, to, limit, offset.
+func (h *Handler) List(w http.ResponseWriter, r *http.Request) {
+	filter, fieldErr := parseFilter(r)
+	if fieldErr != nil {
+		http_utils.ValidationError(w, r, []*http_utils.FieldError{fieldErr}, http.StatusBadRequest)
+		return
+	}
+	items, err := h.events.List(r.Context(), filter)
+	if err != nil {
+		http_utils.HttpError(w, r, http_utils.CodeInternal, "Internal error while listing audit events", http.StatusInternalServerError)
+		return
+	}
+	http_utils.JsonResp(w, ListEventsResp{items, filter.Limit, filter.Offset}, http.StatusOK)
+}
+
+func parseFilter(r *http.Request) (*audit.Filter, *http_utils.FieldError) {
+	f := &audit.Filter{}
+	var fieldErr *http_utils.FieldError
+	if f.Limit, f.Offset, fieldErr = http_utils.PageFromQuery(r); fieldErr != nil {
+		return nil, fieldErr
+	}
+
+	query := r.URL.Query()
+	f.Action = query.Get("action")
+	for param, dst := range map[string]*uint32{"actorId": &f.ActorID, "targetId": &f.TargetID} {
+		if v := query.Get(param); v != "" {
+			id, err := strconv.ParseUint(v, 10, 32)
+			if err != nil {
+				return nil, http_utils.NewFieldError(param, http_utils.FieldInvalid, "should be an integer")
+			}
+			*dst = uint32(id)
+		}
+	}
+	for param, dst := range map[string]*time.Time{"from": &f.From, "to": &f.To} {
+		if v := query.Get(param); v != "" {
+			var err error
+			if *dst, err = time.Parse(time.RFC3339, v); err != nil {
+				return nil, http_utils.NewFieldError(param, http_utils.FieldInvalid, "should be RFC3339 timestamp")
+			}
+		}
+	}
+	return f, nil
+}
@@ -0,0 +1,132 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Ollub/user_service/internal/audit"
+)
+
+type RepoPgx struct {
+	DB *sql.DB
+}
+
+func NewPgRepository(db *sql.DB) *RepoPgx {
+	return &RepoPgx{DB: db}
+}
+
+func (repo *RepoPgx) Add(ctx context.Context, e *audit.Event) (int64, error) {
+	changes, err := nullableJSON(e.Changes, len(e.Changes) == 0)
+	if err != nil {
+		return 0, err
+	}
+	details, err := nullableJSON(e.Details, len(e.Details) == 0)
+	if err != nil {
+		return 0, err
+	}
+
+	var lastInsertId int64
+	err = repo.DB.QueryRowContext(
+		ctx,
+		`INSERT INTO audit_events (actor_id, target_id, action, ip, user_agent, request_id, changes, details) `+
+			`VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+		e.ActorID,
+		e.TargetID,
+		e.Action,
+		e.IP,
+		e.UserAgent,
+		e.RequestID,
+		changes,
+		details,
+	).Scan(&lastInsertId)
+	if err != nil {
+		return 0, err
+	}
+	return lastInsertId, nil
+}
+
+func (repo *RepoPgx) List(ctx context.Context, f *audit.Filter) ([]*audit.Event, error) {
+	var conds []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, strings.ReplaceAll(cond, "?", fmt.Sprintf("$%d", len(args))))
+	}
+	if f.ActorID != 0 {
+		add("actor_id = ?", f.ActorID)
+	}
+	if f.TargetID != 0 {
+		add("target_id = ?", f.TargetID)
+	}
+	if f.UserID != 0 {
+		add("(actor_id = ? OR target_id = ?)", f.UserID)
+	}
+	if f.Action != "" {
+		add("action = ?", f.Action)
+	}
+	if !f.From.IsZero() {
+		add("created_at >= ?", f.From)
+	}
+	if !f.To.IsZero() {
+		add("created_at < ?", f.To)
+	}
+	query := `SELECT id, actor_id, target_id, action, ip, user_agent, request_id, changes, details, created_at FROM audit_events`
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " ORDER BY id DESC"
+	if f.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", f.Limit)
+	}
+	if f.Offset > 0 {
+		query += fmt.Sprintf(" OFFSET %d", f.Offset)
+	}
+
+	items := []*audit.Event{}
+	rows, err := repo.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e := &audit.Event{}
+		var actorID, targetID sql.NullInt32
+		var changes, details []byte
+		err = rows.Scan(&e.ID, &actorID, &targetID, &e.Action, &e.IP, &e.UserAgent, &e.RequestID, &changes, &details, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if actorID.Valid {
+			e.ActorID = audit.UserRef(uint32(actorID.Int32))
+		}
+		if targetID.Valid {
+			e.TargetID = audit.UserRef(uint32(targetID.Int32))
+		}
+		if changes != nil {
+			if err = json.Unmarshal(changes, &e.Changes); err != nil {
+				return nil, err
+			}
+		}
+		if details != nil {
+			if err = json.Unmarshal(details, &e.Details); err != nil {
+				return nil, err
+			}
+		}
+		items = append(items, e)
+	}
+	return items, rows.Err()
+}
+
+func nullableJSON(v interface{}, empty bool) (interface{}, error) {
+	if empty {
+		return nil, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
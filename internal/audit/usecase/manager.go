@@ -0,0 +1,81 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Ollub/user_service/internal/audit"
+	"github.com/Ollub/user_service/internal/middleware"
+	"github.com/Ollub/user_service/internal/session"
+	"github.com/Ollub/user_service/pkg/log"
+)
+
+const (
+	DefaultLimit = 50
+	MaxLimit     = 500
+)
+
+type Repo interface {
+	Add(ctx context.Context, e *audit.Event) (int64, error)
+	List(ctx context.Context, f *audit.Filter) ([]*audit.Event, error)
+}
+
+type Manager struct {
+	repo Repo
+}
+
+func NewManager(repo Repo) *Manager {
+	return &Manager{repo: repo}
+}
+
+// Record stores the event enriched with the request metadata from ctx.
+// Failures are logged but not returned: a broken audit log must not break the audited operation.
+func (m *Manager) Record(ctx context.Context, e *audit.Event) {
+	if e.ActorID == nil {
+		if sess := session.FromContext(ctx); sess != nil && !sess.ClientOnly() {
+			e.ActorID = audit.UserRef(sess.UserID)
+		}
+	}
+	e.RequestID = middleware.RequestIDFromContext(ctx)
+	e.IP = middleware.ClientIPFromContext(ctx)
+	e.UserAgent = middleware.UserAgentFromContext(ctx)
+
+	id, err := m.repo.Add(ctx, e)
+	if err != nil {
+		log.Clog(ctx).Error("Error while recording audit event", log.Fields{"action": e.Action, "error": err.Error()})
+		return
+	}
+	e.ID = id
+}
+
+func (m *Manager) List(ctx context.Context, f *audit.Filter) ([]*audit.Event, error) {
+	if f.Limit <= 0 {
+		f.Limit = DefaultLimit
+	}
+	if f.Limit > MaxLimit {
+		f.Limit = MaxLimit
+	}
+	items, err := m.repo.List(ctx, f)
+	if err != nil {
+		log.Clog(ctx).Error("Error while listing audit events", log.Fields{"error": err.Error()})
+		return nil, fmt.Errorf("list audit events: %w", err)
+	}
+	return items, nil
+}
+
+// UserEvents returns every event where the user is the actor or the target.
+// The ip and user agent are the ones of the actor, they are cleared in the events made by someone else,
+// e.g. an admin or a provisioning client.
+func (m *Manager) UserEvents(ctx context.Context, userID uint32) ([]*audit.Event, error) {
+	items, err := m.repo.List(ctx, &audit.Filter{UserID: userID})
+	if err != nil {
+		log.Clog(ctx).Error("Error while listing user audit events", log.Fields{"userId": userID, "error": err.Error()})
+		return nil, fmt.Errorf("list user audit events: %w", err)
+	}
+	for _, e := range items {
+		if e.ActorID == nil || *e.ActorID != userID {
+			e.IP, e.UserAgent = "", ""
+		}
+	}
+	return items, nil
+}
@@ -0,0 +1,39 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Ollub/user_service/internal/audit"
+)
+
+type listRepo struct {
+	Repo
+	events []*audit.Event
+}
+
+func (r *listRepo) List(context.Context, *audit.Filter) ([]*audit.Event, error) {
+	return r.events, nil
+}
+
+// The data export of the user must not disclose the ip and user agent of the admins acting on the user.
+func TestUserEventsHideOtherActors(t *testing.T) {
+	repo := &listRepo{events: []*audit.Event{
+		{ActorID: audit.UserRef(1), TargetID: audit.UserRef(1), IP: "10.0.0.1", UserAgent: "user"},
+		{ActorID: audit.UserRef(2), TargetID: audit.UserRef(1), IP: "10.0.0.2", UserAgent: "admin"},
+		{TargetID: audit.UserRef(1), IP: "10.0.0.3", UserAgent: "scim"},
+	}}
+
+	items, err := NewManager(repo).UserEvents(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items[0].IP != "10.0.0.1" || items[0].UserAgent != "user" {
+		t.Errorf("own event: got ip %q and user agent %q", items[0].IP, items[0].UserAgent)
+	}
+	for _, e := range items[1:] {
+		if e.IP != "" || e.UserAgent != "" {
+			t.Errorf("event of another actor: got ip %q and user agent %q", e.IP, e.UserAgent)
+		}
+	}
+}
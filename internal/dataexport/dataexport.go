@@ -0,0 +1,48 @@
+package dataexport
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	StatusPending = "pending"
+	StatusRunning = "running"
+	StatusReady   = "ready"
+	StatusFailed  = "failed"
+)
+
+// Job is a single data subject access request of a user.
+type Job struct {
+	ID         uint32     `json:"id"`
+	UserID     uint32     `json:"-"`
+	Status     string     `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+}
+
+// Section collects one part of the user data, it is stored in the archive as <Name>.json.
+type Section interface {
+	Name() string
+	Collect(ctx context.Context, userID uint32) (interface{}, error)
+}
+
+type sectionFunc struct {
+	name string
+	fn   func(ctx context.Context, userID uint32) (interface{}, error)
+}
+
+// SectionFunc adapts a plain function to the Section interface.
+func SectionFunc(name string, fn func(ctx context.Context, userID uint32) (interface{}, error)) Section {
+	return &sectionFunc{name: name, fn: fn}
+}
+
+func (s *sectionFunc) Name() string {
+	return s.name
+}
+
+func (s *sectionFunc) Collect(ctx context.Context, userID uint32) (interface{}, error) {
+	return s.fn(ctx, userID)
+}
@@ -0,0 +1,99 @@
+package delivery
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Ollub/user_service/internal/dataexport"
+	"github.com/Ollub/user_service/internal/dataexport/usecase"
+	"github.com/Ollub/user_service/internal/session"
+	"github.com/Ollub/user_service/pkg/utils/http_utils"
+	"github.com/gorilla/mux"
+)
+
+// CodeExportNotReady is returned for archives of exports which are not completed or already expired.
+const CodeExportNotReady = "export_not_ready"
+
+type Handler struct {
+	exports *usecase.Manager
+}
+
+func NewHandler(exportManager *usecase.Manager) *Handler {
+	return &Handler{exportManager}
+}
+
+type ListJobsResp struct {
+	Exports []*dataexport.Job `json:"exports"`
+}
+
+// Create schedules a new export of the current user data.
+func (h *Handler) Create(w http.ResponseWriter, r *http.Request) {
+	sess := session.FromContext(r.Context())
+	job, err := h.exports.Request(r.Context(), sess.UserID)
+	if err != nil {
+		http_utils.HttpError(w, r, http_utils.CodeInternal, "Internal error while requesting data export", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/me/data-export/%d", job.ID))
+	http_utils.JsonResp(w, job, http.StatusAccepted)
+}
+
+func (h *Handler) List(w http.ResponseWriter, r *http.Request) {
+	sess := session.FromContext(r.Context())
+	items, err := h.exports.List(r.Context(), sess.UserID)
+	if err != nil {
+		http_utils.HttpError(w, r, http_utils.CodeInternal, "Internal error while listing data exports", http.StatusInternalServerError)
+		return
+	}
+	http_utils.JsonResp(w, ListJobsResp{items}, http.StatusOK)
+}
+
+func (h *Handler) Status(w http.ResponseWriter, r *http.Request) {
+	jobId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		fieldErr := http_utils.NewFieldError("id", http_utils.FieldInvalid, "should be an integer")
+		http_utils.ValidationError(w, r, []*http_utils.FieldError{fieldErr}, http.StatusBadRequest)
+		return
+	}
+	sess := session.FromContext(r.Context())
+	job, err := h.exports.Get(r.Context(), sess.UserID, uint32(jobId))
+	if err == usecase.JobNotFoundError {
+		http_utils.HttpError(w, r, http_utils.CodeNotFound, "Data export not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http_utils.HttpError(w, r, http_utils.CodeInternal, "Internal error while retrieving data export", http.StatusInternalServerError)
+		return
+	}
+	http_utils.JsonResp(w, job, http.StatusOK)
+}
+
+func (h *Handler) Download(w http.ResponseWriter, r *http.Request) {
+	jobId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		fieldErr := http_utils.NewFieldError("id", http_utils.FieldInvalid, "should be an integer")
+		http_utils.ValidationError(w, r, []*http_utils.FieldError{fieldErr}, http.StatusBadRequest)
+		return
+	}
+	sess := session.FromContext(r.Context())
+	archive, err := h.exports.Archive(r.Context(), sess.UserID, uint32(jobId))
+	switch err {
+	case nil:
+		// all is ok
+	case usecase.JobNotFoundError:
+		http_utils.HttpError(w, r, http_utils.CodeNotFound, "Data export not found", http.StatusNotFound)
+	case usecase.ArchiveNotReadyError:
+		http_utils.HttpError(w, r, CodeExportNotReady, "Data export is not ready or expired", http.StatusConflict)
+	default:
+		http_utils.HttpError(w, r, http_utils.CodeInternal, "Internal error while retrieving data export", http.StatusInternalServerError)
+	}
+	if err != nil {
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="data-export-%d.zip"`, jobId))
+	w.Header().Set("Content-Length", strconv.Itoa(len(archive)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(archive)
+}
@@ -0,0 +1,191 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Ollub/user_service/internal/dataexport"
+)
+
+type RepoPgx struct {
+	DB *sql.DB
+}
+
+func NewPgRepository(db *sql.DB) *RepoPgx {
+	return &RepoPgx{DB: db}
+}
+
+const jobColumns = `id, user_id, status, error, created_at, finished_at, expires_at`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanJob(row scanner) (*dataexport.Job, error) {
+	j := &dataexport.Job{}
+	var finishedAt, expiresAt sql.NullTime
+	err := row.Scan(&j.ID, &j.UserID, &j.Status, &j.Error, &j.CreatedAt, &finishedAt, &expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if finishedAt.Valid {
+		j.FinishedAt = &finishedAt.Time
+	}
+	if expiresAt.Valid {
+		j.ExpiresAt = &expiresAt.Time
+	}
+	return j, nil
+}
+
+func (repo *RepoPgx) Add(ctx context.Context, userID uint32) (*dataexport.Job, error) {
+	row := repo.DB.QueryRowContext(
+		ctx,
+		`INSERT INTO data_exports (user_id, status) VALUES ($1, $2) RETURNING `+jobColumns,
+		userID,
+		dataexport.StatusPending,
+	)
+	return scanJob(row)
+}
+
+// GetActive returns a pending or running job of the user if any.
+func (repo *RepoPgx) GetActive(ctx context.Context, userID uint32) (*dataexport.Job, error) {
+	row := repo.DB.QueryRowContext(
+		ctx,
+		`SELECT `+jobColumns+` FROM data_exports WHERE user_id = $1 AND status IN ($2, $3) ORDER BY id DESC LIMIT 1`,
+		userID,
+		dataexport.StatusPending,
+		dataexport.StatusRunning,
+	)
+	j, err := scanJob(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return j, err
+}
+
+func (repo *RepoPgx) GetByID(ctx context.Context, userID, id uint32) (*dataexport.Job, error) {
+	row := repo.DB.QueryRowContext(
+		ctx,
+		`SELECT `+jobColumns+` FROM data_exports WHERE id = $1 AND user_id = $2`,
+		id,
+		userID,
+	)
+	j, err := scanJob(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return j, err
+}
+
+func (repo *RepoPgx) ListByUser(ctx context.Context, userID uint32) ([]*dataexport.Job, error) {
+	items := []*dataexport.Job{}
+	rows, err := repo.DB.QueryContext(
+		ctx,
+		`SELECT `+jobColumns+` FROM data_exports WHERE user_id = $1 ORDER BY id DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, j)
+	}
+	return items, rows.Err()
+}
+
+// GetArchive returns the archive of a ready job, nil if there is nothing to download.
+func (repo *RepoPgx) GetArchive(ctx context.Context, userID, id uint32) ([]byte, error) {
+	var archive []byte
+	err := repo.DB.QueryRowContext(
+		ctx,
+		`SELECT archive FROM data_exports WHERE id = $1 AND user_id = $2 AND status = $3 AND expires_at > now()`,
+		id,
+		userID,
+		dataexport.StatusReady,
+	).Scan(&archive)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return archive, err
+}
+
+// ClaimPending marks the oldest pending job as running and returns it.
+// SKIP LOCKED allows several service replicas to process the queue concurrently.
+func (repo *RepoPgx) ClaimPending(ctx context.Context) (*dataexport.Job, error) {
+	row := repo.DB.QueryRowContext(
+		ctx,
+		`UPDATE data_exports SET status = $1, started_at = now(), attempts = attempts + 1 WHERE id = (`+
+			`SELECT id FROM data_exports WHERE status = $2 ORDER BY created_at LIMIT 1 FOR UPDATE SKIP LOCKED`+
+			`) RETURNING `+jobColumns,
+		dataexport.StatusRunning,
+		dataexport.StatusPending,
+	)
+	j, err := scanJob(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return j, err
+}
+
+func (repo *RepoPgx) Finish(ctx context.Context, id uint32, archive []byte, expiresAt time.Time) error {
+	_, err := repo.DB.ExecContext(
+		ctx,
+		`UPDATE data_exports SET status = $1, archive = $2, finished_at = now(), expires_at = $3 WHERE id = $4`,
+		dataexport.StatusReady,
+		archive,
+		expiresAt,
+		id,
+	)
+	return err
+}
+
+func (repo *RepoPgx) Fail(ctx context.Context, id uint32, reason string) error {
+	_, err := repo.DB.ExecContext(
+		ctx,
+		`UPDATE data_exports SET status = $1, error = $2, finished_at = now() WHERE id = $3`,
+		dataexport.StatusFailed,
+		reason,
+		id,
+	)
+	return err
+}
+
+// ReclaimStale returns the jobs running since before the time to the queue, they were left by a dead worker.
+// Jobs that already had maxAttempts attempts are failed instead.
+func (repo *RepoPgx) ReclaimStale(ctx context.Context, startedBefore time.Time, maxAttempts int) (int64, error) {
+	result, err := repo.DB.ExecContext(
+		ctx,
+		`UPDATE data_exports SET `+
+			`status = CASE WHEN attempts < $1 THEN $2 ELSE $3 END, `+
+			`error = CASE WHEN attempts < $1 THEN '' ELSE 'export timed out' END, `+
+			`finished_at = CASE WHEN attempts < $1 THEN NULL ELSE now() END `+
+			`WHERE status = $4 AND started_at < $5`,
+		maxAttempts,
+		dataexport.StatusPending,
+		dataexport.StatusFailed,
+		dataexport.StatusRunning,
+		startedBefore,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DeleteExpiredArchives drops archive payloads past their expiry, the job rows are kept as history.
+func (repo *RepoPgx) DeleteExpiredArchives(ctx context.Context) (int64, error) {
+	result, err := repo.DB.ExecContext(
+		ctx,
+		`UPDATE data_exports SET archive = NULL WHERE archive IS NOT NULL AND expires_at <= now()`,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,6 @@
+package usecase
+
+import "errors"
+
+var JobNotFoundError = errors.New("data export job not found")
+var ArchiveNotReadyError = errors.New("data export archive is not ready")
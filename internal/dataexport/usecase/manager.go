@@ -0,0 +1,204 @@
+package usecase
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Ollub/user_service/internal/dataexport"
+	"github.com/Ollub/user_service/pkg/log"
+)
+
+type Repo interface {
+	Add(ctx context.Context, userID uint32) (*dataexport.Job, error)
+	GetActive(ctx context.Context, userID uint32) (*dataexport.Job, error)
+	GetByID(ctx context.Context, userID, id uint32) (*dataexport.Job, error)
+	ListByUser(ctx context.Context, userID uint32) ([]*dataexport.Job, error)
+	GetArchive(ctx context.Context, userID, id uint32) ([]byte, error)
+	ClaimPending(ctx context.Context) (*dataexport.Job, error)
+	Finish(ctx context.Context, id uint32, archive []byte, expiresAt time.Time) error
+	Fail(ctx context.Context, id uint32, reason string) error
+	ReclaimStale(ctx context.Context, startedBefore time.Time, maxAttempts int) (int64, error)
+	DeleteExpiredArchives(ctx context.Context) (int64, error)
+}
+
+// maxAttempts is the number of times a job left running by a dead worker is retried before it fails.
+const maxAttempts = 3
+
+type Manager struct {
+	repo       Repo
+	sections   []dataexport.Section
+	archiveTTL time.Duration
+	// Jobs running longer than that are considered abandoned
+	jobTimeout time.Duration
+}
+
+func NewManager(repo Repo, archiveTTL, jobTimeout time.Duration, sections ...dataexport.Section) *Manager {
+	return &Manager{
+		repo:       repo,
+		sections:   sections,
+		archiveTTL: archiveTTL,
+		jobTimeout: jobTimeout,
+	}
+}
+
+// Request schedules a new export for the user.
+// If the user already has an export in progress it is returned instead.
+func (m *Manager) Request(ctx context.Context, userID uint32) (*dataexport.Job, error) {
+	job, err := m.repo.GetActive(ctx, userID)
+	if err != nil {
+		log.Clog(ctx).Error("Error while checking active export", log.Fields{"userId": userID, "error": err.Error()})
+		return nil, fmt.Errorf("request export: %w", err)
+	}
+	if job != nil {
+		return job, nil
+	}
+	job, err = m.repo.Add(ctx, userID)
+	if err != nil {
+		log.Clog(ctx).Error("Error while creating export", log.Fields{"userId": userID, "error": err.Error()})
+		return nil, fmt.Errorf("request export: %w", err)
+	}
+	log.Clog(ctx).Info("Data export requested", log.Fields{"userId": userID, "jobId": job.ID})
+	return job, nil
+}
+
+func (m *Manager) List(ctx context.Context, userID uint32) ([]*dataexport.Job, error) {
+	items, err := m.repo.ListByUser(ctx, userID)
+	if err != nil {
+		log.Clog(ctx).Error("Error while listing exports", log.Fields{"userId": userID, "error": err.Error()})
+		return nil, fmt.Errorf("list exports: %w", err)
+	}
+	return items, nil
+}
+
+func (m *Manager) Get(ctx context.Context, userID, id uint32) (*dataexport.Job, error) {
+	job, err := m.repo.GetByID(ctx, userID, id)
+	if err != nil {
+		log.Clog(ctx).Error("Error while retrieving export", log.Fields{"jobId": id, "error": err.Error()})
+		return nil, fmt.Errorf("get export: %w", err)
+	}
+	if job == nil {
+		return nil, JobNotFoundError
+	}
+	return job, nil
+}
+
+func (m *Manager) Archive(ctx context.Context, userID, id uint32) ([]byte, error) {
+	if _, err := m.Get(ctx, userID, id); err != nil {
+		return nil, err
+	}
+	archive, err := m.repo.GetArchive(ctx, userID, id)
+	if err != nil {
+		log.Clog(ctx).Error("Error while retrieving export archive", log.Fields{"jobId": id, "error": err.Error()})
+		return nil, fmt.Errorf("get export archive: %w", err)
+	}
+	if archive == nil {
+		return nil, ArchiveNotReadyError
+	}
+	return archive, nil
+}
+
+// Run processes pending exports until ctx is cancelled.
+func (m *Manager) Run(ctx context.Context, pollInterval time.Duration) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		if n, err := m.repo.ReclaimStale(ctx, time.Now().Add(-m.jobTimeout), maxAttempts); err != nil {
+			log.Error("Error while reclaiming stale export jobs", log.Fields{"error": err.Error()})
+		} else if n > 0 {
+			log.Info("Stale export jobs reclaimed", log.Fields{"count": n})
+		}
+		for m.processNext(ctx) {
+		}
+		if n, err := m.repo.DeleteExpiredArchives(ctx); err != nil {
+			log.Error("Error while deleting expired export archives", log.Fields{"error": err.Error()})
+		} else if n > 0 {
+			log.Info("Expired export archives deleted", log.Fields{"count": n})
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// processNext builds one pending export, it returns false when the queue is empty.
+func (m *Manager) processNext(ctx context.Context) bool {
+	job, err := m.repo.ClaimPending(ctx)
+	if err != nil {
+		log.Error("Error while claiming export job", log.Fields{"error": err.Error()})
+		return false
+	}
+	if job == nil {
+		return false
+	}
+
+	logger := log.New(log.Fields{"jobId": job.ID, "userId": job.UserID})
+	archive, err := m.build(ctx, job)
+	if err != nil {
+		logger.Error("Data export failed", log.Fields{"error": err.Error()})
+		if err := m.repo.Fail(ctx, job.ID, "export failed"); err != nil {
+			logger.Error("Error while marking export failed", log.Fields{"error": err.Error()})
+		}
+		return true
+	}
+	if err := m.repo.Finish(ctx, job.ID, archive, time.Now().Add(m.archiveTTL)); err != nil {
+		logger.Error("Error while storing export archive", log.Fields{"error": err.Error()})
+		// otherwise the job stays running and blocks new exports of the user until it is reclaimed
+		if err := m.repo.Fail(ctx, job.ID, "export failed"); err != nil {
+			logger.Error("Error while marking export failed", log.Fields{"error": err.Error()})
+		}
+		return true
+	}
+	logger.Info("Data export ready", log.Fields{"size": len(archive)})
+	return true
+}
+
+type manifest struct {
+	UserID      uint32    `json:"userId"`
+	GeneratedAt time.Time `json:"generatedAt"`
+	Files       []string  `json:"files"`
+}
+
+func (m *Manager) build(ctx context.Context, job *dataexport.Job) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+
+	mf := manifest{UserID: job.UserID, GeneratedAt: time.Now().UTC()}
+	for _, s := range m.sections {
+		data, err := s.Collect(ctx, job.UserID)
+		if err != nil {
+			return nil, fmt.Errorf("collect %s: %w", s.Name(), err)
+		}
+		name := s.Name() + ".json"
+		if err := writeJSON(zw, name, data); err != nil {
+			return nil, err
+		}
+		mf.Files = append(mf.Files, name)
+	}
+	if err := writeJSON(zw, "manifest.json", mf); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeJSON(zw *zip.Writer, name string, v interface{}) error {
+	f, err := zw.Create(name)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", name, err)
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return fmt.Errorf("write %s: %w", name, err)
+	}
+	return nil
+}
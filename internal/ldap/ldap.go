@@ -0,0 +1,145 @@
+package ldap
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/Ollub/user_service/internal/users"
+	user_usecase "github.com/Ollub/user_service/internal/users/usecase"
+	ldapv3 "github.com/go-ldap/ldap/v3"
+)
+
+// Name is the provider recorded in the audit events of the users signing in with LDAP.
+const Name = "ldap"
+
+type Config struct {
+	// Directory url, e.g. ldaps://ldap.example.com:636, empty disables the LDAP login
+	URL string `envconfig:"LDAP_URL"`
+	// Upgrade a plain ldap:// connection with StartTLS
+	StartTLS bool `envconfig:"LDAP_START_TLS" default:"false"`
+	// Skip the verification of the server certificate, for tests only
+	InsecureSkipVerify bool `envconfig:"LDAP_INSECURE_SKIP_VERIFY" default:"false"`
+	// Service account the users are searched with, the search is anonymous if empty
+	BindDN       string `envconfig:"LDAP_BIND_DN"`
+	BindPassword string `envconfig:"LDAP_BIND_PASSWORD"`
+	SearchBase   string `envconfig:"LDAP_SEARCH_BASE"`
+	// Filter of the user entry, %s is replaced with the escaped email.
+	// Active Directory: (&(objectClass=user)(userPrincipalName=%s))
+	Filter string `envconfig:"LDAP_FILTER" default:"(&(objectClass=person)(mail=%s))"`
+	// Attributes synced to the first and last name of the user on every login
+	FirstNameAttr string        `envconfig:"LDAP_FIRST_NAME_ATTR" default:"givenName"`
+	LastNameAttr  string        `envconfig:"LDAP_LAST_NAME_ATTR" default:"sn"`
+	Timeout       time.Duration `envconfig:"LDAP_TIMEOUT" default:"5s"`
+}
+
+// Authenticator checks passwords by binding to the directory as the user entry found by the email.
+type Authenticator struct {
+	cfg *Config
+}
+
+func NewAuthenticator(cfg *Config) *Authenticator {
+	return &Authenticator{cfg: cfg}
+}
+
+func (a *Authenticator) Name() string {
+	return Name
+}
+
+// Authenticate returns the profile of the directory entry with the email, nil if there is none,
+// or user_usecase.BadPasswordError if the directory rejects the password.
+func (a *Authenticator) Authenticate(ctx context.Context, email, pass string) (*users.UserIn, error) {
+	// an empty password is an unauthenticated bind, which many servers accept for any dn
+	if pass == "" {
+		return nil, user_usecase.BadPasswordError
+	}
+	conn, err := a.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if a.cfg.BindDN != "" {
+		if err := conn.Bind(a.cfg.BindDN, a.cfg.BindPassword); err != nil {
+			return nil, fmt.Errorf("ldap service bind: %w", err)
+		}
+	}
+	entry, err := a.find(conn, email)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	if err := conn.Bind(entry.DN, pass); err != nil {
+		if ldapv3.IsErrorWithCode(err, ldapv3.LDAPResultInvalidCredentials) {
+			return nil, user_usecase.BadPasswordError
+		}
+		return nil, fmt.Errorf("ldap user bind: %w", err)
+	}
+	return &users.UserIn{
+		Email:     email,
+		FirstName: entry.GetAttributeValue(a.cfg.FirstNameAttr),
+		LastName:  entry.GetAttributeValue(a.cfg.LastNameAttr),
+	}, nil
+}
+
+func (a *Authenticator) dial(ctx context.Context) (*ldapv3.Conn, error) {
+	timeout := a.cfg.Timeout
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+		timeout = time.Until(deadline)
+	}
+	u, err := url.Parse(a.cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("ldap url: %w", err)
+	}
+	tlsConfig := &tls.Config{ServerName: u.Hostname(), InsecureSkipVerify: a.cfg.InsecureSkipVerify}
+	conn, err := ldapv3.DialURL(
+		a.cfg.URL,
+		ldapv3.DialWithDialer(&net.Dialer{Timeout: timeout}),
+		ldapv3.DialWithTLSConfig(tlsConfig),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("ldap dial: %w", err)
+	}
+	conn.SetTimeout(timeout)
+	if a.cfg.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ldap start tls: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// find returns the single entry matching the filter, more than one is an error as the password
+// could be checked against the wrong entry.
+func (a *Authenticator) find(conn *ldapv3.Conn, email string) (*ldapv3.Entry, error) {
+	req := ldapv3.NewSearchRequest(
+		a.cfg.SearchBase,
+		ldapv3.ScopeWholeSubtree,
+		ldapv3.NeverDerefAliases,
+		2,
+		int(a.cfg.Timeout.Seconds()),
+		false,
+		fmt.Sprintf(a.cfg.Filter, ldapv3.EscapeFilter(email)),
+		[]string{a.cfg.FirstNameAttr, a.cfg.LastNameAttr},
+		nil,
+	)
+	res, err := conn.Search(req)
+	if ldapv3.IsErrorWithCode(err, ldapv3.LDAPResultSizeLimitExceeded) {
+		return nil, errors.New("ldap search: more than one entry matches the email")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ldap search: %w", err)
+	}
+	switch len(res.Entries) {
+	case 0:
+		return nil, nil
+	case 1:
+		return res.Entries[0], nil
+	default:
+		return nil, errors.New("ldap search: more than one entry matches the email")
+	}
+}
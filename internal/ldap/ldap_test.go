@@ -0,0 +1,171 @@
+package ldap
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ollub/user_service/internal/audit"
+	"github.com/Ollub/user_service/internal/users"
+	user_usecase "github.com/Ollub/user_service/internal/users/usecase"
+	"github.com/Ollub/user_service/pkg/utils/password"
+)
+
+const (
+	serviceDN   = "cn=service,dc=example,dc=com"
+	servicePass = "service-secret"
+)
+
+// userRepo keeps the users in memory, the methods the tests don't need panic on the nil Repo.
+type userRepo struct {
+	user_usecase.Repo
+	users map[string]*users.User
+}
+
+func (r *userRepo) Add(_ context.Context, u *users.User) (int64, error) {
+	stored := *u
+	stored.ID = uint32(len(r.users) + 1)
+	r.users[u.Email] = &stored
+	u.ID = stored.ID
+	return int64(stored.ID), nil
+}
+
+func (r *userRepo) GetByEmail(_ context.Context, email string) (*users.User, error) {
+	u, ok := r.users[email]
+	if !ok {
+		return nil, nil
+	}
+	found := *u
+	return &found, nil
+}
+
+func (r *userRepo) Update(_ context.Context, u *users.User) (int64, error) {
+	stored := *u
+	r.users[u.Email] = &stored
+	return 1, nil
+}
+
+type nopAuditor struct{}
+
+func (nopAuditor) Record(context.Context, *audit.Event) {}
+
+var (
+	testPool   = password.NewPool("test_ldap_password_hashing", 1, time.Second)
+	testParams = &password.ArgonParams{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+)
+
+func newTestManager(t *testing.T, repo *userRepo, entries ...*entry) *user_usecase.Manager {
+	srv := newTestServer(t, append(entries, &entry{dn: serviceDN, password: servicePass})...)
+	a := NewAuthenticator(&Config{
+		URL:           srv.URL(),
+		BindDN:        serviceDN,
+		BindPassword:  servicePass,
+		SearchBase:    "dc=example,dc=com",
+		Filter:        "(&(objectClass=person)(mail=%s))",
+		FirstNameAttr: "givenName",
+		LastNameAttr:  "sn",
+		Timeout:       time.Second,
+	})
+	return user_usecase.NewManager(repo, nopAuditor{}, testPool, testParams, 0, 0, a)
+}
+
+func john() *entry {
+	return &entry{
+		dn:       "uid=john,ou=people,dc=example,dc=com",
+		password: "directory-pass",
+		attrs:    map[string]string{"mail": "john@example.com", "givenName": "John", "sn": "Doe"},
+	}
+}
+
+func TestLoginProvisionsAndSyncsProfile(t *testing.T) {
+	repo := &userRepo{users: map[string]*users.User{}}
+	m := newTestManager(t, repo, john())
+
+	u, err := m.CheckPassByEmail(context.Background(), "john@example.com", "directory-pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.ID == 0 || u.FirstName != "John" || u.LastName != "Doe" || u.HasPassword() {
+		t.Fatalf("provisioned user %+v", u)
+	}
+
+	// the name changed in the directory is synced on the next login
+	repo.users["john@example.com"].FirstName = "Johnny"
+	u, err = m.CheckPassByEmail(context.Background(), "john@example.com", "directory-pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.FirstName != "John" || repo.users["john@example.com"].FirstName != "John" {
+		t.Errorf("profile is not synced: %+v", repo.users["john@example.com"])
+	}
+}
+
+func TestLoginWrongPassword(t *testing.T) {
+	repo := &userRepo{users: map[string]*users.User{}}
+	m := newTestManager(t, repo, john())
+
+	for _, pass := range []string{"wrong-pass", ""} {
+		if _, err := m.CheckPassByEmail(context.Background(), "john@example.com", pass); err != user_usecase.BadPasswordError {
+			t.Errorf("password %q: %v, want BadPasswordError", pass, err)
+		}
+	}
+	if len(repo.users) != 0 {
+		t.Errorf("user provisioned on failed login: %v", repo.users)
+	}
+}
+
+// Emails unknown to the directory are checked against the passwords of the service.
+func TestLoginUnknownEmail(t *testing.T) {
+	hash, err := password.GenerateHash("local-pass", testParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := &userRepo{users: map[string]*users.User{
+		"jane@example.com": {ID: 1, Email: "jane@example.com", PassHash: hash},
+	}}
+	m := newTestManager(t, repo, john())
+
+	if _, err := m.CheckPassByEmail(context.Background(), "jane@example.com", "local-pass"); err != nil {
+		t.Errorf("local password: %v", err)
+	}
+	if _, err := m.CheckPassByEmail(context.Background(), "jane@example.com", "directory-pass"); err != user_usecase.BadPasswordError {
+		t.Errorf("wrong local password: %v, want BadPasswordError", err)
+	}
+	if _, err := m.CheckPassByEmail(context.Background(), "nobody@example.com", "local-pass"); err != user_usecase.UserNotFoundError {
+		t.Errorf("unknown email: %v, want UserNotFoundError", err)
+	}
+	if len(repo.users) != 1 {
+		t.Errorf("unknown email provisioned: %v", repo.users)
+	}
+}
+
+func TestLoginMoreThanOneMatch(t *testing.T) {
+	repo := &userRepo{users: map[string]*users.User{}}
+	twin := john()
+	twin.dn = "uid=john2,ou=people,dc=example,dc=com"
+	m := newTestManager(t, repo, john(), twin)
+
+	_, err := m.CheckPassByEmail(context.Background(), "john@example.com", "directory-pass")
+	if err == nil || !strings.Contains(err.Error(), "more than one entry") {
+		t.Errorf("error %v, want more than one entry", err)
+	}
+	if len(repo.users) != 0 {
+		t.Errorf("user provisioned on ambiguous login: %v", repo.users)
+	}
+}
+
+func TestLoginDisabledUser(t *testing.T) {
+	repo := &userRepo{users: map[string]*users.User{
+		"john@example.com": {ID: 1, Email: "john@example.com", FirstName: "John", LastName: "Doe", Disabled: true},
+	}}
+	m := newTestManager(t, repo, john())
+
+	if _, err := m.CheckPassByEmail(context.Background(), "john@example.com", "directory-pass"); err != user_usecase.UserDisabledError {
+		t.Errorf("error %v, want UserDisabledError", err)
+	}
+	// the password is checked first, a disabled user can't be probed for it
+	if _, err := m.CheckPassByEmail(context.Background(), "john@example.com", "wrong-pass"); err != user_usecase.BadPasswordError {
+		t.Errorf("error %v, want BadPasswordError", err)
+	}
+}
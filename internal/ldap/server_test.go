@@ -0,0 +1,145 @@
+package ldap
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	ldapv3 "github.com/go-ldap/ldap/v3"
+)
+
+// entry is a user of the test directory.
+type entry struct {
+	dn       string
+	password string
+	attrs    map[string]string
+}
+
+// testServer is a minimal in-process LDAP server, it supports simple binds and searches by the mail attribute.
+type testServer struct {
+	t       *testing.T
+	lis     net.Listener
+	entries []*entry
+	wg      sync.WaitGroup
+}
+
+func newTestServer(t *testing.T, entries ...*entry) *testServer {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testServer{t: t, lis: lis, entries: entries}
+	s.wg.Add(1)
+	go s.serve()
+	t.Cleanup(func() {
+		lis.Close()
+		s.wg.Wait()
+	})
+	return s
+}
+
+func (s *testServer) URL() string {
+	return "ldap://" + s.lis.Addr().String()
+}
+
+func (s *testServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.lis.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *testServer) handle(conn net.Conn) {
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id, _ := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+		var responses []*ber.Packet
+		switch op.Tag {
+		case ldapv3.ApplicationBindRequest:
+			dn := op.Children[1].Data.String()
+			pass := op.Children[2].Data.String()
+			responses = append(responses, result(ldapv3.ApplicationBindResponse, s.bind(dn, pass)))
+		case ldapv3.ApplicationSearchRequest:
+			filter, err := ldapv3.DecompileFilter(op.Children[6])
+			if err != nil {
+				s.t.Errorf("search filter: %v", err)
+				return
+			}
+			for _, e := range s.search(filter) {
+				responses = append(responses, e.packet())
+			}
+			responses = append(responses, result(ldapv3.ApplicationSearchResultDone, ldapv3.LDAPResultSuccess))
+		case ldapv3.ApplicationUnbindRequest:
+			return
+		default:
+			s.t.Errorf("unexpected ldap operation %d", op.Tag)
+			return
+		}
+		for _, resp := range responses {
+			msg := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+			msg.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "Message ID"))
+			msg.AppendChild(resp)
+			if _, err := conn.Write(msg.Bytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *testServer) bind(dn, pass string) uint16 {
+	for _, e := range s.entries {
+		if e.dn == dn && e.password == pass {
+			return ldapv3.LDAPResultSuccess
+		}
+	}
+	return ldapv3.LDAPResultInvalidCredentials
+}
+
+func (s *testServer) search(filter string) []*entry {
+	var found []*entry
+	for _, e := range s.entries {
+		if strings.Contains(filter, "(mail="+ldapv3.EscapeFilter(e.attrs["mail"])+")") {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+func (e *entry) packet() *ber.Packet {
+	p := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldapv3.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.dn, "Object Name"))
+	attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for name, value := range e.attrs {
+		attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+		attr.AppendChild(values)
+		attrs.AppendChild(attr)
+	}
+	p.AppendChild(attrs)
+	return p
+}
+
+func result(tag ber.Tag, code uint16) *ber.Packet {
+	p := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "Result Code"))
+	p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	return p
+}
@@ -0,0 +1,28 @@
+package lockout
+
+import (
+	"strings"
+	"time"
+)
+
+type Config struct {
+	// Failures allowed without any delay
+	FreeAttempts int `envconfig:"LOGIN_FREE_ATTEMPTS" default:"3"`
+	// Delay after the first failure above FreeAttempts, doubled with every next one
+	BackoffBase time.Duration `envconfig:"LOGIN_BACKOFF_BASE" default:"1s"`
+	BackoffMax  time.Duration `envconfig:"LOGIN_BACKOFF_MAX" default:"5m"`
+	// Failures after which the account or ip is locked for LockoutDuration
+	AccountThreshold int           `envconfig:"LOGIN_ACCOUNT_LOCKOUT_THRESHOLD" default:"10"`
+	IPThreshold      int           `envconfig:"LOGIN_IP_LOCKOUT_THRESHOLD" default:"50"`
+	LockoutDuration  time.Duration `envconfig:"LOGIN_LOCKOUT_DURATION" default:"15m"`
+	// Counters are reset when there were no failures during this window
+	Window time.Duration `envconfig:"LOGIN_FAILURE_WINDOW" default:"1h"`
+}
+
+func AccountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
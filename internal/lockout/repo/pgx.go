@@ -0,0 +1,90 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type RepoPgx struct {
+	DB *sql.DB
+}
+
+func NewPgRepository(db *sql.DB) *RepoPgx {
+	return &RepoPgx{DB: db}
+}
+
+// Reserve counts a login attempt of the key as failed before the password is checked, so parallel attempts
+// can't all pass before the first failure is counted. A blocked key gets its block expiration and nothing
+// is counted, otherwise the key is blocked for delay(failures) after the counted attempt.
+// The counter starts over if the previous failure happened before windowStart.
+func (repo *RepoPgx) Reserve(
+	ctx context.Context,
+	key string,
+	windowStart time.Time,
+	delay func(failures int) time.Duration,
+) (blockedUntil time.Time, failures int, err error) {
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	defer tx.Rollback()
+
+	// the row has to exist to be locked by the concurrent attempts
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO login_throttles (key, failures, last_failure_at) VALUES ($1, 0, now()) ON CONFLICT (key) DO NOTHING`,
+		key,
+	)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	var lastFailureAt time.Time
+	var blocked sql.NullTime
+	err = tx.QueryRowContext(
+		ctx,
+		`SELECT failures, last_failure_at, blocked_until FROM login_throttles WHERE key = $1 FOR UPDATE`,
+		key,
+	).Scan(&failures, &lastFailureAt, &blocked)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	now := time.Now()
+	if blocked.Valid && blocked.Time.After(now) {
+		return blocked.Time, failures, nil
+	}
+
+	if lastFailureAt.Before(windowStart) {
+		failures = 0
+	}
+	failures++
+	var until sql.NullTime
+	if d := delay(failures); d > 0 {
+		until = sql.NullTime{Time: now.Add(d), Valid: true}
+	}
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE login_throttles SET failures = $1, last_failure_at = now(), blocked_until = $2 WHERE key = $3`,
+		failures,
+		until,
+		key,
+	)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	return time.Time{}, failures, tx.Commit()
+}
+
+// Release takes back an attempt counted by Reserve which turned out not to be a failure.
+func (repo *RepoPgx) Release(ctx context.Context, key string) error {
+	_, err := repo.DB.ExecContext(ctx, `UPDATE login_throttles SET failures = GREATEST(failures - 1, 0) WHERE key = $1`, key)
+	return err
+}
+
+func (repo *RepoPgx) Delete(ctx context.Context, key string) (int64, error) {
+	result, err := repo.DB.ExecContext(ctx, `DELETE FROM login_throttles WHERE key = $1`, key)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
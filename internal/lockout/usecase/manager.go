@@ -0,0 +1,142 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Ollub/user_service/internal/audit"
+	"github.com/Ollub/user_service/internal/lockout"
+	"github.com/Ollub/user_service/internal/middleware"
+	"github.com/Ollub/user_service/pkg/log"
+)
+
+type Repo interface {
+	Reserve(ctx context.Context, key string, windowStart time.Time, delay func(failures int) time.Duration) (time.Time, int, error)
+	Release(ctx context.Context, key string) error
+	Delete(ctx context.Context, key string) (int64, error)
+}
+
+type Auditor interface {
+	Record(ctx context.Context, e *audit.Event)
+}
+
+// Manager throttles failed logins per account and per client ip.
+type Manager struct {
+	repo    Repo
+	auditor Auditor
+	cfg     *lockout.Config
+}
+
+func NewManager(repo Repo, auditor Auditor, cfg *lockout.Config) *Manager {
+	return &Manager{
+		repo:    repo,
+		auditor: auditor,
+		cfg:     cfg,
+	}
+}
+
+// Begin reserves a login attempt for the email from the current client ip. The attempt is counted as failed
+// until it is reported with Succeed or Release, so parallel attempts are throttled like sequential ones.
+// It returns for how long the attempts are blocked, zero means the attempt is allowed.
+func (m *Manager) Begin(ctx context.Context, email string) (time.Duration, error) {
+	accountKey := lockout.AccountKey(email)
+	until, err := m.reserve(ctx, accountKey, m.cfg.AccountThreshold, email)
+	if err != nil {
+		return 0, err
+	}
+	if !until.IsZero() {
+		return time.Until(until), nil
+	}
+	until, err = m.reserve(ctx, lockout.IPKey(middleware.ClientIPFromContext(ctx)), m.cfg.IPThreshold, email)
+	if err != nil || !until.IsZero() {
+		m.release(ctx, accountKey)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !until.IsZero() {
+		return time.Until(until), nil
+	}
+	return 0, nil
+}
+
+// reserve counts the attempt of the key and blocks the next ones with the backoff or the lockout.
+// It returns the block expiration if the key is already blocked.
+func (m *Manager) reserve(ctx context.Context, key string, threshold int, email string) (time.Time, error) {
+	delay := func(failures int) time.Duration {
+		d := m.backoff(failures)
+		if failures >= threshold && d < m.cfg.LockoutDuration {
+			d = m.cfg.LockoutDuration
+		}
+		return d
+	}
+	until, failures, err := m.repo.Reserve(ctx, key, time.Now().Add(-m.cfg.Window), delay)
+	if err != nil {
+		log.Clog(ctx).Error("Error while reserving login attempt", log.Fields{"key": key, "error": err.Error()})
+		return time.Time{}, fmt.Errorf("reserve login attempt: %w", err)
+	}
+	if !until.IsZero() {
+		return until, nil
+	}
+	if failures >= threshold {
+		lockedFor := delay(failures)
+		log.Clog(ctx).Warn("Login locked", log.Fields{"key": key, "failures": failures, "for": lockedFor.String()})
+		m.auditor.Record(ctx, &audit.Event{
+			Action:  audit.ActionLoginLocked,
+			Details: map[string]interface{}{"key": key, "email": email, "failures": failures, "until": time.Now().Add(lockedFor)},
+		})
+	}
+	return time.Time{}, nil
+}
+
+func (m *Manager) release(ctx context.Context, key string) {
+	if err := m.repo.Release(ctx, key); err != nil {
+		log.Clog(ctx).Error("Error while releasing login attempt", log.Fields{"key": key, "error": err.Error()})
+	}
+}
+
+// backoff doubles the delay with every failure above the free ones.
+func (m *Manager) backoff(failures int) time.Duration {
+	extra := failures - m.cfg.FreeAttempts
+	if extra <= 0 {
+		return 0
+	}
+	delay := m.cfg.BackoffBase
+	for i := 1; i < extra && delay < m.cfg.BackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > m.cfg.BackoffMax {
+		delay = m.cfg.BackoffMax
+	}
+	return delay
+}
+
+// Succeed resets the account counter. Only the attempt reserved by Begin is taken back from the ip counter,
+// otherwise an attacker could reset it with logins to own account.
+func (m *Manager) Succeed(ctx context.Context, email string) {
+	if _, err := m.repo.Delete(ctx, lockout.AccountKey(email)); err != nil {
+		log.Clog(ctx).Error("Error while resetting login throttle", log.Fields{"email": email, "error": err.Error()})
+	}
+	m.release(ctx, lockout.IPKey(middleware.ClientIPFromContext(ctx)))
+}
+
+// Release takes back the attempt reserved by Begin which was neither a failure nor a success,
+// e.g. the password was right but the user is disabled or the password could not be checked.
+func (m *Manager) Release(ctx context.Context, email string) {
+	m.release(ctx, lockout.AccountKey(email))
+	m.release(ctx, lockout.IPKey(middleware.ClientIPFromContext(ctx)))
+}
+
+// Unlock removes the account lock, it is an administrative action.
+func (m *Manager) Unlock(ctx context.Context, userID uint32, email string) error {
+	if _, err := m.repo.Delete(ctx, lockout.AccountKey(email)); err != nil {
+		log.Clog(ctx).Error("Error while unlocking account", log.Fields{"email": email, "error": err.Error()})
+		return fmt.Errorf("unlock account: %w", err)
+	}
+	m.auditor.Record(ctx, &audit.Event{
+		TargetID: audit.UserRef(userID),
+		Action:   audit.ActionLoginUnlocked,
+	})
+	return nil
+}
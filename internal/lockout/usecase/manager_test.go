@@ -0,0 +1,160 @@
+package usecase
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Ollub/user_service/internal/audit"
+	"github.com/Ollub/user_service/internal/lockout"
+	"github.com/Ollub/user_service/internal/middleware"
+)
+
+type throttle struct {
+	failures      int
+	lastFailureAt time.Time
+	blockedUntil  time.Time
+}
+
+// memoryRepo reserves the attempts under a mutex like the row lock of the postgres repo.
+type memoryRepo struct {
+	mu        sync.Mutex
+	throttles map[string]*throttle
+}
+
+func (r *memoryRepo) Reserve(_ context.Context, key string, windowStart time.Time, delay func(int) time.Duration) (time.Time, int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.throttles[key]
+	if !ok {
+		t = &throttle{lastFailureAt: time.Now()}
+		r.throttles[key] = t
+	}
+	now := time.Now()
+	if t.blockedUntil.After(now) {
+		return t.blockedUntil, t.failures, nil
+	}
+	if t.lastFailureAt.Before(windowStart) {
+		t.failures = 0
+	}
+	t.failures++
+	t.lastFailureAt = now
+	t.blockedUntil = time.Time{}
+	if d := delay(t.failures); d > 0 {
+		t.blockedUntil = now.Add(d)
+	}
+	return time.Time{}, t.failures, nil
+}
+
+func (r *memoryRepo) Release(_ context.Context, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if t, ok := r.throttles[key]; ok && t.failures > 0 {
+		t.failures--
+	}
+	return nil
+}
+
+func (r *memoryRepo) Delete(_ context.Context, key string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.throttles, key)
+	return 1, nil
+}
+
+type nopAuditor struct{}
+
+func (nopAuditor) Record(context.Context, *audit.Event) {}
+
+func newTestManager() (*Manager, *memoryRepo) {
+	repo := &memoryRepo{throttles: map[string]*throttle{}}
+	return NewManager(repo, nopAuditor{}, &lockout.Config{
+		FreeAttempts:     3,
+		BackoffBase:      time.Minute,
+		BackoffMax:       time.Hour,
+		AccountThreshold: 10,
+		IPThreshold:      50,
+		LockoutDuration:  time.Hour,
+		Window:           time.Hour,
+	}), repo
+}
+
+func clientContext(ip string) context.Context {
+	return context.WithValue(context.Background(), middleware.ClientIPKey, ip)
+}
+
+// Parallel attempts sent before any of them failed are throttled like sequential ones.
+func TestBeginParallelAttempts(t *testing.T) {
+	m, _ := newTestManager()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			retryAfter, err := m.Begin(clientContext("1.1.1.1"), "john@doe.com")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if retryAfter == 0 {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	// the free attempts and the one after which the backoff starts
+	if allowed != 4 {
+		t.Errorf("%d parallel attempts allowed, want 4", allowed)
+	}
+}
+
+func TestSucceedReleasesAttempt(t *testing.T) {
+	m, repo := newTestManager()
+	ctx := clientContext("1.1.1.1")
+
+	for i := 0; i < 10; i++ {
+		if retryAfter, err := m.Begin(ctx, "john@doe.com"); err != nil || retryAfter != 0 {
+			t.Fatalf("attempt %d: retry after %v, err %v", i, retryAfter, err)
+		}
+		m.Succeed(ctx, "john@doe.com")
+	}
+	if _, ok := repo.throttles[lockout.AccountKey("john@doe.com")]; ok {
+		t.Error("account counter is not reset")
+	}
+	if n := repo.throttles[lockout.IPKey("1.1.1.1")].failures; n != 0 {
+		t.Errorf("%d ip failures after successful logins", n)
+	}
+
+	// failures of other accounts are kept in the ip counter
+	for i := 0; i < 2; i++ {
+		if _, err := m.Begin(ctx, "jane@doe.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.Begin(ctx, "john@doe.com")
+	m.Succeed(ctx, "john@doe.com")
+	if n := repo.throttles[lockout.IPKey("1.1.1.1")].failures; n != 2 {
+		t.Errorf("%d ip failures, want 2", n)
+	}
+}
+
+func TestBeginBlockedByIP(t *testing.T) {
+	m, repo := newTestManager()
+	ctx := clientContext("1.1.1.1")
+	repo.throttles[lockout.IPKey("1.1.1.1")] = &throttle{failures: 50, lastFailureAt: time.Now(), blockedUntil: time.Now().Add(time.Hour)}
+
+	retryAfter, err := m.Begin(ctx, "john@doe.com")
+	if err != nil || retryAfter <= 0 {
+		t.Fatalf("retry after %v, err %v", retryAfter, err)
+	}
+	// the attempt reserved on the account is taken back
+	if n := repo.throttles[lockout.AccountKey("john@doe.com")].failures; n != 0 {
+		t.Errorf("%d account failures of a blocked attempt", n)
+	}
+}
@@ -0,0 +1,46 @@
+package delivery
+
+import (
+	"net/http"
+
+	"github.com/Ollub/user_service/internal/logins"
+	"github.com/Ollub/user_service/internal/logins/usecase"
+	"github.com/Ollub/user_service/internal/session"
+	"github.com/Ollub/user_service/pkg/utils/http_utils"
+)
+
+type Handler struct {
+	logins *usecase.Manager
+}
+
+func NewHandler(loginManager *usecase.Manager) *Handler {
+	return &Handler{loginManager}
+}
+
+type ListLoginsResp struct {
+	Logins []*logins.Attempt `json:"logins"`
+	Limit  int               `json:"limit"`
+	Offset int               `json:"offset"`
+}
+
+// List returns login attempts of the current user, newest first.
+func (h *Handler) List(w http.ResponseWriter, r *http.Request) {
+	limit, offset, fieldErr := http_utils.PageFromQuery(r)
+	if fieldErr != nil {
+		http_utils.ValidationError(w, r, []*http_utils.FieldError{fieldErr}, http.StatusBadRequest)
+		return
+	}
+	if limit <= 0 {
+		limit = usecase.DefaultLimit
+	}
+	if limit > usecase.MaxLimit {
+		limit = usecase.MaxLimit
+	}
+	sess := session.FromContext(r.Context())
+	items, err := h.logins.List(r.Context(), sess.UserID, limit, offset)
+	if err != nil {
+		http_utils.HttpError(w, r, http_utils.CodeInternal, "Internal error while listing logins", http.StatusInternalServerError)
+		return
+	}
+	http_utils.JsonResp(w, ListLoginsResp{items, limit, offset}, http.StatusOK)
+}
@@ -0,0 +1,22 @@
+package logins
+
+import "time"
+
+const (
+	ReasonUserNotFound = "user_not_found"
+	ReasonBadPassword  = "bad_password"
+	ReasonThrottled    = "throttled"
+	ReasonDisabled     = "disabled"
+)
+
+// Attempt is a single call of the login endpoint.
+type Attempt struct {
+	ID        int64     `json:"id"`
+	UserID    *uint32   `json:"-"`
+	Email     string    `json:"-"`
+	Success   bool      `json:"success"`
+	Reason    string    `json:"reason,omitempty"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"userAgent"`
+	CreatedAt time.Time `json:"createdAt"`
+}
@@ -0,0 +1,74 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/Ollub/user_service/internal/logins"
+)
+
+type RepoPgx struct {
+	DB *sql.DB
+}
+
+func NewPgRepository(db *sql.DB) *RepoPgx {
+	return &RepoPgx{DB: db}
+}
+
+// Add stores the attempt linking it to the user with the same email if any.
+// Successful attempts also update users.last_login_at.
+func (repo *RepoPgx) Add(ctx context.Context, a *logins.Attempt) (int64, error) {
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var lastInsertId int64
+	err = tx.QueryRowContext(
+		ctx,
+		`INSERT INTO login_attempts (user_id, email, success, reason, ip, user_agent) `+
+			`VALUES ((SELECT id FROM users WHERE email = $1), $1, $2, $3, $4, $5) RETURNING id, user_id, created_at`,
+		a.Email,
+		a.Success,
+		a.Reason,
+		a.IP,
+		a.UserAgent,
+	).Scan(&lastInsertId, &a.UserID, &a.CreatedAt)
+	if err != nil {
+		return 0, err
+	}
+	if a.Success && a.UserID != nil {
+		_, err = tx.ExecContext(ctx, `UPDATE users SET last_login_at = $1 WHERE id = $2`, a.CreatedAt, *a.UserID)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return lastInsertId, tx.Commit()
+}
+
+func (repo *RepoPgx) ListByUser(ctx context.Context, userID uint32, limit, offset int) ([]*logins.Attempt, error) {
+	items := []*logins.Attempt{}
+	query := `SELECT id, email, success, reason, ip, user_agent, created_at FROM login_attempts WHERE user_id = $1 ORDER BY id DESC`
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+	if offset > 0 {
+		query += fmt.Sprintf(" OFFSET %d", offset)
+	}
+	rows, err := repo.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		a := &logins.Attempt{UserID: &userID}
+		err = rows.Scan(&a.ID, &a.Email, &a.Success, &a.Reason, &a.IP, &a.UserAgent, &a.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, a)
+	}
+	return items, rows.Err()
+}
@@ -0,0 +1,61 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Ollub/user_service/internal/logins"
+	"github.com/Ollub/user_service/internal/middleware"
+	"github.com/Ollub/user_service/pkg/log"
+)
+
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+type Repo interface {
+	Add(ctx context.Context, a *logins.Attempt) (int64, error)
+	ListByUser(ctx context.Context, userID uint32, limit, offset int) ([]*logins.Attempt, error)
+}
+
+type Manager struct {
+	repo Repo
+}
+
+func NewManager(repo Repo) *Manager {
+	return &Manager{repo: repo}
+}
+
+// Record stores the login attempt with the client ip and user agent from ctx.
+func (m *Manager) Record(ctx context.Context, email string, success bool, reason string) {
+	a := &logins.Attempt{
+		Email:     email,
+		Success:   success,
+		Reason:    reason,
+		IP:        middleware.ClientIPFromContext(ctx),
+		UserAgent: middleware.UserAgentFromContext(ctx),
+	}
+	if _, err := m.repo.Add(ctx, a); err != nil {
+		log.Clog(ctx).Error("Error while recording login attempt", log.Fields{"email": email, "error": err.Error()})
+	}
+}
+
+func (m *Manager) List(ctx context.Context, userID uint32, limit, offset int) ([]*logins.Attempt, error) {
+	items, err := m.repo.ListByUser(ctx, userID, limit, offset)
+	if err != nil {
+		log.Clog(ctx).Error("Error while listing login attempts", log.Fields{"userId": userID, "error": err.Error()})
+		return nil, fmt.Errorf("list login attempts: %w", err)
+	}
+	return items, nil
+}
+
+// History returns all login attempts of the user.
+func (m *Manager) History(ctx context.Context, userID uint32) ([]*logins.Attempt, error) {
+	items, err := m.repo.ListByUser(ctx, userID, 0, 0)
+	if err != nil {
+		log.Clog(ctx).Error("Error while listing login attempts", log.Fields{"userId": userID, "error": err.Error()})
+		return nil, fmt.Errorf("login history: %w", err)
+	}
+	return items, nil
+}
@@ -2,34 +2,73 @@ package middleware
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/Ollub/user_service/internal/session"
+	"github.com/Ollub/user_service/pkg/utils/http_utils"
 )
 
 const AutenticationHeader = "x-authentication-token"
 
+// CodePasswordChangeRequired is returned to restricted sessions for everything but the password change.
+const CodePasswordChangeRequired = "password_change_required"
+
 var (
 	noAuthUrls = map[string]struct{}{
 		"/signup": {},
 		"/login":  {},
+		// authenticated by client credentials
+		"/introspect": {},
+		"/authorize":  {},
+		"/token":      {},
+		// OpenID Connect provider metadata
+		"/.well-known/openid-configuration": {},
+		"/.well-known/jwks.json":            {},
+	}
+	// sign in with external identity providers, /login/{provider} and its callback,
+	// and the SCIM API authenticated by its own tokens
+	noAuthPrefixes = []string{"/login/", "/scim/"}
+	// OAuth resource endpoints, the only ones allowed to tokens issued to OAuth clients
+	oauthUrls = map[string]struct{}{
+		"/userinfo": {},
+	}
+	// urls allowed to sessions restricted to the password change
+	passwordChangeUrls = map[string]struct{}{
+		"/me/password": {},
 	}
 )
 
 func Authentication(sm *session.SessionsJWTVer) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if _, ok := noAuthUrls[r.URL.Path]; ok {
+			if isNoAuth(r.URL.Path) {
 				next.ServeHTTP(w, r)
 				return
 			}
 			token := r.Header.Get(AutenticationHeader)
 			if token == "" {
-				http.Error(w, "Missing authentication header", http.StatusUnauthorized)
+				token = BearerToken(r)
+			}
+			if token == "" {
+				http_utils.HttpError(w, r, http_utils.CodeUnauthorized, "Missing authentication header", http.StatusUnauthorized)
 				return
 			}
 			sess, err := sm.Check(r.Context(), token)
 			if err != nil {
-				http.Error(w, "No auth", http.StatusUnauthorized)
+				http_utils.HttpError(w, r, http_utils.CodeUnauthorized, "No auth", http.StatusUnauthorized)
+				return
+			}
+			if sess.ClientOnly() {
+				http_utils.HttpError(w, r, http_utils.CodeForbidden, "Client tokens can't be used for the user API", http.StatusForbidden)
+				return
+			}
+			// OAuth clients get tokens of the users for the OAuth resource endpoints only
+			if _, ok := oauthUrls[r.URL.Path]; sess.ClientID != "" && !ok {
+				http_utils.HttpError(w, r, http_utils.CodeForbidden, "OAuth tokens can't be used for the user API", http.StatusForbidden)
+				return
+			}
+			if _, ok := passwordChangeUrls[r.URL.Path]; sess.Scope == session.ScopePasswordChange && !ok {
+				http_utils.HttpError(w, r, CodePasswordChangeRequired, "Password change required", http.StatusForbidden)
 				return
 			}
 			ctx := session.ToContext(r.Context(), sess)
@@ -37,3 +76,40 @@ func Authentication(sm *session.SessionsJWTVer) func(http.Handler) http.Handler
 		})
 	}
 }
+
+// RequireRole rejects requests whose session does not have the given role.
+// It must be applied after Authentication.
+func RequireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sess := session.FromContext(r.Context())
+			if sess == nil || sess.Role != role {
+				http_utils.HttpError(w, r, http_utils.CodeForbidden, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// BearerToken returns the token from the `Authorization: Bearer` header, if any.
+func BearerToken(r *http.Request) string {
+	const prefix = "Bearer "
+	auth := r.Header.Get("Authorization")
+	if len(auth) > len(prefix) && strings.EqualFold(auth[:len(prefix)], prefix) {
+		return auth[len(prefix):]
+	}
+	return ""
+}
+
+func isNoAuth(path string) bool {
+	if _, ok := noAuthUrls[path]; ok {
+		return true
+	}
+	for _, prefix := range noAuthPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
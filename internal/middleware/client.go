@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	ClientIPKey  = "clientIP"
+	UserAgentKey = "userAgent"
+)
+
+// SetupClientInfo stores the client ip and user agent in the request context.
+// X-Forwarded-For and X-Real-IP are honoured only behind proxyHops trusted proxies, 0 ignores them,
+// otherwise any client could spoof its address.
+func SetupClientInfo(proxyHops int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), ClientIPKey, clientIP(r, proxyHops))
+			ctx = context.WithValue(ctx, UserAgentKey, r.UserAgent())
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func clientIP(r *http.Request, proxyHops int) string {
+	if proxyHops > 0 {
+		if ip := forwardedClientIP(r.Header.Values("X-Forwarded-For"), proxyHops); ip != "" {
+			return ip
+		}
+		if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+			return strings.TrimSpace(realIP)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// forwardedClientIP returns the address appended to X-Forwarded-For by the outermost of proxyHops trusted proxies.
+// Every proxy appends the address it got the request from, so the entries on the left are sent by the client
+// and can't be trusted.
+func forwardedClientIP(headers []string, proxyHops int) string {
+	var entries []string
+	for _, h := range headers {
+		for _, e := range strings.Split(h, ",") {
+			if e = strings.TrimSpace(e); e != "" {
+				entries = append(entries, e)
+			}
+		}
+	}
+	if len(entries) == 0 {
+		return ""
+	}
+	// fewer entries than proxies: the request came in through an inner proxy, all the entries are theirs
+	if len(entries) < proxyHops {
+		return entries[0]
+	}
+	return entries[len(entries)-proxyHops]
+}
+
+func ClientIPFromContext(ctx context.Context) string {
+	ip, ok := ctx.Value(ClientIPKey).(string)
+	if !ok {
+		return ""
+	}
+	return ip
+}
+
+func UserAgentFromContext(ctx context.Context) string {
+	ua, ok := ctx.Value(UserAgentKey).(string)
+	if !ok {
+		return ""
+	}
+	return ua
+}
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	cases := []struct {
+		name      string
+		forwarded []string
+		realIP    string
+		proxyHops int
+		want      string
+	}{
+		{"untrusted proxy", []string{"1.1.1.1"}, "2.2.2.2", 0, "10.0.0.1"},
+		{"spoofed entry", []string{"6.6.6.6, 1.1.1.1"}, "", 1, "1.1.1.1"},
+		{"two proxies", []string{"6.6.6.6, 1.1.1.1, 10.0.0.2"}, "", 2, "1.1.1.1"},
+		{"repeated header", []string{"6.6.6.6", "1.1.1.1"}, "", 1, "1.1.1.1"},
+		{"fewer entries than proxies", []string{"1.1.1.1"}, "", 2, "1.1.1.1"},
+		{"real ip", nil, "2.2.2.2", 1, "2.2.2.2"},
+		{"no headers", nil, "", 1, "10.0.0.1"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "10.0.0.1:4242"
+		for _, h := range c.forwarded {
+			r.Header.Add("X-Forwarded-For", h)
+		}
+		if c.realIP != "" {
+			r.Header.Set("X-Real-IP", c.realIP)
+		}
+		if got := clientIP(r, c.proxyHops); got != c.want {
+			t.Errorf("%s: client ip %s, want %s", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/Ollub/user_service/internal/session"
+	"github.com/Ollub/user_service/pkg/log"
+	"github.com/Ollub/user_service/pkg/ratelimit"
+	"github.com/Ollub/user_service/pkg/utils/grpc_utils"
+	"github.com/Ollub/user_service/pkg/utils/http_utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// The gRPC interceptors below are the equivalents of the HTTP middlewares, they put the same values into the context.
+
+// metadataValue returns the first value of the incoming metadata key, keys are lower case.
+func metadataValue(ctx context.Context, key string) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func UnaryReqID(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	requestID := metadataValue(ctx, "x-request-id")
+	if requestID == "" {
+		requestID = RandBytesHex(16)
+	}
+	grpc.SetHeader(ctx, metadata.Pairs("x-request-id", requestID))
+	return handler(context.WithValue(ctx, RequestIDKey, requestID), req)
+}
+
+func UnaryInjectLogger(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	logger := log.New()
+	logger.SetContext(log.Fields{"trace-id": RequestIDFromContext(ctx)})
+	return handler(context.WithValue(ctx, log.LoggerKey, logger), req)
+}
+
+// UnaryRecover turns a panic of the handler into codes.Internal, otherwise it would kill the whole process.
+func UnaryRecover(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Clog(ctx).Error(
+				"Panic in gRPC handler",
+				log.Fields{"method": info.FullMethod, "panic": fmt.Sprint(r), "stack": string(debug.Stack())},
+			)
+			resp, err = nil, grpc_utils.Error(ctx, codes.Internal, http_utils.CodeInternal, "Internal error")
+		}
+	}()
+	return handler(ctx, req)
+}
+
+// UnaryClientInfo stores the client ip and user agent in the context, see SetupClientInfo.
+func UnaryClientInfo(proxyHops int) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx = context.WithValue(ctx, ClientIPKey, grpcClientIP(ctx, proxyHops))
+		ctx = context.WithValue(ctx, UserAgentKey, metadataValue(ctx, "user-agent"))
+		return handler(ctx, req)
+	}
+}
+
+func grpcClientIP(ctx context.Context, proxyHops int) string {
+	if proxyHops > 0 {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if ip := forwardedClientIP(md.Get("x-forwarded-for"), proxyHops); ip != "" {
+			return ip
+		}
+		if realIP := metadataValue(ctx, "x-real-ip"); realIP != "" {
+			return strings.TrimSpace(realIP)
+		}
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+func UnaryAccessLog(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+
+	log.Clog(ctx).Info(
+		info.FullMethod,
+		log.Fields{
+			"code":        status.Code(err).String(),
+			"remote_addr": ClientIPFromContext(ctx),
+			"method":      info.FullMethod,
+			"work_time":   time.Since(start),
+		},
+	)
+	return resp, err
+}
+
+// UnaryAuthentication checks the token from the `x-authentication-token` metadata, see Authentication.
+// noAuthMethods are full method names available without a token.
+func UnaryAuthentication(sm *session.SessionsJWTVer, noAuthMethods ...string) grpc.UnaryServerInterceptor {
+	skip := make(map[string]struct{}, len(noAuthMethods))
+	for _, m := range noAuthMethods {
+		skip[m] = struct{}{}
+	}
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if _, ok := skip[info.FullMethod]; ok {
+			return handler(ctx, req)
+		}
+		token := metadataValue(ctx, AutenticationHeader)
+		if token == "" {
+			return nil, grpc_utils.Error(ctx, codes.Unauthenticated, http_utils.CodeUnauthorized, "Missing authentication metadata")
+		}
+		sess, err := sm.Check(ctx, token)
+		if err != nil {
+			return nil, grpc_utils.Error(ctx, codes.Unauthenticated, http_utils.CodeUnauthorized, "No auth")
+		}
+		// the gRPC API is first-party, tokens issued to OAuth clients are for the OAuth resource endpoints
+		if sess.ClientID != "" {
+			return nil, grpc_utils.Error(ctx, codes.PermissionDenied, http_utils.CodeForbidden, "OAuth tokens can't be used for the user API")
+		}
+		// the password can be changed over HTTP only
+		if sess.Scope == session.ScopePasswordChange {
+			return nil, grpc_utils.Error(ctx, codes.PermissionDenied, CodePasswordChangeRequired, "Password change required")
+		}
+		return handler(session.ToContext(ctx, sess), req)
+	}
+}
+
+// UnaryRateLimit is RateLimit for gRPC. Methods with their own limit are keyed by the method and the client ip,
+// the others share the api limit keyed by the user, or the client ip for anonymous calls.
+// Store errors let the call through.
+func UnaryRateLimit(store ratelimit.Store, api ratelimit.Limit, methods map[string]ratelimit.Limit) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		limit, ok := methods[info.FullMethod]
+		var key string
+		switch {
+		case ok:
+			key = "grpc:" + info.FullMethod + "|ip:" + ClientIPFromContext(ctx)
+		case session.FromContext(ctx) != nil:
+			limit, key = api, fmt.Sprintf("user:%d", session.FromContext(ctx).UserID)
+		default:
+			limit, key = api, "ip:"+ClientIPFromContext(ctx)
+		}
+
+		res, err := store.Take(ctx, key, limit)
+		if err != nil {
+			log.Clog(ctx).Error("Rate limit store error", log.Fields{"key": key, "error": err.Error()})
+			return handler(ctx, req)
+		}
+		if !res.Allowed {
+			log.Clog(ctx).Info("Rate limit exceeded", log.Fields{"key": key})
+			grpc.SetHeader(ctx, metadata.Pairs("retry-after", ceilSeconds(res.RetryAfter)))
+			return nil, grpc_utils.Error(ctx, codes.ResourceExhausted, http_utils.CodeRateLimited, "Too many requests")
+		}
+		return handler(ctx, req)
+	}
+}
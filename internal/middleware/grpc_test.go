@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnaryRecover(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/user.v1.UserService/Login"}
+	panics := func(context.Context, interface{}) (interface{}, error) {
+		panic("argon2: number of rounds too small")
+	}
+	resp, err := UnaryRecover(context.Background(), nil, info, panics)
+	if resp != nil || status.Code(err) != codes.Internal {
+		t.Fatalf("resp %v, err %v, want Internal", resp, err)
+	}
+
+	ok := func(context.Context, interface{}) (interface{}, error) {
+		return "resp", nil
+	}
+	resp, err = UnaryRecover(context.Background(), nil, info, ok)
+	if resp != "resp" || err != nil {
+		t.Fatalf("resp %v, err %v", resp, err)
+	}
+}
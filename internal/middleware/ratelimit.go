@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ollub/user_service/internal/session"
+	"github.com/Ollub/user_service/pkg/log"
+	"github.com/Ollub/user_service/pkg/ratelimit"
+	"github.com/Ollub/user_service/pkg/utils/http_utils"
+	"github.com/gorilla/mux"
+)
+
+// KeyFunc returns a part of the rate limit bucket key for the request.
+type KeyFunc func(r *http.Request) string
+
+// ByIP keys requests by the client ip, requires SetupClientInfo.
+func ByIP(r *http.Request) string {
+	return "ip:" + ClientIPFromContext(r.Context())
+}
+
+// ByUser keys requests by the authenticated user and falls back to the client ip for anonymous ones.
+func ByUser(r *http.Request) string {
+	if sess := session.FromContext(r.Context()); sess != nil {
+		return fmt.Sprintf("user:%d", sess.UserID)
+	}
+	return ByIP(r)
+}
+
+// ByRoute keys requests by the matched route template, so /users/1 and /users/2 share a bucket.
+func ByRoute(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tpl, err := route.GetPathTemplate(); err == nil {
+			return "route:" + r.Method + " " + tpl
+		}
+	}
+	return "route:" + r.Method + " " + r.URL.Path
+}
+
+// RateLimit allows requests while the token bucket of the request key has tokens.
+// The key is built from all the keys functions, e.g. RateLimit(store, limit, ByRoute, ByIP)
+// gives every client ip its own bucket per route. Store errors let the request through.
+func RateLimit(store ratelimit.Store, limit ratelimit.Limit, keys ...KeyFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			parts := make([]string, len(keys))
+			for i, key := range keys {
+				parts[i] = key(r)
+			}
+			key := strings.Join(parts, "|")
+
+			res, err := store.Take(r.Context(), key, limit)
+			if err != nil {
+				log.Rlog(r).Error("Rate limit store error", log.Fields{"key": key, "error": err.Error()})
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			w.Header().Set("RateLimit-Reset", ceilSeconds(res.ResetAfter))
+			if !res.Allowed {
+				log.Rlog(r).Info("Rate limit exceeded", log.Fields{"key": key})
+				w.Header().Set("Retry-After", ceilSeconds(res.RetryAfter))
+				http_utils.HttpError(w, r, http_utils.CodeRateLimited, "Too many requests", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
@@ -0,0 +1,263 @@
+package delivery
+
+import (
+	"html/template"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Ollub/user_service/internal/oauth"
+	"github.com/Ollub/user_service/internal/oauth/usecase"
+	user_delivery "github.com/Ollub/user_service/internal/users/delivery"
+	user_usecase "github.com/Ollub/user_service/internal/users/usecase"
+	"github.com/Ollub/user_service/pkg/log"
+)
+
+// Error codes of the authorization endpoint, see RFC 6749 section 4.1.2.1.
+const (
+	ErrAccessDenied            = "access_denied"
+	ErrUnsupportedResponseType = "unsupported_response_type"
+	// There is no login session to reuse, so prompt=none always fails, see OpenID Connect Core section 3.1.2.6.
+	ErrLoginRequired = "login_required"
+)
+
+var authorizePage = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>Sign in to {{.ClientName}}</title>
+</head>
+<body>
+  <h1>Sign in to {{.ClientName}}</h1>
+  {{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+  {{if .Scopes}}
+  <p>{{.ClientName}} asks for access to:</p>
+  <ul>{{range .Scopes}}<li>{{.}}</li>{{end}}</ul>
+  {{end}}
+  <form method="post" action="/authorize">
+    {{range $name, $value := .Params}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+    {{end}}
+    <label>Email <input type="email" name="email" value="{{.Email}}" autocomplete="username" required></label>
+    <label>Password <input type="password" name="password" autocomplete="current-password"></label>
+    <button type="submit" name="action" value="allow">Allow</button>
+    <button type="submit" name="action" value="deny" formnovalidate>Deny</button>
+  </form>
+</body>
+</html>
+`))
+
+var errorPage = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Authorization error</title></head>
+<body>
+  <h1>Authorization error</h1>
+  <p>{{.}}</p>
+</body>
+</html>
+`))
+
+// authorizeParams are the query params of the authorization request, they are passed through the login form.
+var authorizeParams = []string{
+	"response_type", "client_id", "redirect_uri", "scope", "state", "code_challenge", "code_challenge_method", "nonce", "prompt",
+}
+
+type authorizeReq struct {
+	client      *oauth.Client
+	redirectURI string
+	scope       string
+	state       string
+	challenge   string
+	nonce       string
+	params      map[string]string
+}
+
+// authorizeErr is a failed authorization request. Errors found before the redirect uri is validated
+// are shown to the user, the others are sent to the client by the redirect.
+type authorizeErr struct {
+	code        string
+	description string
+	redirect    bool
+}
+
+type authorizePageData struct {
+	ClientName string
+	Scopes     []string
+	Params     map[string]string
+	Email      string
+	Error      string
+}
+
+// Authorize shows the login and consent page of the authorization code flow.
+func (h *Handler) Authorize(w http.ResponseWriter, r *http.Request) {
+	req, aErr := h.parseAuthorize(r, r.URL.Query())
+	if aErr != nil {
+		h.authorizeError(w, r, req, aErr)
+		return
+	}
+	h.renderAuthorize(w, req, "", "", http.StatusOK)
+}
+
+// AuthorizeSubmit handles the login and consent form, the user is redirected back to the client
+// with the authorization code or the access_denied error.
+func (h *Handler) AuthorizeSubmit(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if err := r.ParseForm(); err != nil {
+		h.authorizeError(w, r, nil, &authorizeErr{code: ErrInvalidRequest, description: "Invalid form"})
+		return
+	}
+	req, aErr := h.parseAuthorize(r, r.PostForm)
+	if aErr != nil {
+		h.authorizeError(w, r, req, aErr)
+		return
+	}
+	if r.PostForm.Get("action") != "allow" {
+		h.authorizeError(w, r, req, &authorizeErr{code: ErrAccessDenied, description: "The user denied the request", redirect: true})
+		return
+	}
+
+	email := r.PostForm.Get("email")
+	user, retryAfter, err := h.auth.Authenticate(ctx, email, r.PostForm.Get("password"))
+	switch err {
+	case nil:
+	case user_delivery.LoginThrottledError:
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		h.renderAuthorize(w, req, email, "Too many failed login attempts, try again later", http.StatusTooManyRequests)
+		return
+	case user_usecase.UserNotFoundError, user_usecase.BadPasswordError:
+		h.renderAuthorize(w, req, email, "Invalid email or password", http.StatusUnauthorized)
+		return
+	case user_usecase.UserDisabledError:
+		h.renderAuthorize(w, req, email, "The account is disabled", http.StatusForbidden)
+		return
+	case user_usecase.ServiceBusyError:
+		w.Header().Set("Retry-After", "1")
+		h.renderAuthorize(w, req, email, "Service is busy, try again later", http.StatusServiceUnavailable)
+		return
+	default:
+		log.Clog(ctx).Error("Error during checking user password", log.Fields{"err": err})
+		h.renderAuthorize(w, req, email, "Internal error, try again later", http.StatusInternalServerError)
+		return
+	}
+
+	// the token request has to repeat the redirect uri exactly as it was given here, including its absence
+	code, err := h.oauth.Authorize(ctx, req.client, user, &oauth.AuthCode{
+		RedirectURI:   req.params["redirect_uri"],
+		Scope:         req.scope,
+		CodeChallenge: req.challenge,
+		Nonce:         req.nonce,
+	})
+	if err != nil {
+		h.authorizeError(w, r, req, &authorizeErr{code: ErrServerError, description: "Internal error", redirect: true})
+		return
+	}
+	redirect(w, r, req, url.Values{"code": {code}})
+}
+
+// parseAuthorize validates the authorization request. The returned request is set once the client
+// and the redirect uri are known, so errors can be redirected to the client.
+func (h *Handler) parseAuthorize(r *http.Request, values url.Values) (*authorizeReq, *authorizeErr) {
+	params := make(map[string]string, len(authorizeParams))
+	for _, name := range authorizeParams {
+		if v := values.Get(name); v != "" {
+			params[name] = v
+		}
+	}
+
+	client, err := h.oauth.GetClient(r.Context(), params["client_id"])
+	if err == usecase.ClientNotFoundError {
+		return nil, &authorizeErr{code: ErrInvalidRequest, description: "Unknown client"}
+	}
+	if err != nil {
+		return nil, &authorizeErr{code: ErrServerError, description: "Internal error, try again later"}
+	}
+	redirectURI, ok := client.RedirectURI(params["redirect_uri"])
+	if !ok {
+		return nil, &authorizeErr{code: ErrInvalidRequest, description: "The redirect uri is not registered for the client"}
+	}
+	req := &authorizeReq{client: client, redirectURI: redirectURI, state: params["state"], params: params}
+
+	if params["response_type"] != "code" {
+		return req, &authorizeErr{code: ErrUnsupportedResponseType, description: "Only the code response type is supported", redirect: true}
+	}
+	if !client.AllowsGrant(oauth.GrantAuthorizationCode) {
+		return req, &authorizeErr{code: ErrUnauthorizedClient, description: "The client can't use the authorization code flow", redirect: true}
+	}
+	if req.scope, ok = client.GrantScope(params["scope"]); !ok {
+		return req, &authorizeErr{code: ErrInvalidScope, description: "The scope is not allowed", redirect: true}
+	}
+	req.challenge = params["code_challenge"]
+	if req.challenge == "" && client.Public {
+		return req, &authorizeErr{code: ErrInvalidRequest, description: "PKCE code_challenge is required for public clients", redirect: true}
+	}
+	if req.challenge != "" && params["code_challenge_method"] != oauth.ChallengeS256 {
+		return req, &authorizeErr{code: ErrInvalidRequest, description: "Only the S256 code_challenge_method is supported", redirect: true}
+	}
+	req.nonce = params["nonce"]
+	for _, prompt := range strings.Fields(params["prompt"]) {
+		if prompt == "none" {
+			return req, &authorizeErr{code: ErrLoginRequired, description: "The user has to log in", redirect: true}
+		}
+	}
+	return req, nil
+}
+
+func (h *Handler) renderAuthorize(w http.ResponseWriter, req *authorizeReq, email, errMsg string, status int) {
+	setPageHeaders(w)
+	w.WriteHeader(status)
+	data := &authorizePageData{
+		ClientName: req.client.Name,
+		Scopes:     strings.Fields(req.scope),
+		Params:     req.params,
+		Email:      email,
+		Error:      errMsg,
+	}
+	if err := authorizePage.Execute(w, data); err != nil {
+		log.Error("Error while rendering authorize page", log.Fields{"error": err.Error()})
+	}
+}
+
+func (h *Handler) authorizeError(w http.ResponseWriter, r *http.Request, req *authorizeReq, aErr *authorizeErr) {
+	if aErr.redirect && req != nil {
+		q := url.Values{"error": {aErr.code}, "error_description": {aErr.description}}
+		redirect(w, r, req, q)
+		return
+	}
+	setPageHeaders(w)
+	status := http.StatusBadRequest
+	if aErr.code == ErrServerError {
+		status = http.StatusInternalServerError
+	}
+	w.WriteHeader(status)
+	if err := errorPage.Execute(w, aErr.description); err != nil {
+		log.Error("Error while rendering authorize error page", log.Fields{"error": err.Error()})
+	}
+}
+
+// redirect sends the user back to the client with the params and the state added to the redirect uri query.
+func redirect(w http.ResponseWriter, r *http.Request, req *authorizeReq, params url.Values) {
+	u, err := url.Parse(req.redirectURI)
+	if err != nil {
+		http.Error(w, "Invalid redirect uri", http.StatusInternalServerError)
+		return
+	}
+	q := u.Query()
+	for name, values := range params {
+		q[name] = values
+	}
+	if req.state != "" {
+		q.Set("state", req.state)
+	}
+	u.RawQuery = q.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// setPageHeaders forbids caching and framing of the login page to prevent clickjacking.
+func setPageHeaders(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+}
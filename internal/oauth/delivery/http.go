@@ -0,0 +1,196 @@
+package delivery
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/Ollub/user_service/internal/oauth"
+	"github.com/Ollub/user_service/internal/oauth/usecase"
+	"github.com/Ollub/user_service/internal/users"
+	"github.com/Ollub/user_service/pkg/log"
+	"github.com/Ollub/user_service/pkg/utils/http_utils"
+	"github.com/gorilla/mux"
+)
+
+// CodeClientNotFound is returned by the clients administration for unknown client ids.
+const CodeClientNotFound = "oauth_client_not_found"
+
+// Error codes of the token endpoint, see RFC 6749 section 5.2.
+const (
+	ErrInvalidRequest       = "invalid_request"
+	ErrInvalidClient        = "invalid_client"
+	ErrInvalidGrant         = "invalid_grant"
+	ErrUnauthorizedClient   = "unauthorized_client"
+	ErrUnsupportedGrantType = "unsupported_grant_type"
+	ErrInvalidScope         = "invalid_scope"
+	ErrServerError          = "server_error"
+)
+
+// Authenticator checks user credentials the same way as /login, see users delivery Handler.Authenticate.
+type Authenticator interface {
+	Authenticate(ctx context.Context, email, pass string) (*users.User, time.Duration, error)
+}
+
+type Handler struct {
+	oauth *usecase.Manager
+	auth  Authenticator
+}
+
+func NewHandler(oauthManager *usecase.Manager, auth Authenticator) *Handler {
+	return &Handler{oauthManager, auth}
+}
+
+type ClientResp struct {
+	*oauth.Client
+	// Returned only once on registration
+	ClientSecret string `json:"clientSecret,omitempty"`
+}
+
+type ListClientsResp struct {
+	Clients []*oauth.Client `json:"clients"`
+}
+
+// TokenErrorResp is the token endpoint error, see RFC 6749 section 5.2.
+type TokenErrorResp struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// CreateClient registers a new client. Clients get authorization_code and refresh_token grants by default.
+func (h *Handler) CreateClient(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	in, err := http_utils.FromBody[oauth.ClientIn](r)
+	if err != nil {
+		log.Clog(ctx).Error("Marshaling error", log.Fields{"err": err})
+		http_utils.HttpError(w, r, http_utils.CodeInvalidPayload, "Provided payload can not be marshalled", http.StatusBadRequest)
+		return
+	}
+	if len(in.GrantTypes) == 0 {
+		in.GrantTypes = []string{oauth.GrantAuthorizationCode, oauth.GrantRefreshToken}
+	}
+	if in.RedirectURIs == nil {
+		in.RedirectURIs = []string{}
+	}
+	if in.Scopes == nil {
+		in.Scopes = []string{}
+	}
+	if errs := validateClient(in); len(errs) > 0 {
+		http_utils.ValidationError(w, r, errs, http.StatusUnprocessableEntity)
+		return
+	}
+
+	client, secret, err := h.oauth.RegisterClient(ctx, in)
+	if err != nil {
+		http_utils.HttpError(w, r, http_utils.CodeInternal, "Internal error while registering client", http.StatusInternalServerError)
+		return
+	}
+	http_utils.JsonResp(w, &ClientResp{client, secret}, http.StatusCreated)
+}
+
+func (h *Handler) ListClients(w http.ResponseWriter, r *http.Request) {
+	items, err := h.oauth.ListClients(r.Context())
+	if err != nil {
+		http_utils.HttpError(w, r, http_utils.CodeInternal, "Internal error while listing clients", http.StatusInternalServerError)
+		return
+	}
+	http_utils.JsonResp(w, ListClientsResp{items}, http.StatusOK)
+}
+
+// DeleteClient deletes the client, its tokens stop working immediately.
+func (h *Handler) DeleteClient(w http.ResponseWriter, r *http.Request) {
+	err := h.oauth.DeleteClient(r.Context(), mux.Vars(r)["id"])
+	if err == usecase.ClientNotFoundError {
+		http_utils.HttpError(w, r, CodeClientNotFound, "Client not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http_utils.HttpError(w, r, http_utils.CodeInternal, "Internal error while deleting client", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Token is the token endpoint supporting authorization_code (with PKCE), refresh_token and client_credentials grants.
+// Confidential clients authenticate with HTTP Basic auth or client_id/client_secret form fields,
+// public clients send only client_id.
+func (h *Handler) Token(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, ErrInvalidRequest, "Invalid form", http.StatusBadRequest)
+		return
+	}
+
+	id, secret, basic := clientCredentials(r)
+	if id == "" {
+		tokenError(w, ErrInvalidClient, "Client authentication required", http.StatusUnauthorized)
+		return
+	}
+	client, err := h.oauth.AuthenticateClient(ctx, id, secret)
+	if err == usecase.InvalidClientError {
+		if basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+		}
+		tokenError(w, ErrInvalidClient, "Client authentication failed", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		tokenError(w, ErrServerError, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	form := r.PostForm
+	var token *oauth.Token
+	switch grant := form.Get("grant_type"); grant {
+	case oauth.GrantAuthorizationCode:
+		if form.Get("code") == "" {
+			tokenError(w, ErrInvalidRequest, "code is required", http.StatusBadRequest)
+			return
+		}
+		token, err = h.oauth.ExchangeCode(ctx, client, form.Get("code"), form.Get("redirect_uri"), form.Get("code_verifier"))
+	case oauth.GrantRefreshToken:
+		if form.Get("refresh_token") == "" {
+			tokenError(w, ErrInvalidRequest, "refresh_token is required", http.StatusBadRequest)
+			return
+		}
+		token, err = h.oauth.Refresh(ctx, client, form.Get("refresh_token"), form.Get("scope"))
+	case oauth.GrantClientCredentials:
+		token, err = h.oauth.ClientCredentials(ctx, client, form.Get("scope"))
+	case "":
+		tokenError(w, ErrInvalidRequest, "grant_type is required", http.StatusBadRequest)
+		return
+	default:
+		tokenError(w, ErrUnsupportedGrantType, "Unsupported grant type "+grant, http.StatusBadRequest)
+		return
+	}
+
+	switch err {
+	case nil:
+		w.Header().Set("Content-Type", "application/json")
+		http_utils.JsonResp(w, token, http.StatusOK)
+	case usecase.InvalidGrantError:
+		tokenError(w, ErrInvalidGrant, "Invalid, expired or revoked grant", http.StatusBadRequest)
+	case usecase.UnauthorizedClientError:
+		tokenError(w, ErrUnauthorizedClient, "The grant type is not allowed to the client", http.StatusBadRequest)
+	case usecase.InvalidScopeError:
+		tokenError(w, ErrInvalidScope, "The scope is not allowed", http.StatusBadRequest)
+	default:
+		tokenError(w, ErrServerError, "Internal error", http.StatusInternalServerError)
+	}
+}
+
+// clientCredentials takes the client credentials from the Basic auth, where they are form encoded,
+// or from the form fields.
+func clientCredentials(r *http.Request) (id, secret string, basic bool) {
+	if id, secret, ok := http_utils.ClientBasicAuth(r); ok {
+		return id, secret, true
+	}
+	return r.PostForm.Get("client_id"), r.PostForm.Get("client_secret"), false
+}
+
+func tokenError(w http.ResponseWriter, code, description string, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	http_utils.JsonResp(w, &TokenErrorResp{Error: code, ErrorDescription: description}, status)
+}
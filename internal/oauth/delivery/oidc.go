@@ -0,0 +1,57 @@
+package delivery
+
+import (
+	"net/http"
+
+	"github.com/Ollub/user_service/internal/oauth"
+	"github.com/Ollub/user_service/internal/session"
+	user_usecase "github.com/Ollub/user_service/internal/users/usecase"
+	"github.com/Ollub/user_service/pkg/utils/http_utils"
+)
+
+// Error codes of the protected resources, see RFC 6750 section 3.1.
+const (
+	ErrInvalidToken      = "invalid_token"
+	ErrInsufficientScope = "insufficient_scope"
+)
+
+// Discovery is the OpenID Provider configuration document.
+func (h *Handler) Discovery(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	// public documents fetched by browser apps from other origins
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	http_utils.JsonResp(w, h.oauth.Discovery(), http.StatusOK)
+}
+
+// JWKS publishes the keys verifying the ID tokens.
+func (h *Handler) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	http_utils.JsonResp(w, h.oauth.JWKS(), http.StatusOK)
+}
+
+// UserInfo returns the claims of the user the access token was issued for, the token needs the openid scope.
+// The token itself is checked by the authentication middleware.
+func (h *Handler) UserInfo(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	sess := session.FromContext(ctx)
+	if !oauth.HasScope(sess.Scope, oauth.ScopeOpenID) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="`+ErrInsufficientScope+`", scope="`+oauth.ScopeOpenID+`"`)
+		tokenError(w, ErrInsufficientScope, "The access token has no openid scope", http.StatusForbidden)
+		return
+	}
+	info, err := h.oauth.UserInfo(ctx, sess.UserID, sess.Scope)
+	switch err {
+	case nil:
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		http_utils.JsonResp(w, info, http.StatusOK)
+	case user_usecase.UserNotFoundError:
+		w.Header().Set("WWW-Authenticate", `Bearer error="`+ErrInvalidToken+`"`)
+		tokenError(w, ErrInvalidToken, "The user does not exist", http.StatusUnauthorized)
+	default:
+		tokenError(w, ErrServerError, "Internal error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,86 @@
+package delivery
+
+import (
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/Ollub/user_service/internal/oauth"
+	"github.com/Ollub/user_service/internal/session"
+	"github.com/Ollub/user_service/pkg/utils/http_utils"
+)
+
+var supportedGrants = map[string]struct{}{
+	oauth.GrantAuthorizationCode: {},
+	oauth.GrantRefreshToken:      {},
+	oauth.GrantClientCredentials: {},
+}
+
+// schemes which run code or read local files in the browser instead of redirecting it
+var unsafeRedirectSchemes = map[string]struct{}{
+	"javascript": {},
+	"data":       {},
+	"vbscript":   {},
+	"file":       {},
+}
+
+func validateClient(in *oauth.ClientIn) []*http_utils.FieldError {
+	var errs []*http_utils.FieldError
+
+	if strings.TrimSpace(in.Name) == "" {
+		errs = append(errs, http_utils.NewFieldError("name", http_utils.FieldRequired, "may not be empty"))
+	}
+	for _, g := range in.GrantTypes {
+		if _, ok := supportedGrants[g]; !ok {
+			errs = append(errs, http_utils.NewFieldError("grantTypes", http_utils.FieldInvalid, "unsupported grant type "+g))
+		}
+		if g == oauth.GrantClientCredentials && in.Public {
+			errs = append(errs, http_utils.NewFieldError("grantTypes", http_utils.FieldInvalid, "public clients can't use client_credentials"))
+		}
+	}
+
+	needsRedirect := false
+	for _, g := range in.GrantTypes {
+		needsRedirect = needsRedirect || g == oauth.GrantAuthorizationCode
+	}
+	if needsRedirect && len(in.RedirectURIs) == 0 {
+		errs = append(errs, http_utils.NewFieldError("redirectUris", http_utils.FieldRequired, "may not be empty"))
+	}
+	for _, uri := range in.RedirectURIs {
+		if !isRedirectURIValid(uri) {
+			errs = append(errs, http_utils.NewFieldError("redirectUris", http_utils.FieldInvalid, "invalid redirect uri "+uri))
+		}
+	}
+
+	for _, s := range in.Scopes {
+		if s == "" || strings.ContainsAny(s, " \t\n\"\\") {
+			errs = append(errs, http_utils.NewFieldError("scopes", http_utils.FieldInvalid, "invalid scope "+s))
+		}
+		if s == session.ScopePasswordChange {
+			errs = append(errs, http_utils.NewFieldError("scopes", http_utils.FieldInvalid, "reserved scope "+s))
+		}
+	}
+	return errs
+}
+
+// isRedirectURIValid accepts absolute uris without fragment, plain http is allowed for loopback hosts only.
+// Custom schemes of native apps are allowed, but not the ones handled by the browser itself.
+func isRedirectURIValid(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme == "" || u.Fragment != "" || strings.ContainsAny(uri, " \t\n") {
+		return false
+	}
+	// the scheme is lowercased by url.Parse
+	if _, ok := unsafeRedirectSchemes[u.Scheme]; ok {
+		return false
+	}
+	if u.Scheme == "http" {
+		host := u.Hostname()
+		if host == "localhost" {
+			return true
+		}
+		ip := net.ParseIP(host)
+		return ip != nil && ip.IsLoopback()
+	}
+	return u.Scheme != "https" || u.Host != ""
+}
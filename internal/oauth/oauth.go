@@ -0,0 +1,165 @@
+package oauth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+	GrantClientCredentials = "client_credentials"
+)
+
+// ChallengeS256 is the only supported PKCE code challenge method, "plain" is not accepted.
+const ChallengeS256 = "S256"
+
+type Config struct {
+	AccessTokenTTL  time.Duration `envconfig:"OAUTH_ACCESS_TOKEN_TTL" default:"1h"`
+	RefreshTokenTTL time.Duration `envconfig:"OAUTH_REFRESH_TOKEN_TTL" default:"720h"`
+	CodeTTL         time.Duration `envconfig:"OAUTH_CODE_TTL" default:"1m"`
+	// OpenID Connect issuer, the public base url of the service
+	Issuer string `envconfig:"OIDC_ISSUER" default:"http://localhost:8080"`
+	// PEM RSA private key signing ID tokens, a key is generated on start if empty
+	SigningKeyPath string `envconfig:"OIDC_SIGNING_KEY_PATH"`
+}
+
+// Client is a registered OAuth client application.
+// Public clients (SPAs, mobile apps) have no secret and have to use PKCE.
+type Client struct {
+	ID           string    `json:"clientId"`
+	SecretHash   string    `json:"-"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirectUris"`
+	GrantTypes   []string  `json:"grantTypes"`
+	Scopes       []string  `json:"scopes"`
+	Public       bool      `json:"public"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+type ClientIn struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirectUris"`
+	GrantTypes   []string `json:"grantTypes"`
+	Scopes       []string `json:"scopes"`
+	Public       bool     `json:"public"`
+}
+
+// RedirectURI returns the registered redirect uri matching the requested one exactly.
+// The only registered uri is used when none is requested.
+func (c *Client) RedirectURI(requested string) (string, bool) {
+	if requested == "" {
+		if len(c.RedirectURIs) == 1 {
+			return c.RedirectURIs[0], true
+		}
+		return "", false
+	}
+	for _, uri := range c.RedirectURIs {
+		if uri == requested {
+			return uri, true
+		}
+	}
+	return "", false
+}
+
+func (c *Client) AllowsGrant(grant string) bool {
+	return contains(c.GrantTypes, grant)
+}
+
+// GrantScope checks the requested space separated scope against the client scopes.
+// All the client scopes are granted when none is requested.
+func (c *Client) GrantScope(requested string) (string, bool) {
+	if strings.TrimSpace(requested) == "" {
+		return strings.Join(c.Scopes, " "), true
+	}
+	return SubScope(requested, c.Scopes)
+}
+
+// SubScope checks that every scope of the requested space separated scope is in allowed
+// and returns them deduplicated.
+func SubScope(requested string, allowed []string) (string, bool) {
+	var granted []string
+	seen := map[string]struct{}{}
+	for _, s := range strings.Fields(requested) {
+		if _, ok := seen[s]; ok {
+			continue
+		}
+		if !contains(allowed, s) {
+			return "", false
+		}
+		seen[s] = struct{}{}
+		granted = append(granted, s)
+	}
+	return strings.Join(granted, " "), true
+}
+
+// AuthCode is an issued authorization code, only its hash is stored.
+type AuthCode struct {
+	Hash          string
+	ClientID      string
+	UserID        uint32
+	RedirectURI   string
+	Scope         string
+	CodeChallenge string
+	// OpenID Connect nonce, returned in the ID token
+	Nonce     string
+	AuthTime  time.Time
+	ExpiresAt time.Time
+}
+
+// RefreshToken is an issued refresh token, only its hash is stored.
+// Tokens are rotated: a used token is revoked and a new one is issued.
+// It is the session of the user in the client application, the data export lists them.
+type RefreshToken struct {
+	Hash      string     `json:"-"`
+	ClientID  string     `json:"clientId"`
+	UserID    uint32     `json:"-"`
+	Scope     string     `json:"scope"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+}
+
+// Token is the token endpoint response, see RFC 6749 section 5.1.
+type Token struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+}
+
+// RandomToken returns a random url safe string used for client ids, secrets, codes and refresh tokens.
+func RandomToken(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Hash is used to store secrets, codes and refresh tokens, they are random so a plain sha256 is enough.
+func Hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// S256Challenge is the PKCE S256 code challenge of the verifier.
+func S256Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,187 @@
+package oauth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Ollub/user_service/internal/users"
+	"github.com/Ollub/user_service/pkg/log"
+	"github.com/dgrijalva/jwt-go"
+)
+
+// OpenID Connect scopes, see OpenID Connect Core section 5.4.
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+// HasScope reports whether the space separated scope contains s.
+func HasScope(scope, s string) bool {
+	return contains(strings.Fields(scope), s)
+}
+
+// KeySet is the RSA key signing ID tokens, its public part is published as JWKS.
+type KeySet struct {
+	key *rsa.PrivateKey
+	kid string
+}
+
+// LoadKeySet reads the PEM private key at path. Without a path a key is generated,
+// ID tokens signed by it can't be verified after a restart or by other instances.
+func LoadKeySet(path string) (*KeySet, error) {
+	var key *rsa.PrivateKey
+	if path == "" {
+		log.Warn("OIDC_SIGNING_KEY_PATH is not set, ID tokens are signed by a generated key")
+		var err error
+		if key, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			return nil, err
+		}
+	} else {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if key, err = jwt.ParseRSAPrivateKeyFromPEM(data); err != nil {
+			return nil, err
+		}
+	}
+	// the key id is derived from the public key so every instance sharing the key publishes the same one
+	sum := sha256.Sum256(key.PublicKey.N.Bytes())
+	return &KeySet{key: key, kid: base64.RawURLEncoding.EncodeToString(sum[:8])}, nil
+}
+
+// Sign signs the claims with RS256, the key id is set in the header.
+func (k *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = k.kid
+	return token.SignedString(k.key)
+}
+
+// JWK is a public key in the JSON Web Key format, see RFC 7517.
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+func (k *KeySet) JWKS() *JWKS {
+	pub := k.key.PublicKey
+	return &JWKS{Keys: []JWK{{
+		Kty: "RSA",
+		Use: "sig",
+		Alg: jwt.SigningMethodRS256.Alg(),
+		Kid: k.kid,
+		N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}}}
+}
+
+// UserInfo are the standard claims of the user released by the granted scopes,
+// see OpenID Connect Core section 5.1.
+type UserInfo struct {
+	Sub        string `json:"sub"`
+	Name       string `json:"name,omitempty"`
+	GivenName  string `json:"given_name,omitempty"`
+	FamilyName string `json:"family_name,omitempty"`
+	Email      string `json:"email,omitempty"`
+	// Emails are not verified by the service
+	EmailVerified *bool `json:"email_verified,omitempty"`
+}
+
+func NewUserInfo(u *users.User, scope string) *UserInfo {
+	info := &UserInfo{Sub: Subject(u.ID)}
+	if HasScope(scope, ScopeProfile) {
+		info.GivenName = u.FirstName
+		info.FamilyName = u.LastName
+		info.Name = strings.TrimSpace(u.FirstName + " " + u.LastName)
+	}
+	if HasScope(scope, ScopeEmail) {
+		verified := false
+		info.Email = u.Email
+		info.EmailVerified = &verified
+	}
+	return info
+}
+
+// Subject is the sub claim of the user, the same as in the access tokens.
+func Subject(userID uint32) string {
+	return strconv.FormatUint(uint64(userID), 10)
+}
+
+// IDTokenClaims are the claims of the ID token, see OpenID Connect Core section 2.
+type IDTokenClaims struct {
+	*UserInfo
+	Issuer    string `json:"iss"`
+	Audience  string `json:"aud"`
+	ExpiresAt int64  `json:"exp"`
+	IssuedAt  int64  `json:"iat"`
+	AuthTime  int64  `json:"auth_time,omitempty"`
+	Nonce     string `json:"nonce,omitempty"`
+	AtHash    string `json:"at_hash,omitempty"`
+}
+
+// Valid implements jwt.Claims, ID tokens are only issued here and verified by the clients.
+func (c *IDTokenClaims) Valid() error {
+	return nil
+}
+
+// AccessTokenHash is the at_hash claim: the left half of the SHA-256 of the access token.
+func AccessTokenHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+}
+
+// Discovery is the OpenID Provider metadata, see OpenID Connect Discovery section 3.
+type Discovery struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JwksURI                           string   `json:"jwks_uri"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+func NewDiscovery(issuer string) *Discovery {
+	issuer = strings.TrimSuffix(issuer, "/")
+	return &Discovery{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + "/authorize",
+		TokenEndpoint:                     issuer + "/token",
+		UserinfoEndpoint:                  issuer + "/userinfo",
+		JwksURI:                           issuer + "/.well-known/jwks.json",
+		IntrospectionEndpoint:             issuer + "/introspect",
+		ScopesSupported:                   []string{ScopeOpenID, ScopeProfile, ScopeEmail},
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{GrantAuthorizationCode, GrantRefreshToken, GrantClientCredentials},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{jwt.SigningMethodRS256.Alg()},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{ChallengeS256},
+		ClaimsSupported: []string{
+			"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "at_hash",
+			"name", "given_name", "family_name", "email", "email_verified",
+		},
+	}
+}
@@ -0,0 +1,228 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/Ollub/user_service/internal/oauth"
+)
+
+type RepoPgx struct {
+	DB *sql.DB
+}
+
+func NewPgRepository(db *sql.DB) *RepoPgx {
+	return &RepoPgx{DB: db}
+}
+
+const clientColumns = `id, secret_hash, name, redirect_uris, grant_types, scopes, public, created_at`
+
+const refreshTokenColumns = `token_hash, client_id, user_id, scope, created_at, expires_at, revoked_at`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanClient(row scanner) (*oauth.Client, error) {
+	c := &oauth.Client{}
+	var redirectURIs, grantTypes, scopes string
+	err := row.Scan(&c.ID, &c.SecretHash, &c.Name, &redirectURIs, &grantTypes, &scopes, &c.Public, &c.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	c.RedirectURIs = strings.Fields(redirectURIs)
+	c.GrantTypes = strings.Fields(grantTypes)
+	c.Scopes = strings.Fields(scopes)
+	return c, nil
+}
+
+func scanRefreshToken(row scanner) (*oauth.RefreshToken, error) {
+	t := &oauth.RefreshToken{}
+	var revokedAt sql.NullTime
+	err := row.Scan(&t.Hash, &t.ClientID, &t.UserID, &t.Scope, &t.CreatedAt, &t.ExpiresAt, &revokedAt)
+	if err != nil {
+		return nil, err
+	}
+	if revokedAt.Valid {
+		t.RevokedAt = &revokedAt.Time
+	}
+	return t, nil
+}
+
+func (repo *RepoPgx) AddClient(ctx context.Context, c *oauth.Client) error {
+	return repo.DB.QueryRowContext(
+		ctx,
+		`INSERT INTO oauth_clients (id, secret_hash, name, redirect_uris, grant_types, scopes, public) `+
+			`VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING created_at`,
+		c.ID,
+		c.SecretHash,
+		c.Name,
+		strings.Join(c.RedirectURIs, " "),
+		strings.Join(c.GrantTypes, " "),
+		strings.Join(c.Scopes, " "),
+		c.Public,
+	).Scan(&c.CreatedAt)
+}
+
+func (repo *RepoPgx) GetClient(ctx context.Context, id string) (*oauth.Client, error) {
+	row := repo.DB.QueryRowContext(ctx, `SELECT `+clientColumns+` FROM oauth_clients WHERE id = $1`, id)
+	c, err := scanClient(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return c, err
+}
+
+func (repo *RepoPgx) ListClients(ctx context.Context) ([]*oauth.Client, error) {
+	items := []*oauth.Client{}
+	rows, err := repo.DB.QueryContext(ctx, `SELECT `+clientColumns+` FROM oauth_clients ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		c, err := scanClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, c)
+	}
+	return items, rows.Err()
+}
+
+// DeleteClient deletes the client with its codes and refresh tokens, false is returned for unknown clients.
+func (repo *RepoPgx) DeleteClient(ctx context.Context, id string) (bool, error) {
+	result, err := repo.DB.ExecContext(ctx, `DELETE FROM oauth_clients WHERE id = $1`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (repo *RepoPgx) AddCode(ctx context.Context, c *oauth.AuthCode) error {
+	_, err := repo.DB.ExecContext(
+		ctx,
+		`INSERT INTO oauth_codes (code_hash, client_id, user_id, redirect_uri, scope, code_challenge, nonce, auth_time, expires_at) `+
+			`VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		c.Hash,
+		c.ClientID,
+		c.UserID,
+		c.RedirectURI,
+		c.Scope,
+		c.CodeChallenge,
+		c.Nonce,
+		c.AuthTime,
+		c.ExpiresAt,
+	)
+	return err
+}
+
+// TakeCode deletes the code and returns it, so a code can be exchanged only once.
+func (repo *RepoPgx) TakeCode(ctx context.Context, hash string) (*oauth.AuthCode, error) {
+	c := &oauth.AuthCode{}
+	err := repo.DB.QueryRowContext(
+		ctx,
+		`DELETE FROM oauth_codes WHERE code_hash = $1 `+
+			`RETURNING code_hash, client_id, user_id, redirect_uri, scope, code_challenge, nonce, auth_time, expires_at`,
+		hash,
+	).Scan(&c.Hash, &c.ClientID, &c.UserID, &c.RedirectURI, &c.Scope, &c.CodeChallenge, &c.Nonce, &c.AuthTime, &c.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return c, err
+}
+
+func (repo *RepoPgx) AddRefreshToken(ctx context.Context, t *oauth.RefreshToken) error {
+	return repo.DB.QueryRowContext(
+		ctx,
+		`INSERT INTO oauth_refresh_tokens (token_hash, client_id, user_id, scope, expires_at) `+
+			`VALUES ($1, $2, $3, $4, $5) RETURNING created_at`,
+		t.Hash,
+		t.ClientID,
+		t.UserID,
+		t.Scope,
+		t.ExpiresAt,
+	).Scan(&t.CreatedAt)
+}
+
+// RevokeRefreshToken marks the token as used and returns it.
+// Nil is returned if the token is unknown, expired or was already revoked.
+func (repo *RepoPgx) RevokeRefreshToken(ctx context.Context, hash string) (*oauth.RefreshToken, error) {
+	row := repo.DB.QueryRowContext(
+		ctx,
+		`UPDATE oauth_refresh_tokens SET revoked_at = now() `+
+			`WHERE token_hash = $1 AND revoked_at IS NULL AND expires_at > now() RETURNING `+refreshTokenColumns,
+		hash,
+	)
+	t, err := scanRefreshToken(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return t, err
+}
+
+func (repo *RepoPgx) GetRefreshToken(ctx context.Context, hash string) (*oauth.RefreshToken, error) {
+	row := repo.DB.QueryRowContext(
+		ctx,
+		`SELECT `+refreshTokenColumns+` FROM oauth_refresh_tokens WHERE token_hash = $1`,
+		hash,
+	)
+	t, err := scanRefreshToken(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return t, err
+}
+
+// ListRefreshTokens returns the not yet deleted refresh tokens of the user, newest first.
+func (repo *RepoPgx) ListRefreshTokens(ctx context.Context, userID uint32) ([]*oauth.RefreshToken, error) {
+	items := []*oauth.RefreshToken{}
+	rows, err := repo.DB.QueryContext(
+		ctx,
+		`SELECT `+refreshTokenColumns+` FROM oauth_refresh_tokens WHERE user_id = $1 ORDER BY created_at DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		t, err := scanRefreshToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, t)
+	}
+	return items, rows.Err()
+}
+
+// RevokeRefreshTokens revokes all the active refresh tokens the client has for the user.
+func (repo *RepoPgx) RevokeRefreshTokens(ctx context.Context, clientID string, userID uint32) (int64, error) {
+	result, err := repo.DB.ExecContext(
+		ctx,
+		`UPDATE oauth_refresh_tokens SET revoked_at = now() WHERE client_id = $1 AND user_id = $2 AND revoked_at IS NULL`,
+		clientID,
+		userID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DeleteExpired deletes expired codes and refresh tokens.
+func (repo *RepoPgx) DeleteExpired(ctx context.Context) (int64, error) {
+	codes, err := repo.DB.ExecContext(ctx, `DELETE FROM oauth_codes WHERE expires_at <= now()`)
+	if err != nil {
+		return 0, err
+	}
+	tokens, err := repo.DB.ExecContext(ctx, `DELETE FROM oauth_refresh_tokens WHERE expires_at <= now()`)
+	if err != nil {
+		return 0, err
+	}
+	nCodes, _ := codes.RowsAffected()
+	nTokens, _ := tokens.RowsAffected()
+	return nCodes + nTokens, nil
+}
@@ -0,0 +1,9 @@
+package usecase
+
+import "errors"
+
+var ClientNotFoundError = errors.New("oauth client not found")
+var InvalidClientError = errors.New("oauth client authentication failed")
+var UnauthorizedClientError = errors.New("grant type is not allowed to the client")
+var InvalidGrantError = errors.New("invalid or expired grant")
+var InvalidScopeError = errors.New("requested scope is not allowed to the client")
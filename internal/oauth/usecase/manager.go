@@ -0,0 +1,407 @@
+package usecase
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Ollub/user_service/internal/audit"
+	"github.com/Ollub/user_service/internal/oauth"
+	"github.com/Ollub/user_service/internal/users"
+	"github.com/Ollub/user_service/pkg/log"
+)
+
+type Repo interface {
+	AddClient(ctx context.Context, c *oauth.Client) error
+	GetClient(ctx context.Context, id string) (*oauth.Client, error)
+	ListClients(ctx context.Context) ([]*oauth.Client, error)
+	DeleteClient(ctx context.Context, id string) (bool, error)
+	AddCode(ctx context.Context, c *oauth.AuthCode) error
+	TakeCode(ctx context.Context, hash string) (*oauth.AuthCode, error)
+	AddRefreshToken(ctx context.Context, t *oauth.RefreshToken) error
+	RevokeRefreshToken(ctx context.Context, hash string) (*oauth.RefreshToken, error)
+	GetRefreshToken(ctx context.Context, hash string) (*oauth.RefreshToken, error)
+	RevokeRefreshTokens(ctx context.Context, clientID string, userID uint32) (int64, error)
+	ListRefreshTokens(ctx context.Context, userID uint32) ([]*oauth.RefreshToken, error)
+	DeleteExpired(ctx context.Context) (int64, error)
+}
+
+type Users interface {
+	GetUser(ctx context.Context, userId uint32) (*users.User, error)
+}
+
+// TokenIssuer issues access tokens, the user is nil for tokens of the client itself.
+type TokenIssuer interface {
+	Issue(ctx context.Context, user *users.User, clientID, scope string, ttl time.Duration) (string, error)
+}
+
+type Auditor interface {
+	Record(ctx context.Context, e *audit.Event)
+}
+
+// Manager is the OAuth 2.0 authorization server and OpenID provider: it keeps the clients
+// and issues codes, access, refresh and ID tokens.
+type Manager struct {
+	repo      Repo
+	users     Users
+	issuer    TokenIssuer
+	keys      *oauth.KeySet
+	auditor   Auditor
+	discovery *oauth.Discovery
+	cfg       *oauth.Config
+}
+
+func NewManager(repo Repo, users Users, issuer TokenIssuer, keys *oauth.KeySet, auditor Auditor, cfg *oauth.Config) *Manager {
+	return &Manager{
+		repo:      repo,
+		users:     users,
+		issuer:    issuer,
+		keys:      keys,
+		auditor:   auditor,
+		discovery: oauth.NewDiscovery(cfg.Issuer),
+		cfg:       cfg,
+	}
+}
+
+func (m *Manager) Discovery() *oauth.Discovery {
+	return m.discovery
+}
+
+func (m *Manager) JWKS() *oauth.JWKS {
+	return m.keys.JWKS()
+}
+
+// RegisterClient creates a client, the returned secret is not stored and can't be shown again.
+// Public clients get no secret.
+func (m *Manager) RegisterClient(ctx context.Context, in *oauth.ClientIn) (*oauth.Client, string, error) {
+	c := &oauth.Client{
+		ID:           oauth.RandomToken(16),
+		Name:         in.Name,
+		RedirectURIs: in.RedirectURIs,
+		GrantTypes:   in.GrantTypes,
+		Scopes:       in.Scopes,
+		Public:       in.Public,
+	}
+	var secret string
+	if !c.Public {
+		secret = oauth.RandomToken(32)
+		c.SecretHash = oauth.Hash(secret)
+	}
+	if err := m.repo.AddClient(ctx, c); err != nil {
+		log.Clog(ctx).Error("Error while registering oauth client", log.Fields{"name": in.Name, "error": err.Error()})
+		return nil, "", fmt.Errorf("register oauth client: %w", err)
+	}
+	m.auditor.Record(ctx, &audit.Event{
+		Action:  audit.ActionOAuthClientCreated,
+		Details: map[string]interface{}{"clientId": c.ID, "name": c.Name, "public": c.Public},
+	})
+	return c, secret, nil
+}
+
+func (m *Manager) ListClients(ctx context.Context) ([]*oauth.Client, error) {
+	items, err := m.repo.ListClients(ctx)
+	if err != nil {
+		log.Clog(ctx).Error("Error while listing oauth clients", log.Fields{"error": err.Error()})
+		return nil, fmt.Errorf("list oauth clients: %w", err)
+	}
+	return items, nil
+}
+
+func (m *Manager) GetClient(ctx context.Context, id string) (*oauth.Client, error) {
+	c, err := m.repo.GetClient(ctx, id)
+	if err != nil {
+		log.Clog(ctx).Error("Error while retrieving oauth client", log.Fields{"clientId": id, "error": err.Error()})
+		return nil, fmt.Errorf("get oauth client: %w", err)
+	}
+	if c == nil {
+		return nil, ClientNotFoundError
+	}
+	return c, nil
+}
+
+// ClientExists is used to reject tokens of deleted clients.
+func (m *Manager) ClientExists(ctx context.Context, id string) (bool, error) {
+	c, err := m.repo.GetClient(ctx, id)
+	return c != nil, err
+}
+
+// DeleteClient deletes the client, its codes and refresh tokens. Its access tokens are rejected from now on.
+func (m *Manager) DeleteClient(ctx context.Context, id string) error {
+	ok, err := m.repo.DeleteClient(ctx, id)
+	if err != nil {
+		log.Clog(ctx).Error("Error while deleting oauth client", log.Fields{"clientId": id, "error": err.Error()})
+		return fmt.Errorf("delete oauth client: %w", err)
+	}
+	if !ok {
+		return ClientNotFoundError
+	}
+	m.auditor.Record(ctx, &audit.Event{
+		Action:  audit.ActionOAuthClientDeleted,
+		Details: map[string]interface{}{"clientId": id},
+	})
+	return nil
+}
+
+// AuthenticateClient checks the client credentials, public clients have to come without a secret.
+func (m *Manager) AuthenticateClient(ctx context.Context, id, secret string) (*oauth.Client, error) {
+	c, err := m.GetClient(ctx, id)
+	if err == ClientNotFoundError {
+		return nil, InvalidClientError
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.Public {
+		if secret != "" {
+			return nil, InvalidClientError
+		}
+		return c, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(oauth.Hash(secret)), []byte(c.SecretHash)) != 1 {
+		return nil, InvalidClientError
+	}
+	return c, nil
+}
+
+// Authorize issues an authorization code of the user to the client. The redirect uri, scope,
+// PKCE challenge and nonce of the request are expected to be validated already,
+// the redirect uri is the requested one and is empty if the request had none.
+func (m *Manager) Authorize(ctx context.Context, c *oauth.Client, u *users.User, req *oauth.AuthCode) (string, error) {
+	code := oauth.RandomToken(32)
+	now := time.Now()
+	err := m.repo.AddCode(ctx, &oauth.AuthCode{
+		Hash:          oauth.Hash(code),
+		ClientID:      c.ID,
+		UserID:        u.ID,
+		RedirectURI:   req.RedirectURI,
+		Scope:         req.Scope,
+		CodeChallenge: req.CodeChallenge,
+		Nonce:         req.Nonce,
+		AuthTime:      now,
+		ExpiresAt:     now.Add(m.cfg.CodeTTL),
+	})
+	if err != nil {
+		log.Clog(ctx).Error("Error while issuing authorization code", log.Fields{"clientId": c.ID, "userId": u.ID, "error": err.Error()})
+		return "", fmt.Errorf("issue authorization code: %w", err)
+	}
+	m.auditor.Record(ctx, &audit.Event{
+		ActorID:  audit.UserRef(u.ID),
+		TargetID: audit.UserRef(u.ID),
+		Action:   audit.ActionOAuthAuthorized,
+		Details:  map[string]interface{}{"clientId": c.ID, "scope": req.Scope},
+	})
+	return code, nil
+}
+
+// ExchangeCode is the authorization_code grant. The code is single use, the redirect uri has to be the one
+// given to /authorize and the verifier has to match the PKCE challenge if there was one.
+func (m *Manager) ExchangeCode(ctx context.Context, c *oauth.Client, code, redirectURI, verifier string) (*oauth.Token, error) {
+	if !c.AllowsGrant(oauth.GrantAuthorizationCode) {
+		return nil, UnauthorizedClientError
+	}
+	ac, err := m.repo.TakeCode(ctx, oauth.Hash(code))
+	if err != nil {
+		log.Clog(ctx).Error("Error while taking authorization code", log.Fields{"clientId": c.ID, "error": err.Error()})
+		return nil, fmt.Errorf("take authorization code: %w", err)
+	}
+	if ac == nil || time.Now().After(ac.ExpiresAt) || ac.ClientID != c.ID || ac.RedirectURI != redirectURI {
+		return nil, InvalidGrantError
+	}
+	if ac.CodeChallenge == "" && verifier != "" {
+		return nil, InvalidGrantError
+	}
+	if ac.CodeChallenge != "" && subtle.ConstantTimeCompare([]byte(oauth.S256Challenge(verifier)), []byte(ac.CodeChallenge)) != 1 {
+		return nil, InvalidGrantError
+	}
+
+	u, err := m.users.GetUser(ctx, ac.UserID)
+	if err != nil || u.Disabled {
+		return nil, InvalidGrantError
+	}
+	return m.issueUserTokens(ctx, c, u, ac.Scope, ac.Scope, ac)
+}
+
+// Refresh is the refresh_token grant. The refresh token is rotated, a reused one revokes all the refresh tokens
+// the client has for the user as it is likely stolen. Tokens issued before a password change are not accepted.
+// The scope may be narrowed, the new refresh token keeps the original one.
+func (m *Manager) Refresh(ctx context.Context, c *oauth.Client, refreshToken, scope string) (*oauth.Token, error) {
+	if !c.AllowsGrant(oauth.GrantRefreshToken) {
+		return nil, UnauthorizedClientError
+	}
+	hash := oauth.Hash(refreshToken)
+	t, err := m.repo.GetRefreshToken(ctx, hash)
+	if err != nil {
+		log.Clog(ctx).Error("Error while retrieving refresh token", log.Fields{"clientId": c.ID, "error": err.Error()})
+		return nil, fmt.Errorf("get refresh token: %w", err)
+	}
+	if t == nil || t.ClientID != c.ID || time.Now().After(t.ExpiresAt) {
+		return nil, InvalidGrantError
+	}
+	if t.RevokedAt != nil {
+		m.revokeReused(ctx, t)
+		return nil, InvalidGrantError
+	}
+
+	accessScope := t.Scope
+	if strings.TrimSpace(scope) != "" {
+		var ok bool
+		if accessScope, ok = oauth.SubScope(scope, strings.Fields(t.Scope)); !ok {
+			return nil, InvalidScopeError
+		}
+	}
+
+	u, err := m.users.GetUser(ctx, t.UserID)
+	if err != nil || u.Disabled {
+		return nil, InvalidGrantError
+	}
+	if u.PasswordChangedAt.After(t.CreatedAt) {
+		return nil, InvalidGrantError
+	}
+
+	// the token may be used concurrently, only one of the requests revokes it
+	revoked, err := m.repo.RevokeRefreshToken(ctx, hash)
+	if err != nil {
+		log.Clog(ctx).Error("Error while revoking refresh token", log.Fields{"clientId": c.ID, "error": err.Error()})
+		return nil, fmt.Errorf("revoke refresh token: %w", err)
+	}
+	if revoked == nil {
+		m.revokeReused(ctx, t)
+		return nil, InvalidGrantError
+	}
+	return m.issueUserTokens(ctx, c, u, accessScope, t.Scope, nil)
+}
+
+// revokeReused revokes all the refresh tokens of the client and the user after a revoked one was presented.
+func (m *Manager) revokeReused(ctx context.Context, t *oauth.RefreshToken) {
+	n, err := m.repo.RevokeRefreshTokens(ctx, t.ClientID, t.UserID)
+	if err != nil {
+		log.Clog(ctx).Error("Error while revoking refresh tokens", log.Fields{"clientId": t.ClientID, "userId": t.UserID, "error": err.Error()})
+		return
+	}
+	log.Clog(ctx).Warn("Revoked refresh token reused", log.Fields{"clientId": t.ClientID, "userId": t.UserID, "revoked": n})
+	m.auditor.Record(ctx, &audit.Event{
+		TargetID: audit.UserRef(t.UserID),
+		Action:   audit.ActionRefreshTokenReused,
+		Details:  map[string]interface{}{"clientId": t.ClientID, "revoked": n},
+	})
+}
+
+// ClientCredentials is the client_credentials grant, the token is issued to the confidential client itself.
+func (m *Manager) ClientCredentials(ctx context.Context, c *oauth.Client, scope string) (*oauth.Token, error) {
+	if c.Public || !c.AllowsGrant(oauth.GrantClientCredentials) {
+		return nil, UnauthorizedClientError
+	}
+	granted, ok := c.GrantScope(scope)
+	if !ok {
+		return nil, InvalidScopeError
+	}
+	access, err := m.issuer.Issue(ctx, nil, c.ID, granted, m.cfg.AccessTokenTTL)
+	if err != nil {
+		log.Clog(ctx).Error("Cant issue token", log.Fields{"clientId": c.ID, "error": err.Error()})
+		return nil, fmt.Errorf("issue access token: %w", err)
+	}
+	return &oauth.Token{
+		AccessToken: access,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(m.cfg.AccessTokenTTL.Seconds()),
+		Scope:       granted,
+	}, nil
+}
+
+// issueUserTokens issues the access token, the ID token for the openid scope and, if the client may refresh it,
+// the refresh token. The code is nil on refresh, the ID token has no nonce and auth_time then.
+func (m *Manager) issueUserTokens(ctx context.Context, c *oauth.Client, u *users.User, scope, refreshScope string, ac *oauth.AuthCode) (*oauth.Token, error) {
+	access, err := m.issuer.Issue(ctx, u, c.ID, scope, m.cfg.AccessTokenTTL)
+	if err != nil {
+		log.Clog(ctx).Error("Cant issue token", log.Fields{"clientId": c.ID, "userId": u.ID, "error": err.Error()})
+		return nil, fmt.Errorf("issue access token: %w", err)
+	}
+	token := &oauth.Token{
+		AccessToken: access,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(m.cfg.AccessTokenTTL.Seconds()),
+		Scope:       scope,
+	}
+	if oauth.HasScope(scope, oauth.ScopeOpenID) {
+		if token.IDToken, err = m.idToken(c, u, scope, access, ac); err != nil {
+			log.Clog(ctx).Error("Cant issue ID token", log.Fields{"clientId": c.ID, "userId": u.ID, "error": err.Error()})
+			return nil, fmt.Errorf("issue id token: %w", err)
+		}
+	}
+	if !c.AllowsGrant(oauth.GrantRefreshToken) {
+		return token, nil
+	}
+
+	refresh := oauth.RandomToken(32)
+	err = m.repo.AddRefreshToken(ctx, &oauth.RefreshToken{
+		Hash:      oauth.Hash(refresh),
+		ClientID:  c.ID,
+		UserID:    u.ID,
+		Scope:     refreshScope,
+		ExpiresAt: time.Now().Add(m.cfg.RefreshTokenTTL),
+	})
+	if err != nil {
+		log.Clog(ctx).Error("Error while issuing refresh token", log.Fields{"clientId": c.ID, "userId": u.ID, "error": err.Error()})
+		return nil, fmt.Errorf("issue refresh token: %w", err)
+	}
+	token.RefreshToken = refresh
+	return token, nil
+}
+
+func (m *Manager) idToken(c *oauth.Client, u *users.User, scope, access string, ac *oauth.AuthCode) (string, error) {
+	now := time.Now()
+	claims := &oauth.IDTokenClaims{
+		UserInfo:  oauth.NewUserInfo(u, scope),
+		Issuer:    m.discovery.Issuer,
+		Audience:  c.ID,
+		ExpiresAt: now.Add(m.cfg.AccessTokenTTL).Unix(),
+		IssuedAt:  now.Unix(),
+		AtHash:    oauth.AccessTokenHash(access),
+	}
+	if ac != nil {
+		claims.AuthTime = ac.AuthTime.Unix()
+		claims.Nonce = ac.Nonce
+	}
+	return m.keys.Sign(claims)
+}
+
+// UserInfo returns the claims of the user released by the scope of the access token.
+func (m *Manager) UserInfo(ctx context.Context, userID uint32, scope string) (*oauth.UserInfo, error) {
+	u, err := m.users.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return oauth.NewUserInfo(u, scope), nil
+}
+
+// Sessions returns the refresh tokens issued to the client applications of the user.
+// Tokens of /login and /signup are not stored, so they are not listed.
+func (m *Manager) Sessions(ctx context.Context, userID uint32) ([]*oauth.RefreshToken, error) {
+	items, err := m.repo.ListRefreshTokens(ctx, userID)
+	if err != nil {
+		log.Clog(ctx).Error("Error while listing refresh tokens", log.Fields{"userId": userID, "error": err.Error()})
+		return nil, fmt.Errorf("list sessions: %w", err)
+	}
+	return items, nil
+}
+
+// Run deletes expired codes and refresh tokens every interval until ctx is done.
+func (m *Manager) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if n, err := m.repo.DeleteExpired(ctx); err != nil {
+			log.Error("Error while deleting expired oauth grants", log.Fields{"error": err.Error()})
+		} else if n > 0 {
+			log.Info("Expired oauth grants deleted", log.Fields{"count": n})
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
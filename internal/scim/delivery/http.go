@@ -0,0 +1,300 @@
+package delivery
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Ollub/user_service/internal/middleware"
+	"github.com/Ollub/user_service/internal/scim"
+	"github.com/Ollub/user_service/internal/users"
+	"github.com/Ollub/user_service/internal/users/usecase"
+	"github.com/Ollub/user_service/pkg/log"
+	"github.com/Ollub/user_service/pkg/utils/http_utils"
+	"github.com/Ollub/user_service/pkg/utils/password"
+	"github.com/gorilla/mux"
+)
+
+type Users interface {
+	GetUser(ctx context.Context, userId uint32) (*users.User, error)
+	ListPage(ctx context.Context, f *users.Filter, offset, limit int) ([]*users.User, int, error)
+	CreateAccount(ctx context.Context, a *users.Account, provider string) (*users.User, error)
+	UpdateAccount(ctx context.Context, userId uint32, a *users.Account, provider string) (*users.User, error)
+	DeleteUser(ctx context.Context, userId uint32, provider string) error
+}
+
+// Handler is the SCIM 2.0 API of the provisioning clients, see RFC 7644.
+// The errors are SCIM error responses, not the problem details of the other endpoints.
+type Handler struct {
+	users  Users
+	policy *password.Policy
+	// sha256 of the tokens, so they are compared in constant time
+	tokens     [][]byte
+	location   string
+	maxResults int
+}
+
+func NewHandler(userManager Users, passwordPolicy *password.Policy, cfg *scim.Config) *Handler {
+	h := &Handler{
+		users:      userManager,
+		policy:     passwordPolicy,
+		location:   strings.TrimSuffix(cfg.BaseURL, "/") + "/scim/v2",
+		maxResults: cfg.MaxResults,
+	}
+	for _, token := range cfg.Tokens {
+		sum := sha256.Sum256([]byte(token))
+		h.tokens = append(h.tokens, sum[:])
+	}
+	return h
+}
+
+// Authenticate rejects requests without one of the SCIM_TOKENS, the API is disabled if there are none.
+func (h *Handler) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := middleware.BearerToken(r)
+		sum := sha256.Sum256([]byte(token))
+		ok := false
+		for _, t := range h.tokens {
+			if subtle.ConstantTimeCompare(t, sum[:]) == 1 {
+				ok = true
+			}
+		}
+		if token == "" || !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="scim"`)
+			writeError(w, http.StatusUnauthorized, "", "Invalid or missing bearer token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *Handler) ServiceProviderConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, scim.ServiceProviderConfig(h.location, h.maxResults), http.StatusOK)
+}
+
+func (h *Handler) ResourceTypes(w http.ResponseWriter, r *http.Request) {
+	types := scim.ResourceTypes(h.location)
+	writeJSON(w, scim.NewListResponse(types, len(types), 1, len(types)), http.StatusOK)
+}
+
+func (h *Handler) Schemas(w http.ResponseWriter, r *http.Request) {
+	schemas := scim.Schemas(h.location)
+	writeJSON(w, scim.NewListResponse(schemas, len(schemas), 1, len(schemas)), http.StatusOK)
+}
+
+// ListUsers supports the filter, startIndex and count params, see RFC 7644 section 3.4.2.
+func (h *Handler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	query := r.URL.Query()
+	startIndex, count := 1, h.maxResults
+	if v := query.Get("startIndex"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, scim.ErrInvalidValue, "startIndex should be an integer")
+			return
+		}
+		// values less than 1 are interpreted as 1
+		if n > 1 {
+			startIndex = n
+		}
+	}
+	if v := query.Get("count"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, scim.ErrInvalidValue, "count should be an integer")
+			return
+		}
+		if n < 0 {
+			n = 0
+		}
+		if n < count {
+			count = n
+		}
+	}
+	var filter *users.Filter
+	if v := query.Get("filter"); v != "" {
+		f, err := scim.ParseFilter(v)
+		if err != nil {
+			h.error(w, r, err)
+			return
+		}
+		filter = f
+	}
+
+	items, total, err := h.users.ListPage(ctx, filter, startIndex-1, count)
+	if err != nil {
+		h.error(w, r, err)
+		return
+	}
+	resources := make([]*scim.User, 0, len(items))
+	for _, u := range items {
+		resources = append(resources, scim.NewUser(u, h.location+"/Users"))
+	}
+	writeJSON(w, scim.NewListResponse(resources, total, startIndex, len(resources)), http.StatusOK)
+}
+
+func (h *Handler) GetUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := userID(r)
+	if !ok {
+		h.error(w, r, usecase.UserNotFoundError)
+		return
+	}
+	u, err := h.users.GetUser(r.Context(), id)
+	if err != nil {
+		h.error(w, r, err)
+		return
+	}
+	writeJSON(w, scim.NewUser(u, h.location+"/Users"), http.StatusOK)
+}
+
+func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) {
+	in := &scim.User{}
+	if err := decode(r, in); err != nil {
+		h.error(w, r, err)
+		return
+	}
+	a := in.Account()
+	if err := h.validate(a); err != nil {
+		h.error(w, r, err)
+		return
+	}
+	u, err := h.users.CreateAccount(r.Context(), a, scim.Provider)
+	if err != nil {
+		h.error(w, r, err)
+		return
+	}
+	out := scim.NewUser(u, h.location+"/Users")
+	w.Header().Set("Location", out.Meta.Location)
+	writeJSON(w, out, http.StatusCreated)
+}
+
+// ReplaceUser is PUT, attributes missing in the request are cleared except the password.
+func (h *Handler) ReplaceUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := userID(r)
+	if !ok {
+		h.error(w, r, usecase.UserNotFoundError)
+		return
+	}
+	in := &scim.User{}
+	if err := decode(r, in); err != nil {
+		h.error(w, r, err)
+		return
+	}
+	h.update(w, r, id, in)
+}
+
+func (h *Handler) PatchUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, ok := userID(r)
+	if !ok {
+		h.error(w, r, usecase.UserNotFoundError)
+		return
+	}
+	req := &scim.PatchRequest{}
+	if err := decode(r, req); err != nil {
+		h.error(w, r, err)
+		return
+	}
+	u, err := h.users.GetUser(ctx, id)
+	if err != nil {
+		h.error(w, r, err)
+		return
+	}
+	current := scim.NewUser(u, h.location+"/Users")
+	if err := current.Apply(req.Operations); err != nil {
+		h.error(w, r, err)
+		return
+	}
+	h.update(w, r, id, current)
+}
+
+func (h *Handler) update(w http.ResponseWriter, r *http.Request, id uint32, in *scim.User) {
+	a := in.Account()
+	if err := h.validate(a); err != nil {
+		h.error(w, r, err)
+		return
+	}
+	u, err := h.users.UpdateAccount(r.Context(), id, a, scim.Provider)
+	if err != nil {
+		h.error(w, r, err)
+		return
+	}
+	writeJSON(w, scim.NewUser(u, h.location+"/Users"), http.StatusOK)
+}
+
+func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := userID(r)
+	if !ok {
+		h.error(w, r, usecase.UserNotFoundError)
+		return
+	}
+	if err := h.users.DeleteUser(r.Context(), id, scim.Provider); err != nil {
+		h.error(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) validate(a *users.Account) error {
+	if !scim.IsEmail(a.Email) {
+		return &scim.BadRequestError{ScimType: scim.ErrInvalidValue, Detail: "userName or the primary email has to be an email address"}
+	}
+	if a.Password == "" {
+		return nil
+	}
+	if violations := h.policy.Check(a.Password, a.Email, a.FirstName, a.LastName); len(violations) > 0 {
+		return &scim.BadRequestError{ScimType: scim.ErrInvalidValue, Detail: "password: " + violations[0].Message}
+	}
+	return nil
+}
+
+func (h *Handler) error(w http.ResponseWriter, r *http.Request, err error) {
+	var badRequest *scim.BadRequestError
+	switch {
+	case errors.As(err, &badRequest):
+		writeError(w, http.StatusBadRequest, badRequest.ScimType, badRequest.Detail)
+	case err == usecase.UserNotFoundError:
+		writeError(w, http.StatusNotFound, "", "User not found")
+	case err == usecase.UserExistsError:
+		writeError(w, http.StatusConflict, scim.ErrUniqueness, "A user with the userName already exists")
+	case err == usecase.ServiceBusyError:
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusServiceUnavailable, "", "Service is busy, try again later")
+	default:
+		log.Clog(r.Context()).Error("Error during scim request", log.Fields{"err": err.Error()})
+		writeError(w, http.StatusInternalServerError, "", "Internal error")
+	}
+}
+
+func userID(r *http.Request) (uint32, bool) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	return uint32(id), err == nil
+}
+
+func decode(r *http.Request, v interface{}) error {
+	defer r.Body.Close()
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(v); err != nil {
+		return &scim.BadRequestError{ScimType: scim.ErrInvalidSyntax, Detail: "The request body is not valid JSON"}
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}, status int) {
+	w.Header().Set("Content-Type", scim.ContentType)
+	http_utils.JsonResp(w, v, status)
+}
+
+func writeError(w http.ResponseWriter, status int, scimType, detail string) {
+	writeJSON(w, &scim.Error{
+		Schemas:  []string{scim.SchemaError},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   detail,
+	}, status)
+}
@@ -0,0 +1,104 @@
+package scim
+
+// Documents of the discovery endpoints, see RFC 7644 section 4.
+
+type object = map[string]interface{}
+
+// ServiceProviderConfig describes the supported features, location is the url of the SCIM API.
+func ServiceProviderConfig(location string, maxResults int) object {
+	return object{
+		"schemas":        []string{SchemaServiceProviderConfig},
+		"patch":          object{"supported": true},
+		"bulk":           object{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         object{"supported": true, "maxResults": maxResults},
+		"changePassword": object{"supported": true},
+		"sort":           object{"supported": false},
+		"etag":           object{"supported": false},
+		"authenticationSchemes": []object{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer token",
+			"description": "A token of SCIM_TOKENS in the Authorization header",
+			"primary":     true,
+		}},
+		"meta": object{"resourceType": "ServiceProviderConfig", "location": location + "/ServiceProviderConfig"},
+	}
+}
+
+func ResourceTypes(location string) []object {
+	return []object{{
+		"schemas":     []string{SchemaResourceType},
+		"id":          "User",
+		"name":        "User",
+		"endpoint":    "/Users",
+		"description": "User Account",
+		"schema":      SchemaUser,
+		"meta":        object{"resourceType": "ResourceType", "location": location + "/ResourceTypes/User"},
+	}}
+}
+
+// Schemas describes the attributes of the user the service stores, others are ignored.
+func Schemas(location string) []object {
+	return []object{{
+		"schemas":     []string{SchemaSchema},
+		"id":          SchemaUser,
+		"name":        "User",
+		"description": "User Account",
+		"attributes": []object{
+			attribute("userName", "string", true, "readWrite", "server", "The email of the user"),
+			attribute("externalId", "string", false, "readWrite", "none", "Id of the user in the provisioning client"),
+			{
+				"name":        "name",
+				"type":        "complex",
+				"multiValued": false,
+				"required":    false,
+				"mutability":  "readWrite",
+				"returned":    "default",
+				"subAttributes": []object{
+					attribute("formatted", "string", false, "readWrite", "none", "Full name, split if there are no name parts"),
+					attribute("givenName", "string", false, "readWrite", "none", "First name"),
+					attribute("familyName", "string", false, "readWrite", "none", "Last name"),
+				},
+			},
+			attribute("displayName", "string", false, "readOnly", "none", "Full name"),
+			{
+				"name":        "emails",
+				"type":        "complex",
+				"multiValued": true,
+				"required":    false,
+				"mutability":  "readWrite",
+				"returned":    "default",
+				"description": "The single email of the user, used if userName is not an email",
+				"subAttributes": []object{
+					attribute("value", "string", false, "readWrite", "server", "Email"),
+					attribute("type", "string", false, "readWrite", "none", "Always work"),
+					attribute("primary", "boolean", false, "readWrite", "none", "Always true"),
+				},
+			},
+			attribute("active", "boolean", false, "readWrite", "none", "Inactive users can't sign in"),
+			{
+				"name":        "password",
+				"type":        "string",
+				"multiValued": false,
+				"required":    false,
+				"mutability":  "writeOnly",
+				"returned":    "never",
+				"description": "Password of the service, users without one sign in with an external provider",
+			},
+		},
+		"meta": object{"resourceType": "Schema", "location": location + "/Schemas/" + SchemaUser},
+	}}
+}
+
+func attribute(name, typ string, required bool, mutability, uniqueness, description string) object {
+	return object{
+		"name":        name,
+		"type":        typ,
+		"multiValued": false,
+		"required":    required,
+		"caseExact":   false,
+		"mutability":  mutability,
+		"returned":    "default",
+		"uniqueness":  uniqueness,
+		"description": description,
+	}
+}
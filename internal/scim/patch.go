@@ -0,0 +1,160 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Ollub/user_service/internal/users"
+)
+
+// Error types of RFC 7644 section 3.12.
+const (
+	ErrInvalidFilter = "invalidFilter"
+	ErrInvalidSyntax = "invalidSyntax"
+	ErrInvalidValue  = "invalidValue"
+	ErrMutability    = "mutability"
+	ErrUniqueness    = "uniqueness"
+)
+
+// BadRequestError is a request the client has to fix, ScimType is one of the Err* error types.
+type BadRequestError struct {
+	ScimType string
+	Detail   string
+}
+
+func (e *BadRequestError) Error() string {
+	return e.ScimType + ": " + e.Detail
+}
+
+func badRequest(scimType, format string, args ...interface{}) *BadRequestError {
+	return &BadRequestError{ScimType: scimType, Detail: fmt.Sprintf(format, args...)}
+}
+
+// Apply runs the PATCH operations against the user. Attributes the service doesn't store are ignored,
+// like they are on create and replace.
+func (u *User) Apply(ops []PatchOperation) error {
+	for _, op := range ops {
+		switch strings.ToLower(op.Op) {
+		case "add", "replace":
+			if op.Path != "" {
+				if err := u.set(op.Path, op.Value); err != nil {
+					return err
+				}
+				continue
+			}
+			// without a path the value holds the attributes to set
+			var attrs map[string]json.RawMessage
+			if err := json.Unmarshal(op.Value, &attrs); err != nil {
+				return badRequest(ErrInvalidValue, "operation without path needs an object value")
+			}
+			for path, value := range attrs {
+				if err := u.set(path, value); err != nil {
+					return err
+				}
+			}
+		case "remove":
+			if err := u.remove(op.Path); err != nil {
+				return err
+			}
+		default:
+			return badRequest(ErrInvalidSyntax, "unknown operation %q", op.Op)
+		}
+	}
+	return nil
+}
+
+func (u *User) set(path string, value json.RawMessage) error {
+	var err error
+	switch p := attrPath(path); {
+	case p == "username":
+		err = json.Unmarshal(value, &u.UserName)
+	case p == "externalid":
+		err = json.Unmarshal(value, &u.ExternalID)
+	case p == "active":
+		err = json.Unmarshal(value, &u.Active)
+	case p == "password":
+		err = json.Unmarshal(value, &u.Password)
+	case p == "name":
+		if u.Name == nil {
+			u.Name = &Name{}
+		}
+		err = json.Unmarshal(value, u.Name)
+	case strings.HasPrefix(p, "name."):
+		if u.Name == nil {
+			u.Name = &Name{}
+		}
+		switch p {
+		case "name.givenname":
+			err = json.Unmarshal(value, &u.Name.GivenName)
+		case "name.familyname":
+			err = json.Unmarshal(value, &u.Name.FamilyName)
+		case "name.formatted":
+			err = json.Unmarshal(value, &u.Name.Formatted)
+		}
+	case p == "emails":
+		var emails []Email
+		if err = json.Unmarshal(value, &emails); err == nil && len(emails) > 0 {
+			u.Emails = emails
+		}
+	case strings.HasPrefix(p, "emails") && strings.HasSuffix(p, ".value"):
+		// e.g. emails[type eq "work"].value, the user has a single email
+		var email string
+		if err = json.Unmarshal(value, &email); err == nil {
+			u.Emails = []Email{{Value: email, Type: "work", Primary: true}}
+		}
+	}
+	if err != nil {
+		return badRequest(ErrInvalidValue, "invalid value of %s", path)
+	}
+	return nil
+}
+
+func (u *User) remove(path string) error {
+	switch p := attrPath(path); {
+	case p == "":
+		return badRequest(ErrInvalidSyntax, "remove operation needs a path")
+	case p == "username" || strings.HasPrefix(p, "emails"):
+		return badRequest(ErrMutability, "%s is required", path)
+	case p == "externalid":
+		u.ExternalID = ""
+	case p == "active":
+		u.Active = nil
+	case p == "name":
+		u.Name = nil
+	case p == "name.givenname" && u.Name != nil:
+		u.Name.GivenName = ""
+	case p == "name.familyname" && u.Name != nil:
+		u.Name.FamilyName = ""
+	}
+	return nil
+}
+
+// attrPath lowercases the attribute path and strips the core user schema from it.
+func attrPath(path string) string {
+	p := strings.ToLower(strings.TrimSpace(path))
+	return strings.TrimPrefix(p, strings.ToLower(SchemaUser)+":")
+}
+
+var filterRe = regexp.MustCompile(`(?i)^\s*([a-z0-9.:]+)\s+eq\s+("(?:[^"\\]|\\.)*")\s*$`)
+
+// ParseFilter supports a single eq comparison of userName, emails or externalId,
+// that is what provisioning clients use to find the existing users.
+func ParseFilter(filter string) (*users.Filter, error) {
+	m := filterRe.FindStringSubmatch(filter)
+	if m == nil {
+		return nil, badRequest(ErrInvalidFilter, "only `attribute eq \"value\"` filters are supported")
+	}
+	var value string
+	if err := json.Unmarshal([]byte(m[2]), &value); err != nil {
+		return nil, badRequest(ErrInvalidFilter, "invalid filter value")
+	}
+	switch attrPath(m[1]) {
+	case "username", "emails", "emails.value":
+		return &users.Filter{EmailExact: value}, nil
+	case "externalid":
+		return &users.Filter{ExternalID: value}, nil
+	}
+	return nil, badRequest(ErrInvalidFilter, "filtering by %s is not supported", m[1])
+}
@@ -0,0 +1,195 @@
+package scim
+
+import (
+	"encoding/json"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ollub/user_service/internal/users"
+)
+
+const (
+	SchemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+	SchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	SchemaResourceType          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	SchemaSchema                = "urn:ietf:params:scim:schemas:core:2.0:Schema"
+
+	ContentType = "application/scim+json"
+	// Provider recorded in the audit events of the changes made over SCIM
+	Provider = "scim"
+)
+
+type Config struct {
+	// Bearer tokens of the provisioning clients, empty disables the SCIM API
+	Tokens []string `envconfig:"SCIM_TOKENS"`
+	// Public url of the service used in the resource locations, OIDC_ISSUER is used if empty
+	BaseURL    string `envconfig:"SCIM_BASE_URL"`
+	MaxResults int    `envconfig:"SCIM_MAX_RESULTS" default:"200"`
+}
+
+// User is the SCIM core user, see RFC 7643 section 4.1. The email of the user is both the userName
+// and the single primary email.
+type User struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	ExternalID  string   `json:"externalId,omitempty"`
+	UserName    string   `json:"userName"`
+	Name        *Name    `json:"name,omitempty"`
+	DisplayName string   `json:"displayName,omitempty"`
+	Emails      []Email  `json:"emails,omitempty"`
+	Active      *Bool    `json:"active,omitempty"`
+	// Write only, never returned
+	Password string `json:"password,omitempty"`
+	Meta     *Meta  `json:"meta,omitempty"`
+}
+
+type Name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type Email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary Bool   `json:"primary,omitempty"`
+}
+
+type Meta struct {
+	ResourceType string     `json:"resourceType"`
+	Created      *time.Time `json:"created,omitempty"`
+	LastModified *time.Time `json:"lastModified,omitempty"`
+	Location     string     `json:"location,omitempty"`
+	Version      string     `json:"version,omitempty"`
+}
+
+// Bool also accepts the "True" and "False" strings some clients send instead of booleans.
+type Bool bool
+
+func (b *Bool) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	v, err := strconv.ParseBool(strings.ToLower(s))
+	if err != nil {
+		return err
+	}
+	*b = Bool(v)
+	return nil
+}
+
+type ListResponse struct {
+	Schemas      []string    `json:"schemas"`
+	TotalResults int         `json:"totalResults"`
+	StartIndex   int         `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Resources    interface{} `json:"Resources"`
+}
+
+func NewListResponse(resources interface{}, total, startIndex, count int) *ListResponse {
+	return &ListResponse{
+		Schemas:      []string{SchemaListResponse},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: count,
+		Resources:    resources,
+	}
+}
+
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// Error is the SCIM error response, see RFC 7644 section 3.12.
+type Error struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail"`
+}
+
+// NewUser maps the user to the SCIM resource, location is the url of the users endpoint.
+func NewUser(u *users.User, location string) *User {
+	active := Bool(!u.Disabled)
+	created, modified := u.CreatedAt, u.UpdatedAt
+	out := &User{
+		Schemas:    []string{SchemaUser},
+		ID:         strconv.FormatUint(uint64(u.ID), 10),
+		ExternalID: u.ExternalID,
+		UserName:   u.Email,
+		Name: &Name{
+			Formatted:  strings.TrimSpace(u.FirstName + " " + u.LastName),
+			GivenName:  u.FirstName,
+			FamilyName: u.LastName,
+		},
+		DisplayName: strings.TrimSpace(u.FirstName + " " + u.LastName),
+		Emails:      []Email{{Value: u.Email, Type: "work", Primary: true}},
+		Active:      &active,
+		Meta: &Meta{
+			ResourceType: "User",
+			Location:     location + "/" + strconv.FormatUint(uint64(u.ID), 10),
+			Version:      `W/"` + strconv.Itoa(u.Ver) + `"`,
+		},
+	}
+	if !created.IsZero() {
+		out.Meta.Created = &created
+	}
+	if !modified.IsZero() {
+		out.Meta.LastModified = &modified
+	}
+	return out
+}
+
+// Account maps the SCIM resource to the user. The userName is used as the email if it is an email address,
+// otherwise the primary email. The user is active unless active is false.
+func (u *User) Account() *users.Account {
+	a := &users.Account{
+		Email:      u.UserName,
+		ExternalID: u.ExternalID,
+		Disabled:   u.Active != nil && !bool(*u.Active),
+		Password:   u.Password,
+	}
+	if !IsEmail(a.Email) && u.primaryEmail() != "" {
+		a.Email = u.primaryEmail()
+	}
+	a.Email = strings.ToLower(strings.TrimSpace(a.Email))
+	if u.Name != nil {
+		a.FirstName, a.LastName = u.Name.GivenName, u.Name.FamilyName
+		if a.FirstName == "" && a.LastName == "" {
+			parts := strings.SplitN(strings.TrimSpace(u.Name.Formatted), " ", 2)
+			a.FirstName = parts[0]
+			if len(parts) > 1 {
+				a.LastName = strings.TrimSpace(parts[1])
+			}
+		}
+	}
+	return a
+}
+
+func (u *User) primaryEmail() string {
+	for _, e := range u.Emails {
+		if e.Primary {
+			return e.Value
+		}
+	}
+	if len(u.Emails) > 0 {
+		return u.Emails[0].Value
+	}
+	return ""
+}
+
+// IsEmail reports whether s is a bare email address.
+func IsEmail(s string) bool {
+	addr, err := mail.ParseAddress(s)
+	return err == nil && addr.Address == s
+}
@@ -0,0 +1,78 @@
+package delivery
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+)
+
+// identityHeaders are passed to the upstream of allowed requests, values sent by the client are overwritten.
+var identityHeaders = []string{UserIDHeader, UserEmailHeader, UserRolesHeader}
+
+// ExtAuthzServer implements the Envoy external authorization service (envoy.service.auth.v3.Authorization).
+// The request headers are checked exactly like by the forward-auth endpoint Verify.
+type ExtAuthzServer struct {
+	authv3.UnimplementedAuthorizationServer
+	h *Handler
+}
+
+func NewExtAuthzServer(handler *Handler) *ExtAuthzServer {
+	return &ExtAuthzServer{h: handler}
+}
+
+func (s *ExtAuthzServer) Check(ctx context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, "/auth/verify", nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range req.GetAttributes().GetRequest().GetHttp().GetHeaders() {
+		r.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	s.h.Verify(w, r)
+
+	if w.Code == http.StatusOK {
+		headers := make([]*corev3.HeaderValueOption, len(identityHeaders))
+		for i, name := range identityHeaders {
+			headers[i] = headerOption(name, w.Header().Get(name))
+		}
+		return &authv3.CheckResponse{
+			Status: &rpcstatus.Status{Code: int32(codes.OK)},
+			HttpResponse: &authv3.CheckResponse_OkResponse{
+				OkResponse: &authv3.OkHttpResponse{Headers: headers},
+			},
+		}, nil
+	}
+
+	var headers []*corev3.HeaderValueOption
+	for name := range w.Header() {
+		headers = append(headers, headerOption(name, w.Header().Get(name)))
+	}
+	code := codes.PermissionDenied
+	if w.Code == http.StatusUnauthorized {
+		code = codes.Unauthenticated
+	}
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{Code: int32(code)},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{
+			DeniedResponse: &authv3.DeniedHttpResponse{
+				Status:  &typev3.HttpStatus{Code: typev3.StatusCode(w.Code)},
+				Headers: headers,
+				Body:    w.Body.String(),
+			},
+		},
+	}, nil
+}
+
+func headerOption(name, value string) *corev3.HeaderValueOption {
+	return &corev3.HeaderValueOption{
+		Header:       &corev3.HeaderValue{Key: name, Value: value},
+		AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+	}
+}
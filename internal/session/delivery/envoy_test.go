@@ -0,0 +1,183 @@
+package delivery
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Ollub/user_service/internal/audit"
+	"github.com/Ollub/user_service/internal/middleware"
+	"github.com/Ollub/user_service/internal/session"
+	"github.com/Ollub/user_service/internal/users"
+	"github.com/Ollub/user_service/internal/users/usecase"
+	"github.com/Ollub/user_service/pkg/utils/http_utils"
+	"github.com/Ollub/user_service/pkg/utils/password"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"google.golang.org/grpc/codes"
+)
+
+// userRepo serves the users by id, the methods the tests don't need panic on the nil Repo.
+type userRepo struct {
+	usecase.Repo
+	users map[uint32]*users.User
+}
+
+func (r *userRepo) GetByID(_ context.Context, id uint32) (*users.User, error) {
+	u, ok := r.users[id]
+	if !ok {
+		return nil, nil
+	}
+	found := *u
+	return &found, nil
+}
+
+type clients struct{}
+
+func (clients) ClientExists(context.Context, string) (bool, error) {
+	return true, nil
+}
+
+type nopAuditor struct{}
+
+func (nopAuditor) Record(context.Context, *audit.Event) {}
+
+func newTestServer(t *testing.T, us ...*users.User) (*ExtAuthzServer, *session.SessionsJWTVer) {
+	repo := &userRepo{users: map[uint32]*users.User{}}
+	for _, u := range us {
+		repo.users[u.ID] = u
+	}
+	argon := &password.ArgonParams{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	userManager := usecase.NewManager(repo, nopAuditor{}, nil, argon, 0, 0)
+	sessions := session.NewSessionsJWTVer([]byte("secret"), 1, userManager, nopAuditor{})
+	sessions.Clients = clients{}
+	return NewExtAuthzServer(NewHandler(sessions, nil, "auth_token")), sessions
+}
+
+func checkRequest(headers map[string]string) *authv3.CheckRequest {
+	return &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{Method: http.MethodGet, Path: "/orders", Headers: headers},
+			},
+		},
+	}
+}
+
+func issue(t *testing.T, sessions *session.SessionsJWTVer, u *users.User) string {
+	t.Helper()
+	token, err := sessions.Issue(context.Background(), u, "", "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestExtAuthzAllow(t *testing.T) {
+	user := &users.User{ID: 7, Email: "john@doe.com", Role: "admin", PasswordChangedAt: time.Now()}
+	s, sessions := newTestServer(t, user)
+
+	// envoy passes the header names lowercased, spoofed identity headers have to be replaced
+	resp, err := s.Check(context.Background(), checkRequest(map[string]string{
+		"authorization": "Bearer " + issue(t, sessions, user),
+		"x-user-id":     "1",
+		"x-user-roles":  "superuser",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if codes.Code(resp.Status.Code) != codes.OK {
+		t.Fatalf("status %v", resp.Status)
+	}
+	ok := resp.GetOkResponse()
+	if ok == nil {
+		t.Fatalf("no ok response in %v", resp)
+	}
+	want := map[string]string{
+		UserIDHeader:    strconv.Itoa(int(user.ID)),
+		UserEmailHeader: user.Email,
+		UserRolesHeader: user.Role,
+	}
+	if len(ok.Headers) != len(want) {
+		t.Fatalf("headers %v, want %v", ok.Headers, want)
+	}
+	for _, h := range ok.Headers {
+		if h.Header.Value != want[h.Header.Key] {
+			t.Errorf("header %s = %q, want %q", h.Header.Key, h.Header.Value, want[h.Header.Key])
+		}
+		if h.AppendAction != corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD {
+			t.Errorf("header %s is appended", h.Header.Key)
+		}
+	}
+}
+
+func TestExtAuthzDeny(t *testing.T) {
+	user := &users.User{ID: 7, Email: "john@doe.com", PasswordChangedAt: time.Now()}
+	restricted := &users.User{ID: 8, Email: "jane@doe.com", PassHash: "hash", PasswordChangedAt: time.Now(), MustChangePassword: true}
+	s, sessions := newTestServer(t, user, restricted)
+	oauthToken, err := sessions.Issue(context.Background(), user, "c_test", "openid", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		headers map[string]string
+		code    codes.Code
+		status  int
+		problem string
+	}{
+		{"missing token", map[string]string{}, codes.Unauthenticated, http.StatusUnauthorized, http_utils.CodeUnauthorized},
+		{"invalid token", map[string]string{"authorization": "Bearer not-a-token"}, codes.Unauthenticated, http.StatusUnauthorized, http_utils.CodeUnauthorized},
+		{
+			"oauth token",
+			map[string]string{"authorization": "Bearer " + oauthToken},
+			codes.PermissionDenied, http.StatusForbidden, http_utils.CodeForbidden,
+		},
+		{
+			"password change token",
+			map[string]string{"cookie": "auth_token=" + issue(t, sessions, restricted)},
+			codes.PermissionDenied, http.StatusForbidden, middleware.CodePasswordChangeRequired,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			resp, err := s.Check(context.Background(), checkRequest(c.headers))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if codes.Code(resp.Status.Code) != c.code {
+				t.Fatalf("status %v, want %v", resp.Status, c.code)
+			}
+			denied := resp.GetDeniedResponse()
+			if denied == nil {
+				t.Fatalf("no denied response in %v", resp)
+			}
+			if int(denied.Status.Code) != c.status {
+				t.Errorf("http status %d, want %d", denied.Status.Code, c.status)
+			}
+			headers := map[string]string{}
+			for _, h := range denied.Headers {
+				headers[h.Header.Key] = h.Header.Value
+			}
+			if headers["Content-Type"] != http_utils.ProblemContentType || headers["Cache-Control"] != "no-store" {
+				t.Errorf("headers %v", headers)
+			}
+			for _, name := range identityHeaders {
+				if _, ok := headers[name]; ok {
+					t.Errorf("identity header %s in denied response", name)
+				}
+			}
+			var problem http_utils.Problem
+			if err := json.Unmarshal([]byte(denied.Body), &problem); err != nil {
+				t.Fatalf("body %q: %v", denied.Body, err)
+			}
+			if problem.Code != c.problem || problem.Status != c.status {
+				t.Errorf("problem %+v, want code %s", problem, c.problem)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package delivery
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+
+	"github.com/Ollub/user_service/internal/middleware"
+	"github.com/Ollub/user_service/internal/session"
+	"github.com/Ollub/user_service/pkg/log"
+	"github.com/Ollub/user_service/pkg/utils/http_utils"
+)
+
+// Identity headers set by Verify, roles are comma separated.
+const (
+	UserIDHeader    = "X-User-Id"
+	UserEmailHeader = "X-User-Email"
+	UserRolesHeader = "X-User-Roles"
+)
+
+type Handler struct {
+	sessions *session.SessionsJWTVer
+	// client id -> secret of the resource servers allowed to introspect tokens
+	clients map[string]string
+	// cookie checked by Verify when the token header is missing
+	cookieName string
+}
+
+func NewHandler(sm *session.SessionsJWTVer, clients map[string]string, cookieName string) *Handler {
+	return &Handler{sessions: sm, clients: clients, cookieName: cookieName}
+}
+
+// IntrospectResp is the RFC 7662 introspection response, only `active` is set for invalid tokens.
+type IntrospectResp struct {
+	Active    bool   `json:"active"`
+	Sub       string `json:"sub,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Jti       string `json:"jti,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+}
+
+// Introspect tells resource servers whether the token is valid, see RFC 7662.
+// Clients authenticate with HTTP Basic auth, the token is sent in the `token` form field.
+func (h *Handler) Introspect(w http.ResponseWriter, r *http.Request) {
+	clientID, ok := h.authenticateClient(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="introspect"`)
+		http_utils.HttpError(w, r, http_utils.CodeUnauthorized, "Invalid client credentials", http.StatusUnauthorized)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http_utils.HttpError(w, r, http_utils.CodeInvalidPayload, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	token := r.PostForm.Get("token")
+	if token == "" {
+		http_utils.ValidationError(
+			w, r,
+			[]*http_utils.FieldError{http_utils.NewFieldError("token", http_utils.FieldRequired, "required")},
+			http.StatusBadRequest,
+		)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	sess, err := h.sessions.Check(r.Context(), token)
+	if err != nil {
+		log.Clog(r.Context()).Info("Inactive token introspected", log.Fields{"clientId": clientID})
+		http_utils.JsonResp(w, &IntrospectResp{Active: false}, http.StatusOK)
+		return
+	}
+	resp := &IntrospectResp{
+		Active:    true,
+		Sub:       strconv.FormatUint(uint64(sess.UserID), 10),
+		Exp:       sess.ExpiresAt.Unix(),
+		Iat:       sess.IssuedAt.Unix(),
+		Jti:       sess.ID,
+		Scope:     sess.Scope,
+		ClientID:  sess.ClientID,
+		TokenType: "Bearer",
+	}
+	// tokens of the client itself are issued on its own behalf
+	if sess.ClientOnly() {
+		resp.Sub = sess.ClientID
+	}
+	http_utils.JsonResp(w, resp, http.StatusOK)
+}
+
+// Verify is the forward-auth endpoint for nginx `auth_request`, Traefik ForwardAuth and alike.
+// The token is taken from the authentication header, `Authorization: Bearer` or the cookie.
+// Valid tokens get 200 with the user identity headers, which the proxy passes to the upstream.
+func (h *Handler) Verify(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	token := h.tokenFromRequest(r)
+	if token == "" {
+		http_utils.HttpError(w, r, http_utils.CodeUnauthorized, "Missing authentication token", http.StatusUnauthorized)
+		return
+	}
+	sess, err := h.sessions.Check(r.Context(), token)
+	if err != nil {
+		http_utils.HttpError(w, r, http_utils.CodeUnauthorized, "No auth", http.StatusUnauthorized)
+		return
+	}
+	// tokens issued to OAuth clients are for the resource servers introspecting them, see Introspect
+	if sess.ClientID != "" {
+		http_utils.HttpError(w, r, http_utils.CodeForbidden, "OAuth tokens can't be used for the user API", http.StatusForbidden)
+		return
+	}
+	if sess.Scope == session.ScopePasswordChange {
+		http_utils.HttpError(w, r, middleware.CodePasswordChangeRequired, "Password change required", http.StatusForbidden)
+		return
+	}
+	w.Header().Set(UserIDHeader, strconv.FormatUint(uint64(sess.UserID), 10))
+	w.Header().Set(UserEmailHeader, sess.Email)
+	w.Header().Set(UserRolesHeader, sess.Role)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) tokenFromRequest(r *http.Request) string {
+	if token := r.Header.Get(middleware.AutenticationHeader); token != "" {
+		return token
+	}
+	if token := middleware.BearerToken(r); token != "" {
+		return token
+	}
+	if h.cookieName != "" {
+		if cookie, err := r.Cookie(h.cookieName); err == nil {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+func (h *Handler) authenticateClient(r *http.Request) (string, bool) {
+	id, secret, ok := http_utils.ClientBasicAuth(r)
+	if !ok || id == "" {
+		return "", false
+	}
+	expected, ok := h.clients[id]
+	if !ok || expected == "" {
+		return "", false
+	}
+	return id, subtle.ConstantTimeCompare([]byte(secret), []byte(expected)) == 1
+}
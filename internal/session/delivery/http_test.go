@@ -0,0 +1,37 @@
+package delivery
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// The clients form encode the credentials before the Basic auth encoding, see RFC 6749 section 2.3.1.
+func TestAuthenticateClient(t *testing.T) {
+	h := NewHandler(nil, map[string]string{"orders:api": "p@ss w%rd+"}, "auth_token")
+
+	cases := []struct {
+		name       string
+		id, secret string
+		ok         bool
+	}{
+		{"form encoded", url.QueryEscape("orders:api"), url.QueryEscape("p@ss w%rd+"), true},
+		{"raw", "orders:api", "p@ss w%rd+", false},
+		{"wrong secret", url.QueryEscape("orders:api"), "secret", false},
+		{"invalid encoding", url.QueryEscape("orders:api"), "%zz", false},
+		{"empty client", "", "", false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/introspect", nil)
+			r.SetBasicAuth(c.id, c.secret)
+			id, ok := h.authenticateClient(r)
+			if ok != c.ok {
+				t.Fatalf("got ok %v, want %v", ok, c.ok)
+			}
+			if ok && id != "orders:api" {
+				t.Errorf("got client %q", id)
+			}
+		})
+	}
+}
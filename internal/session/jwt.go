@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/Ollub/user_service/internal/audit"
 	"github.com/Ollub/user_service/internal/users"
 	"github.com/Ollub/user_service/internal/users/usecase"
 	"github.com/Ollub/user_service/pkg/log"
@@ -16,23 +17,42 @@ import (
 
 var AuthError = errors.New("authentication error")
 
+type Auditor interface {
+	Record(ctx context.Context, e *audit.Event)
+}
+
+// ClientChecker tells whether an OAuth client is still registered, tokens of deleted clients are rejected.
+type ClientChecker interface {
+	ClientExists(ctx context.Context, id string) (bool, error)
+}
+
 type SessionsJWTVer struct {
 	Secret       []byte
 	TokenTTLDays int
-	users        *usecase.Manager
+	// Clients checks tokens issued to OAuth clients, they are rejected if it is not set
+	Clients ClientChecker
+	users   *usecase.Manager
+	auditor Auditor
+	// audited rejected tokens, they are logged only when replayed
+	rejected *rejections
 }
 
 type SessionJWTVerClaims struct {
-	UserID uint32 `json:"uid"`
-	Ver    int    `json:"ver,omitempty"`
+	// Zero for tokens issued to OAuth clients on their own behalf
+	UserID   uint32 `json:"uid"`
+	Ver      int    `json:"ver,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
 	jwt.StandardClaims
 }
 
-func NewSessionsJWTVer(secret []byte, tokenTTLDays int, manager *usecase.Manager) *SessionsJWTVer {
+func NewSessionsJWTVer(secret []byte, tokenTTLDays int, manager *usecase.Manager, auditor Auditor) *SessionsJWTVer {
 	return &SessionsJWTVer{
 		Secret:       secret,
 		TokenTTLDays: tokenTTLDays,
 		users:        manager,
+		auditor:      auditor,
+		rejected:     newRejections(time.Hour),
 	}
 }
 
@@ -55,35 +75,140 @@ func (sm *SessionsJWTVer) Check(ctx context.Context, token string) (*Session, er
 		return nil, fmt.Errorf("invalid jwt token: %v", err)
 	}
 
-	ver, err := sm.users.GetUserVersion(ctx, payload.UserID)
+	if payload.ClientID != "" && !sm.clientExists(ctx, payload) {
+		return nil, AuthError
+	}
+	if payload.UserID == 0 {
+		if payload.ClientID == "" {
+			return nil, AuthError
+		}
+		return &Session{
+			ID:        payload.Id,
+			ClientID:  payload.ClientID,
+			Scope:     payload.Scope,
+			IssuedAt:  time.Unix(payload.IssuedAt, 0),
+			ExpiresAt: time.Unix(payload.ExpiresAt, 0),
+		}, nil
+	}
+
+	user, err := sm.users.GetUser(ctx, payload.UserID)
 	if err != nil {
 		log.Clog(ctx).Info("Authentication failed for user", log.Fields{"userId": payload.UserID, "error": err})
+		sm.recordRejected(ctx, payload, map[string]interface{}{"reason": err.Error()})
 		return nil, AuthError
 	}
 
-	if payload.Ver != ver {
+	if payload.Ver != user.Ver {
 		log.Clog(ctx).Info(
 			"Provided token with old user version",
-			log.Fields{"userId": payload.UserID, "tokenVer": payload.Ver, "actualVer": ver},
+			log.Fields{"userId": payload.UserID, "tokenVer": payload.Ver, "actualVer": user.Ver},
 		)
+		sm.recordRejected(ctx, payload, map[string]interface{}{"reason": "outdated user version"})
 		return nil, AuthError
 	}
 
+	if user.Disabled {
+		log.Clog(ctx).Info("Provided token of disabled user", log.Fields{"userId": payload.UserID})
+		sm.recordRejected(ctx, payload, map[string]interface{}{"reason": "disabled user"})
+		return nil, AuthError
+	}
+
+	// a password may expire while the token is still valid
+	scope := payload.Scope
+	if sm.users.PasswordChangeRequired(user) {
+		scope = ScopePasswordChange
+	}
+
 	return &Session{
-		ID:     payload.Id,
-		UserID: payload.UserID,
+		ID:        payload.Id,
+		UserID:    payload.UserID,
+		Email:     user.Email,
+		Role:      user.Role,
+		ClientID:  payload.ClientID,
+		Scope:     scope,
+		IssuedAt:  time.Unix(payload.IssuedAt, 0),
+		ExpiresAt: time.Unix(payload.ExpiresAt, 0),
 	}, nil
 }
 
+// Create issues a token of the user, it is restricted to the password change if the user has to change it.
 func (sm *SessionsJWTVer) Create(ctx context.Context, user *users.User) (string, error) {
+	return sm.Issue(ctx, user, "", "", time.Duration(sm.TokenTTLDays)*24*time.Hour)
+}
+
+// Issue issues a token to the OAuth client on behalf of the user with the granted scope.
+// The user is nil for tokens of the client itself. Scope of users who have to change the password
+// is replaced by ScopePasswordChange.
+func (sm *SessionsJWTVer) Issue(ctx context.Context, user *users.User, clientID, scope string, ttl time.Duration) (string, error) {
 	data := SessionJWTVerClaims{
-		UserID: user.ID,
-		Ver:    user.Ver, // изменилось по сравнению со stateless-сессией
+		Scope:    scope,
+		ClientID: clientID,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(time.Duration(sm.TokenTTLDays) * 24 * time.Hour).Unix(),
+			ExpiresAt: time.Now().Add(ttl).Unix(),
 			IssuedAt:  time.Now().Unix(),
 			Id:        utils.RandStringRunes(32),
 		},
 	}
-	return jwt.NewWithClaims(jwt.SigningMethodHS256, data).SignedString(sm.Secret)
+	var userRef *uint32
+	if user != nil {
+		data.UserID = user.ID
+		data.Ver = user.Ver // изменилось по сравнению со stateless-сессией
+		if sm.users.PasswordChangeRequired(user) {
+			data.Scope = ScopePasswordChange
+		}
+		userRef = audit.UserRef(user.ID)
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, data).SignedString(sm.Secret)
+	if err != nil {
+		return "", err
+	}
+	details := map[string]interface{}{"jti": data.Id, "expiresAt": data.ExpiresAt, "scope": data.Scope}
+	if clientID != "" {
+		details["clientId"] = clientID
+	}
+	sm.auditor.Record(ctx, &audit.Event{
+		ActorID:  userRef,
+		TargetID: userRef,
+		Action:   audit.ActionTokenIssued,
+		Details:  details,
+	})
+	return token, nil
+}
+
+func (sm *SessionsJWTVer) clientExists(ctx context.Context, payload *SessionJWTVerClaims) bool {
+	if sm.Clients == nil {
+		return false
+	}
+	ok, err := sm.Clients.ClientExists(ctx, payload.ClientID)
+	if err != nil {
+		log.Clog(ctx).Error("Error while checking token client", log.Fields{"clientId": payload.ClientID, "error": err.Error()})
+		return false
+	}
+	if !ok {
+		log.Clog(ctx).Info("Provided token of unknown client", log.Fields{"clientId": payload.ClientID})
+		sm.recordRejected(ctx, payload, map[string]interface{}{"reason": "unknown client", "clientId": payload.ClientID})
+	}
+	return ok
+}
+
+// recordRejected audits the rejection of the token once an hour, replays of the token are only logged,
+// the token check runs for every proxied request and the audit log is append-only.
+func (sm *SessionsJWTVer) recordRejected(ctx context.Context, payload *SessionJWTVerClaims, details map[string]interface{}) {
+	if !sm.rejected.first(payload.Id, time.Now()) {
+		log.Clog(ctx).Debug("Rejected token replayed", log.Fields{"jti": payload.Id, "userId": payload.UserID})
+		return
+	}
+	details["jti"] = payload.Id
+	sm.auditor.Record(ctx, &audit.Event{
+		TargetID: userRefOrNil(payload.UserID),
+		Action:   audit.ActionTokenRejected,
+		Details:  details,
+	})
+}
+
+func userRefOrNil(id uint32) *uint32 {
+	if id == 0 {
+		return nil
+	}
+	return audit.UserRef(id)
 }
@@ -0,0 +1,42 @@
+package session
+
+import (
+	"sync"
+	"time"
+)
+
+// rejectionsLimit bounds the memory of the remembered tokens, they are forgotten all at once above it.
+const rejectionsLimit = 10000
+
+// rejections remembers the recently audited rejected tokens, so a client replaying a token
+// writes a single audit event per window instead of one per request.
+type rejections struct {
+	mu     sync.Mutex
+	seen   map[string]time.Time
+	window time.Duration
+}
+
+func newRejections(window time.Duration) *rejections {
+	return &rejections{seen: map[string]time.Time{}, window: window}
+}
+
+// first tells whether the token was not rejected within the window and remembers it.
+func (r *rejections) first(jti string, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if at, ok := r.seen[jti]; ok && now.Sub(at) < r.window {
+		return false
+	}
+	if len(r.seen) >= rejectionsLimit {
+		for k, at := range r.seen {
+			if now.Sub(at) >= r.window {
+				delete(r.seen, k)
+			}
+		}
+		if len(r.seen) >= rejectionsLimit {
+			r.seen = map[string]time.Time{}
+		}
+	}
+	r.seen[jti] = now
+	return true
+}
@@ -1,12 +1,33 @@
 package session
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 const sessionKey = "session"
 
+// ScopePasswordChange restricts the session to the password change,
+// it is given to users whose password expired or has to be changed by the admin demand.
+const ScopePasswordChange = "password_change"
+
 type Session struct {
+	// Zero for sessions of OAuth clients acting on their own behalf, see ClientOnly
 	UserID uint32
 	ID     string
+	Email  string
+	Role   string
+	// OAuth client the token was issued to, empty for tokens from /login and /signup
+	ClientID string
+	// Empty scope is not restricted
+	Scope     string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// ClientOnly tells whether the session belongs to an OAuth client rather than a user.
+func (s *Session) ClientOnly() bool {
+	return s.UserID == 0
 }
 
 func ToContext(ctx context.Context, sess *Session) context.Context {
@@ -0,0 +1,152 @@
+package delivery
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+
+	login_usecase "github.com/Ollub/user_service/internal/logins/usecase"
+	"github.com/Ollub/user_service/internal/session"
+	"github.com/Ollub/user_service/internal/sso"
+	"github.com/Ollub/user_service/internal/sso/usecase"
+	user_delivery "github.com/Ollub/user_service/internal/users/delivery"
+	user_usecase "github.com/Ollub/user_service/internal/users/usecase"
+	"github.com/Ollub/user_service/pkg/log"
+	"github.com/Ollub/user_service/pkg/utils/http_utils"
+	"github.com/gorilla/mux"
+)
+
+const (
+	CodeProviderNotFound = "sso_provider_not_found"
+	CodeSSOFailed        = "sso_failed"
+	CodeEmailNotVerified = "sso_email_not_verified"
+	CodeDomainNotAllowed = "sso_domain_not_allowed"
+	CodeAccountNotFound  = "sso_account_not_found"
+	CodeAccountNotLinked = "sso_account_not_linked"
+	stateCookie          = "sso_state"
+)
+
+type Handler struct {
+	sso      *usecase.Manager
+	sessions *session.SessionsJWTVer
+	logins   *login_usecase.Manager
+	cfg      *sso.Config
+	// auth cookie set on the login, empty disables it
+	authCookie string
+	tokenTTL   time.Duration
+}
+
+func NewHandler(
+	ssoManager *usecase.Manager,
+	sessionManager *session.SessionsJWTVer,
+	loginManager *login_usecase.Manager,
+	cfg *sso.Config,
+	authCookie string,
+	tokenTTL time.Duration,
+) *Handler {
+	return &Handler{ssoManager, sessionManager, loginManager, cfg, authCookie, tokenTTL}
+}
+
+// Login redirects the user to the provider. The state is also set in a cookie,
+// so the callback is accepted only in the browser that started the login.
+func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
+	provider := mux.Vars(r)["provider"]
+	authURL, state, err := h.sso.Begin(r.Context(), provider)
+	if err == usecase.ProviderNotFoundError {
+		http_utils.HttpError(w, r, CodeProviderNotFound, "Unknown sso provider", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http_utils.HttpError(w, r, CodeSSOFailed, "The sso provider is not available", http.StatusBadGateway)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Value:    state,
+		Path:     "/login/" + provider,
+		MaxAge:   int(h.cfg.StateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   h.secure(),
+		SameSite: http.SameSiteLaxMode,
+	})
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// Callback completes the login when the provider redirects back. It responds like /login,
+// or sets the auth cookie and redirects to SSO_REDIRECT_URL if it is configured.
+func (h *Handler) Callback(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	provider := mux.Vars(r)["provider"]
+	q := r.URL.Query()
+	w.Header().Set("Cache-Control", "no-store")
+
+	cookie, err := r.Cookie(stateCookie)
+	http.SetCookie(w, &http.Cookie{Name: stateCookie, Path: "/login/" + provider, MaxAge: -1})
+	if e := q.Get("error"); e != "" {
+		log.Clog(ctx).Info("SSO provider returned error", log.Fields{"provider": provider, "error": e, "description": q.Get("error_description")})
+		http_utils.HttpError(w, r, CodeSSOFailed, "The sso provider denied the login", http.StatusUnauthorized)
+		return
+	}
+	state := q.Get("state")
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		http_utils.HttpError(w, r, CodeSSOFailed, "Invalid or expired login state", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.sso.Complete(ctx, provider, state, q.Get("code"))
+	switch err {
+	case nil:
+	case usecase.ProviderNotFoundError:
+		http_utils.HttpError(w, r, CodeProviderNotFound, "Unknown sso provider", http.StatusNotFound)
+	case usecase.InvalidStateError:
+		http_utils.HttpError(w, r, CodeSSOFailed, "Invalid or expired login state", http.StatusBadRequest)
+	case usecase.ProviderError:
+		http_utils.HttpError(w, r, CodeSSOFailed, "The sso provider login could not be verified", http.StatusBadGateway)
+	case usecase.EmailNotVerifiedError:
+		http_utils.HttpError(w, r, CodeEmailNotVerified, "The email is not verified by the sso provider", http.StatusForbidden)
+	case usecase.DomainNotAllowedError:
+		http_utils.HttpError(w, r, CodeDomainNotAllowed, "The email domain is not allowed", http.StatusForbidden)
+	case usecase.AccountNotFoundError:
+		http_utils.HttpError(w, r, CodeAccountNotFound, "There is no account for the sso login", http.StatusForbidden)
+	case usecase.AccountNotLinkedError:
+		http_utils.HttpError(w, r, CodeAccountNotLinked, "An account with the email exists, sign in with the password", http.StatusConflict)
+	case user_usecase.UserDisabledError:
+		http_utils.HttpError(w, r, user_delivery.CodeUserDisabled, "The user is disabled", http.StatusForbidden)
+	default:
+		log.Clog(ctx).Error("Error during sso login", log.Fields{"provider": provider, "err": err})
+		http_utils.HttpError(w, r, http_utils.CodeInternal, "Internal error", http.StatusInternalServerError)
+	}
+	if err != nil {
+		return
+	}
+	h.logins.Record(ctx, user.Email, true, "")
+
+	token, err := h.sessions.Create(ctx, user)
+	if err != nil {
+		log.Clog(ctx).Error("Cant issue token", log.Fields{"err": err.Error()})
+		http_utils.HttpError(w, r, http_utils.CodeInternal, "Internal error during token creation", http.StatusInternalServerError)
+		return
+	}
+	if h.authCookie != "" {
+		http.SetCookie(w, &http.Cookie{
+			Name:     h.authCookie,
+			Value:    token,
+			Path:     "/",
+			MaxAge:   int(h.tokenTTL.Seconds()),
+			HttpOnly: true,
+			Secure:   h.secure(),
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	if h.cfg.RedirectURL != "" {
+		http.Redirect(w, r, h.cfg.RedirectURL, http.StatusFound)
+		return
+	}
+	http_utils.JsonResp(w, &user_delivery.LoginResp{Token: token, UserId: user.ID}, http.StatusOK)
+}
+
+func (h *Handler) secure() bool {
+	return strings.HasPrefix(h.cfg.BaseURL, "https://")
+}
@@ -0,0 +1,94 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/Ollub/user_service/internal/sso"
+)
+
+type RepoPgx struct {
+	DB *sql.DB
+}
+
+func NewPgRepository(db *sql.DB) *RepoPgx {
+	return &RepoPgx{DB: db}
+}
+
+func (repo *RepoPgx) AddState(ctx context.Context, s *sso.State) error {
+	_, err := repo.DB.ExecContext(
+		ctx,
+		`INSERT INTO sso_states (state_hash, provider, nonce, code_verifier, expires_at) VALUES ($1, $2, $3, $4, $5)`,
+		s.Hash,
+		s.Provider,
+		s.Nonce,
+		s.CodeVerifier,
+		s.ExpiresAt,
+	)
+	return err
+}
+
+// TakeState deletes and returns the state, so it can be used once.
+func (repo *RepoPgx) TakeState(ctx context.Context, hash string) (*sso.State, error) {
+	s := &sso.State{}
+	err := repo.DB.QueryRowContext(
+		ctx,
+		`DELETE FROM sso_states WHERE state_hash = $1 RETURNING state_hash, provider, nonce, code_verifier, expires_at`,
+		hash,
+	).Scan(&s.Hash, &s.Provider, &s.Nonce, &s.CodeVerifier, &s.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (repo *RepoPgx) GetIdentity(ctx context.Context, provider, subject string) (*sso.Identity, error) {
+	i := &sso.Identity{}
+	err := repo.DB.QueryRowContext(
+		ctx,
+		`SELECT provider, subject, user_id, email, created_at, last_login_at FROM user_identities WHERE provider = $1 AND subject = $2`,
+		provider,
+		subject,
+	).Scan(&i.Provider, &i.Subject, &i.UserID, &i.Email, &i.CreatedAt, &i.LastLoginAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+func (repo *RepoPgx) AddIdentity(ctx context.Context, i *sso.Identity) error {
+	return repo.DB.QueryRowContext(
+		ctx,
+		`INSERT INTO user_identities (provider, subject, user_id, email) VALUES ($1, $2, $3, $4) RETURNING created_at, last_login_at`,
+		i.Provider,
+		i.Subject,
+		i.UserID,
+		i.Email,
+	).Scan(&i.CreatedAt, &i.LastLoginAt)
+}
+
+// TouchIdentity stores the last login time and the current email of the identity.
+func (repo *RepoPgx) TouchIdentity(ctx context.Context, provider, subject, email string) error {
+	_, err := repo.DB.ExecContext(
+		ctx,
+		`UPDATE user_identities SET last_login_at = now(), email = $3 WHERE provider = $1 AND subject = $2`,
+		provider,
+		subject,
+		email,
+	)
+	return err
+}
+
+func (repo *RepoPgx) DeleteExpiredStates(ctx context.Context) (int64, error) {
+	result, err := repo.DB.ExecContext(ctx, `DELETE FROM sso_states WHERE expires_at < now()`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
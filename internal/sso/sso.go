@@ -0,0 +1,89 @@
+package sso
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+type Config struct {
+	// Names of the upstream OpenID providers, each one is configured by SSO_<NAME>_* variables, see ProviderConfig
+	Providers []string `envconfig:"SSO_PROVIDERS"`
+	// Public url of the service the providers redirect back to, OIDC_ISSUER is used if empty
+	BaseURL string `envconfig:"SSO_BASE_URL"`
+	// Page the browser is sent to after the login with the auth cookie set, the token is returned as JSON if empty
+	RedirectURL string        `envconfig:"SSO_REDIRECT_URL"`
+	StateTTL    time.Duration `envconfig:"SSO_STATE_TTL" default:"10m"`
+}
+
+type ProviderConfig struct {
+	Issuer       string   `envconfig:"ISSUER" required:"true"`
+	ClientID     string   `envconfig:"CLIENT_ID" required:"true"`
+	ClientSecret string   `envconfig:"CLIENT_SECRET"`
+	Scopes       []string `envconfig:"SCOPES" default:"openid,email,profile"`
+	// Create users signing in for the first time, otherwise only existing users can sign in
+	Provisioning bool `envconfig:"PROVISIONING" default:"true"`
+	// Link the first login to the existing user with the same verified email, the password of the user is dropped
+	// as emails of the service are not verified and it may have been set by somebody else
+	LinkByEmail bool `envconfig:"LINK_BY_EMAIL" default:"false"`
+	// Only emails of these domains are accepted, all if empty
+	AllowedDomains []string `envconfig:"ALLOWED_DOMAINS"`
+}
+
+var providerNameRe = regexp.MustCompile(`^[a-z0-9]+$`)
+
+// LoadProviders reads the config of every named provider from the SSO_<NAME>_* variables.
+func LoadProviders(names []string) ([]*Provider, error) {
+	providers := make([]*Provider, 0, len(names))
+	for _, name := range names {
+		if !providerNameRe.MatchString(name) {
+			return nil, fmt.Errorf("invalid sso provider name %q, only lowercase letters and digits are allowed", name)
+		}
+		cfg := &ProviderConfig{}
+		if err := envconfig.Process("SSO_"+strings.ToUpper(name), cfg); err != nil {
+			return nil, fmt.Errorf("sso provider %s: %w", name, err)
+		}
+		providers = append(providers, NewProvider(name, cfg))
+	}
+	return providers, nil
+}
+
+// AllowsEmail checks the domain of the email against AllowedDomains.
+func (c *ProviderConfig) AllowsEmail(email string) bool {
+	if len(c.AllowedDomains) == 0 {
+		return true
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(email[at+1:])
+	for _, d := range c.AllowedDomains {
+		if strings.ToLower(d) == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// Identity is an account of an external provider linked to a user.
+type Identity struct {
+	Provider    string
+	Subject     string
+	UserID      uint32
+	Email       string
+	CreatedAt   time.Time
+	LastLoginAt time.Time
+}
+
+// State is a pending login redirected to the provider, only the hash of the state param is stored.
+type State struct {
+	Hash         string
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+}
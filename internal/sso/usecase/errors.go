@@ -0,0 +1,11 @@
+package usecase
+
+import "errors"
+
+var ProviderNotFoundError = errors.New("sso provider not found")
+var InvalidStateError = errors.New("invalid or expired sso state")
+var ProviderError = errors.New("sso provider login failed")
+var EmailNotVerifiedError = errors.New("email is not verified by the sso provider")
+var DomainNotAllowedError = errors.New("email domain is not allowed")
+var AccountNotFoundError = errors.New("no user for the sso login")
+var AccountNotLinkedError = errors.New("user with the email exists and is not linked")
@@ -0,0 +1,228 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Ollub/user_service/internal/audit"
+	"github.com/Ollub/user_service/internal/oauth"
+	"github.com/Ollub/user_service/internal/sso"
+	"github.com/Ollub/user_service/internal/users"
+	user_usecase "github.com/Ollub/user_service/internal/users/usecase"
+	"github.com/Ollub/user_service/pkg/log"
+)
+
+type Repo interface {
+	AddState(ctx context.Context, s *sso.State) error
+	TakeState(ctx context.Context, hash string) (*sso.State, error)
+	GetIdentity(ctx context.Context, provider, subject string) (*sso.Identity, error)
+	AddIdentity(ctx context.Context, i *sso.Identity) error
+	TouchIdentity(ctx context.Context, provider, subject, email string) error
+	DeleteExpiredStates(ctx context.Context) (int64, error)
+}
+
+type Users interface {
+	GetUser(ctx context.Context, userId uint32) (*users.User, error)
+	GetUserByEmail(ctx context.Context, email string) (*users.User, error)
+	Provision(ctx context.Context, in *users.UserIn, provider string) (*users.User, error)
+	DropPassword(ctx context.Context, userId uint32, provider string) (*users.User, error)
+}
+
+type Auditor interface {
+	Record(ctx context.Context, e *audit.Event)
+}
+
+// Manager signs users in with upstream OpenID providers. The provider account is linked to the user
+// on the first login: to the user with the same verified email or to a newly provisioned one.
+type Manager struct {
+	repo      Repo
+	users     Users
+	auditor   Auditor
+	providers map[string]*sso.Provider
+	baseURL   string
+	stateTTL  time.Duration
+}
+
+func NewManager(repo Repo, users Users, auditor Auditor, providers []*sso.Provider, cfg *sso.Config) *Manager {
+	m := &Manager{
+		repo:      repo,
+		users:     users,
+		auditor:   auditor,
+		providers: make(map[string]*sso.Provider, len(providers)),
+		baseURL:   strings.TrimSuffix(cfg.BaseURL, "/"),
+		stateTTL:  cfg.StateTTL,
+	}
+	for _, p := range providers {
+		m.providers[p.Name] = p
+	}
+	return m
+}
+
+// Begin starts the login with the provider. It returns the url the user is redirected to
+// and the state, which the caller has to bind to the browser.
+func (m *Manager) Begin(ctx context.Context, provider string) (string, string, error) {
+	p, ok := m.providers[provider]
+	if !ok {
+		return "", "", ProviderNotFoundError
+	}
+	st := &sso.State{
+		Provider:     provider,
+		Nonce:        oauth.RandomToken(16),
+		CodeVerifier: oauth.RandomToken(32),
+		ExpiresAt:    time.Now().Add(m.stateTTL),
+	}
+	state := oauth.RandomToken(32)
+	st.Hash = oauth.Hash(state)
+
+	authURL, err := p.AuthURL(ctx, m.callbackURL(provider), state, st.Nonce, st.CodeVerifier)
+	if err != nil {
+		log.Clog(ctx).Error("Error while building sso authorization url", log.Fields{"provider": provider, "error": err.Error()})
+		return "", "", fmt.Errorf("sso authorization url: %w", err)
+	}
+	if err := m.repo.AddState(ctx, st); err != nil {
+		log.Clog(ctx).Error("Error while storing sso state", log.Fields{"provider": provider, "error": err.Error()})
+		return "", "", fmt.Errorf("store sso state: %w", err)
+	}
+	return authURL, state, nil
+}
+
+// Complete finishes the login with the code the provider redirected back with and returns the signed in user.
+func (m *Manager) Complete(ctx context.Context, provider, state, code string) (*users.User, error) {
+	p, ok := m.providers[provider]
+	if !ok {
+		return nil, ProviderNotFoundError
+	}
+	st, err := m.repo.TakeState(ctx, oauth.Hash(state))
+	if err != nil {
+		log.Clog(ctx).Error("Error while taking sso state", log.Fields{"provider": provider, "error": err.Error()})
+		return nil, fmt.Errorf("take sso state: %w", err)
+	}
+	if st == nil || st.Provider != provider || time.Now().After(st.ExpiresAt) {
+		return nil, InvalidStateError
+	}
+
+	claims, err := p.Exchange(ctx, m.callbackURL(provider), code, st.CodeVerifier)
+	if err != nil {
+		log.Clog(ctx).Warn("SSO provider login failed", log.Fields{"provider": provider, "error": err.Error()})
+		return nil, ProviderError
+	}
+	if claims.Nonce != st.Nonce {
+		log.Clog(ctx).Warn("SSO id token nonce mismatch", log.Fields{"provider": provider, "subject": claims.Subject})
+		return nil, ProviderError
+	}
+	if !p.Config.AllowsEmail(claims.Email) {
+		return nil, DomainNotAllowedError
+	}
+
+	u, err := m.resolve(ctx, p, claims)
+	if err != nil {
+		return nil, err
+	}
+	if u.Disabled {
+		m.auditor.Record(ctx, &audit.Event{
+			TargetID: audit.UserRef(u.ID),
+			Action:   audit.ActionLoginFailed,
+			Details:  map[string]interface{}{"email": u.Email, "reason": "disabled", "provider": provider},
+		})
+		return nil, user_usecase.UserDisabledError
+	}
+	m.auditor.Record(ctx, &audit.Event{
+		ActorID:  audit.UserRef(u.ID),
+		TargetID: audit.UserRef(u.ID),
+		Action:   audit.ActionLoginSucceeded,
+		Details:  map[string]interface{}{"provider": provider},
+	})
+	return u, nil
+}
+
+// resolve finds the user linked to the provider account, links the user with the same verified email
+// or provisions a new one.
+func (m *Manager) resolve(ctx context.Context, p *sso.Provider, claims *sso.Claims) (*users.User, error) {
+	ident, err := m.repo.GetIdentity(ctx, p.Name, claims.Subject)
+	if err != nil {
+		log.Clog(ctx).Error("Error while retrieving sso identity", log.Fields{"provider": p.Name, "error": err.Error()})
+		return nil, fmt.Errorf("get sso identity: %w", err)
+	}
+	if ident != nil {
+		if err := m.repo.TouchIdentity(ctx, p.Name, claims.Subject, claims.Email); err != nil {
+			log.Clog(ctx).Error("Error while updating sso identity", log.Fields{"provider": p.Name, "error": err.Error()})
+		}
+		return m.users.GetUser(ctx, ident.UserID)
+	}
+
+	// an unverified email may belong to somebody else
+	if claims.Email == "" || !bool(claims.EmailVerified) {
+		return nil, EmailNotVerifiedError
+	}
+	u, err := m.users.GetUserByEmail(ctx, claims.Email)
+	switch {
+	case err == nil && !p.Config.LinkByEmail:
+		return nil, AccountNotLinkedError
+	case err == nil && u.HasPassword():
+		// the email of the service account is not verified, its password may belong to somebody else
+		u, err = m.users.DropPassword(ctx, u.ID, p.Name)
+		if err != nil {
+			return nil, err
+		}
+	case err == user_usecase.UserNotFoundError && !p.Config.Provisioning:
+		return nil, AccountNotFoundError
+	case err == user_usecase.UserNotFoundError:
+		u, err = m.users.Provision(ctx, profile(claims), p.Name)
+		if err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	}
+
+	ident = &sso.Identity{Provider: p.Name, Subject: claims.Subject, UserID: u.ID, Email: claims.Email}
+	if err := m.repo.AddIdentity(ctx, ident); err != nil {
+		log.Clog(ctx).Error("Error while linking sso identity", log.Fields{"provider": p.Name, "userId": u.ID, "error": err.Error()})
+		return nil, fmt.Errorf("add sso identity: %w", err)
+	}
+	m.auditor.Record(ctx, &audit.Event{
+		ActorID:  audit.UserRef(u.ID),
+		TargetID: audit.UserRef(u.ID),
+		Action:   audit.ActionIdentityLinked,
+		Details:  map[string]interface{}{"provider": p.Name, "subject": claims.Subject, "email": claims.Email},
+	})
+	return u, nil
+}
+
+// profile maps the standard claims to the new user, the full name is split if there are no name parts.
+func profile(claims *sso.Claims) *users.UserIn {
+	in := &users.UserIn{Email: claims.Email, FirstName: claims.GivenName, LastName: claims.FamilyName}
+	if in.FirstName == "" && in.LastName == "" {
+		parts := strings.SplitN(strings.TrimSpace(claims.Name), " ", 2)
+		in.FirstName = parts[0]
+		if len(parts) > 1 {
+			in.LastName = strings.TrimSpace(parts[1])
+		}
+	}
+	return in
+}
+
+func (m *Manager) callbackURL(provider string) string {
+	return m.baseURL + "/login/" + provider + "/callback"
+}
+
+// Run deletes expired states every interval until ctx is done.
+func (m *Manager) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if n, err := m.repo.DeleteExpiredStates(ctx); err != nil {
+			log.Error("Error while deleting expired sso states", log.Fields{"error": err.Error()})
+		} else if n > 0 {
+			log.Info("Expired sso states deleted", log.Fields{"count": n})
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
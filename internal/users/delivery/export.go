@@ -0,0 +1,213 @@
+package delivery
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ollub/user_service/internal/users"
+	"github.com/Ollub/user_service/pkg/log"
+	"github.com/Ollub/user_service/pkg/utils/http_utils"
+)
+
+const (
+	exportFormatCSV    = "csv"
+	exportFormatNDJSON = "ndjson"
+)
+
+// exportColumns lists every column that can be exported.
+// PassHash is deliberately not exportable.
+var exportColumns = map[string]func(*users.User) string{
+	"id":        func(u *users.User) string { return strconv.FormatUint(uint64(u.ID), 10) },
+	"email":     func(u *users.User) string { return u.Email },
+	"firstName": func(u *users.User) string { return u.FirstName },
+	"lastName":  func(u *users.User) string { return u.LastName },
+	"role":      func(u *users.User) string { return u.Role },
+	"createdAt": func(u *users.User) string { return u.CreatedAt.UTC().Format(time.RFC3339) },
+	"updatedAt": func(u *users.User) string { return u.UpdatedAt.UTC().Format(time.RFC3339) },
+}
+
+var defaultExportColumns = []string{"id", "email", "firstName", "lastName", "role", "createdAt", "updatedAt"}
+
+type rowWriter interface {
+	Write(u *users.User) error
+	Flush() error
+}
+
+// Export streams users in csv or ndjson format.
+// Supported query params: format, columns, email, role, createdAfter, createdBefore.
+func (h *Handler) Export(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	query := r.URL.Query()
+
+	format := query.Get("format")
+	if format == "" {
+		format = exportFormatCSV
+	}
+	if format != exportFormatCSV && format != exportFormatNDJSON {
+		fieldErr := http_utils.NewFieldError("format", http_utils.FieldInvalid, "should be one of csv, ndjson")
+		http_utils.ValidationError(w, r, []*http_utils.FieldError{fieldErr}, http.StatusBadRequest)
+		return
+	}
+
+	columns, fieldErr := parseExportColumns(query.Get("columns"))
+	if fieldErr != nil {
+		http_utils.ValidationError(w, r, []*http_utils.FieldError{fieldErr}, http.StatusBadRequest)
+		return
+	}
+
+	filter, fieldErr := parseUserFilter(query)
+	if fieldErr != nil {
+		http_utils.ValidationError(w, r, []*http_utils.FieldError{fieldErr}, http.StatusBadRequest)
+		return
+	}
+
+	var writer rowWriter
+	switch format {
+	case exportFormatCSV:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		writer = newCSVRowWriter(w, columns)
+	case exportFormatNDJSON:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		writer = newNDJSONRowWriter(w, columns)
+	}
+	w.Header().Set(
+		"Content-Disposition",
+		fmt.Sprintf(`attachment; filename="users-%s.%s"`, time.Now().UTC().Format("20060102T150405"), format),
+	)
+	// the export may take longer than the WriteTimeout of the server
+	if err := http_utils.DisableWriteDeadline(r); err != nil {
+		log.Clog(ctx).Error("Can't lift write deadline of users export", log.Fields{"err": err.Error()})
+	}
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	rows := 0
+	err := h.users.ExportUsers(ctx, filter, func(u *users.User) error {
+		if err := writer.Write(u); err != nil {
+			return err
+		}
+		rows++
+		if rows%100 == 0 && flusher != nil {
+			if err := writer.Flush(); err != nil {
+				return err
+			}
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		// headers are already sent, the only thing left is to cut the stream
+		log.Clog(ctx).Error("Users export interrupted", log.Fields{"rows": rows, "err": err.Error()})
+		return
+	}
+	log.Clog(ctx).Info("Users exported", log.Fields{"rows": rows, "format": format})
+}
+
+func parseExportColumns(raw string) ([]string, *http_utils.FieldError) {
+	if raw == "" {
+		return defaultExportColumns, nil
+	}
+	var columns []string
+	for _, c := range strings.Split(raw, ",") {
+		c = strings.TrimSpace(c)
+		if _, ok := exportColumns[c]; !ok {
+			return nil, http_utils.NewFieldError("columns", http_utils.FieldInvalid, fmt.Sprintf("unknown column %q", c))
+		}
+		columns = append(columns, c)
+	}
+	return columns, nil
+}
+
+func parseUserFilter(query url.Values) (*users.Filter, *http_utils.FieldError) {
+	f := &users.Filter{
+		Email: query.Get("email"),
+		Role:  query.Get("role"),
+	}
+	var err error
+	if v := query.Get("createdAfter"); v != "" {
+		if f.CreatedAfter, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, http_utils.NewFieldError("createdAfter", http_utils.FieldInvalid, "should be RFC3339 timestamp")
+		}
+	}
+	if v := query.Get("createdBefore"); v != "" {
+		if f.CreatedBefore, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, http_utils.NewFieldError("createdBefore", http_utils.FieldInvalid, "should be RFC3339 timestamp")
+		}
+	}
+	return f, nil
+}
+
+type csvRowWriter struct {
+	w       *csv.Writer
+	columns []string
+	header  bool
+}
+
+func newCSVRowWriter(w http.ResponseWriter, columns []string) *csvRowWriter {
+	return &csvRowWriter{w: csv.NewWriter(w), columns: columns}
+}
+
+func (c *csvRowWriter) Write(u *users.User) error {
+	if !c.header {
+		if err := c.w.Write(c.columns); err != nil {
+			return err
+		}
+		c.header = true
+	}
+	record := make([]string, len(c.columns))
+	for i, col := range c.columns {
+		record[i] = escapeFormula(exportColumns[col](u))
+	}
+	return c.w.Write(record)
+}
+
+// escapeFormula prefixes the cells which spreadsheets would evaluate as a formula with a single quote,
+// the names and emails are set by the users themselves.
+func escapeFormula(cell string) string {
+	if cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+		return "'" + cell
+	}
+	return cell
+}
+
+func (c *csvRowWriter) Flush() error {
+	if !c.header {
+		// empty result still gets a header row
+		if err := c.w.Write(c.columns); err != nil {
+			return err
+		}
+		c.header = true
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type ndjsonRowWriter struct {
+	enc     *json.Encoder
+	columns []string
+}
+
+func newNDJSONRowWriter(w http.ResponseWriter, columns []string) *ndjsonRowWriter {
+	return &ndjsonRowWriter{enc: json.NewEncoder(w), columns: columns}
+}
+
+func (n *ndjsonRowWriter) Write(u *users.User) error {
+	row := make(map[string]string, len(n.columns))
+	for _, col := range n.columns {
+		row[col] = exportColumns[col](u)
+	}
+	return n.enc.Encode(row)
+}
+
+func (n *ndjsonRowWriter) Flush() error {
+	return nil
+}
@@ -0,0 +1,32 @@
+package delivery
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Ollub/user_service/internal/users"
+)
+
+// The names are set by the users, spreadsheets must not evaluate them as formulas.
+func TestCSVRowWriterEscapesFormulas(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w := newCSVRowWriter(rec, []string{"firstName", "lastName", "email"})
+
+	u := &users.User{FirstName: "=HYPERLINK(\"http://evil\")", LastName: "-2+3", Email: "@john@doe.com"}
+	if err := w.Write(u); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(&users.User{FirstName: "John", LastName: "O'Neil", Email: "john@doe.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "firstName,lastName,email\n" +
+		"\"'=HYPERLINK(\"\"http://evil\"\")\",'-2+3,'@john@doe.com\n" +
+		"John,O'Neil,john@doe.com\n"
+	if got := rec.Body.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,159 @@
+package delivery
+
+import (
+	"context"
+	"math"
+	"strconv"
+
+	userv1 "github.com/Ollub/user_service/api/user/v1"
+	"github.com/Ollub/user_service/internal/session"
+	"github.com/Ollub/user_service/internal/users"
+	"github.com/Ollub/user_service/internal/users/usecase"
+	"github.com/Ollub/user_service/pkg/log"
+	"github.com/Ollub/user_service/pkg/utils/grpc_utils"
+	"github.com/Ollub/user_service/pkg/utils/http_utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+// GrpcServer implements userv1.UserServiceServer on top of the same managers as Handler.
+type GrpcServer struct {
+	userv1.UnimplementedUserServiceServer
+	h *Handler
+}
+
+func NewGrpcServer(handler *Handler) *GrpcServer {
+	return &GrpcServer{h: handler}
+}
+
+// NoAuthMethods are the methods available without a token.
+var NoAuthMethods = []string{
+	userv1.UserService_Signup_FullMethodName,
+	userv1.UserService_Login_FullMethodName,
+	userv1.UserService_ValidateToken_FullMethodName,
+}
+
+func (s *GrpcServer) Signup(ctx context.Context, req *userv1.SignupRequest) (*userv1.AuthResponse, error) {
+	userIn := &users.UserIn{
+		Email:     req.Email,
+		Password:  req.Password,
+		FirstName: req.FirstName,
+		LastName:  req.LastName,
+	}
+	if errs := validateUser(userIn, s.h.policy); len(errs) > 0 {
+		return nil, grpc_utils.ValidationError(ctx, errs)
+	}
+
+	user, err := s.h.users.Create(ctx, userIn)
+	switch err {
+	case nil:
+	case usecase.UserExistsError:
+		return nil, grpc_utils.Error(ctx, codes.AlreadyExists, CodeUserExists, "User exist")
+	case usecase.ServiceBusyError:
+		return nil, grpc_utils.Error(ctx, codes.Unavailable, http_utils.CodeServiceBusy, "Service is busy, try again later")
+	default:
+		log.Clog(ctx).Error("Unexpected error during user creation", log.Fields{"err": err.Error()})
+		return nil, grpc_utils.Error(ctx, codes.Internal, http_utils.CodeInternal, "Internal error during user creation")
+	}
+	return s.authResponse(ctx, user)
+}
+
+func (s *GrpcServer) Login(ctx context.Context, req *userv1.LoginRequest) (*userv1.AuthResponse, error) {
+	user, retryAfter, err := s.h.Authenticate(ctx, req.Email, req.Password)
+	switch err {
+	case nil:
+	case LoginThrottledError:
+		grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.Itoa(int(math.Ceil(retryAfter.Seconds())))))
+		return nil, grpc_utils.Error(ctx, codes.ResourceExhausted, CodeLoginThrottled, "Too many failed login attempts, try again later")
+	case usecase.UserNotFoundError, usecase.BadPasswordError:
+		return nil, grpc_utils.Error(ctx, codes.Unauthenticated, CodeInvalidCredentials, "Invalid credentials")
+	case usecase.UserDisabledError:
+		return nil, grpc_utils.Error(ctx, codes.PermissionDenied, CodeUserDisabled, "The user is disabled")
+	case usecase.ServiceBusyError:
+		return nil, grpc_utils.Error(ctx, codes.Unavailable, http_utils.CodeServiceBusy, "Service is busy, try again later")
+	default:
+		log.Clog(ctx).Error("Error during checking user password", log.Fields{"err": err})
+		return nil, grpc_utils.Error(ctx, codes.Internal, http_utils.CodeInternal, "Internal error")
+	}
+	return s.authResponse(ctx, user)
+}
+
+func (s *GrpcServer) GetUser(ctx context.Context, req *userv1.GetUserRequest) (*userv1.User, error) {
+	user, err := s.h.users.GetUser(ctx, req.Id)
+	if err == usecase.UserNotFoundError {
+		return nil, grpc_utils.Error(ctx, codes.NotFound, http_utils.CodeNotFound, "User not found")
+	}
+	if err != nil {
+		return nil, grpc_utils.Error(ctx, codes.Internal, http_utils.CodeInternal, "Internal error while retrieving user")
+	}
+	return userToProto(user), nil
+}
+
+func (s *GrpcServer) ListUsers(ctx context.Context, req *userv1.ListUsersRequest) (*userv1.ListUsersResponse, error) {
+	items, err := s.h.users.ListUsers(ctx)
+	if err != nil {
+		return nil, grpc_utils.Error(ctx, codes.Internal, http_utils.CodeInternal, "Internal error while listing users")
+	}
+	resp := &userv1.ListUsersResponse{Users: make([]*userv1.User, len(items))}
+	for i, u := range items {
+		resp.Users[i] = userToProto(u)
+	}
+	return resp, nil
+}
+
+func (s *GrpcServer) UpdateUser(ctx context.Context, req *userv1.UpdateUserRequest) (*userv1.User, error) {
+	sess := session.FromContext(ctx)
+	if sess.UserID != req.Id {
+		return nil, grpc_utils.Error(ctx, codes.PermissionDenied, http_utils.CodeForbidden, "User can update only his profile")
+	}
+	user, err := s.h.users.PartialUpdate(ctx, req.Id, &users.UserUpdate{FirstName: req.FirstName, LastName: req.LastName})
+	if err == usecase.UserNotFoundError {
+		return nil, grpc_utils.Error(ctx, codes.NotFound, http_utils.CodeNotFound, "User not found")
+	}
+	if err != nil {
+		log.Clog(ctx).Error("User update error", log.Fields{"userId": req.Id, "err": err.Error()})
+		return nil, grpc_utils.Error(ctx, codes.Internal, http_utils.CodeInternal, "Internal during user update")
+	}
+	return userToProto(user), nil
+}
+
+// ValidateToken lets other services check tokens of their callers.
+// Tokens issued to OAuth clients are rejected like by UnaryAuthentication, resource servers introspect them.
+func (s *GrpcServer) ValidateToken(ctx context.Context, req *userv1.ValidateTokenRequest) (*userv1.ValidateTokenResponse, error) {
+	sess, err := s.h.sessions.Check(ctx, req.Token)
+	if err != nil {
+		return nil, grpc_utils.Error(ctx, codes.Unauthenticated, http_utils.CodeUnauthorized, "No auth")
+	}
+	if sess.ClientID != "" {
+		return nil, grpc_utils.Error(ctx, codes.PermissionDenied, http_utils.CodeForbidden, "OAuth tokens can't be used for the user API")
+	}
+	return &userv1.ValidateTokenResponse{
+		UserId:    sess.UserID,
+		SessionId: sess.ID,
+		Role:      sess.Role,
+		Scope:     sess.Scope,
+	}, nil
+}
+
+func (s *GrpcServer) authResponse(ctx context.Context, user *users.User) (*userv1.AuthResponse, error) {
+	token, err := s.h.sessions.Create(ctx, user)
+	if err != nil {
+		log.Clog(ctx).Error("Cant issue token", log.Fields{"err": err.Error()})
+		return nil, grpc_utils.Error(ctx, codes.Internal, http_utils.CodeInternal, "Internal error during token creation")
+	}
+	return &userv1.AuthResponse{
+		Token:                  token,
+		UserId:                 user.ID,
+		PasswordChangeRequired: s.h.users.PasswordChangeRequired(user),
+	}, nil
+}
+
+func userToProto(u *users.User) *userv1.User {
+	return &userv1.User{
+		Id:        u.ID,
+		Email:     u.Email,
+		FirstName: u.FirstName,
+		LastName:  u.LastName,
+	}
+}
@@ -0,0 +1,284 @@
+package delivery
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	userv1 "github.com/Ollub/user_service/api/user/v1"
+	"github.com/Ollub/user_service/internal/audit"
+	"github.com/Ollub/user_service/internal/lockout"
+	lockout_usecase "github.com/Ollub/user_service/internal/lockout/usecase"
+	"github.com/Ollub/user_service/internal/logins"
+	login_usecase "github.com/Ollub/user_service/internal/logins/usecase"
+	"github.com/Ollub/user_service/internal/middleware"
+	"github.com/Ollub/user_service/internal/session"
+	"github.com/Ollub/user_service/internal/users"
+	"github.com/Ollub/user_service/internal/users/usecase"
+	"github.com/Ollub/user_service/pkg/utils/grpc_utils"
+	"github.com/Ollub/user_service/pkg/utils/password"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// userRepo keeps the users in memory, the methods the tests don't need panic on the nil Repo.
+type userRepo struct {
+	usecase.Repo
+	mu    sync.Mutex
+	users []*users.User
+}
+
+func (r *userRepo) Add(_ context.Context, u *users.User) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored := *u
+	stored.ID = uint32(len(r.users) + 1)
+	stored.CreatedAt, stored.UpdatedAt, stored.PasswordChangedAt = time.Now(), time.Now(), time.Now()
+	r.users = append(r.users, &stored)
+	return int64(stored.ID), nil
+}
+
+func (r *userRepo) find(match func(*users.User) bool) (*users.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, u := range r.users {
+		if match(u) {
+			found := *u
+			return &found, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *userRepo) GetByEmail(_ context.Context, email string) (*users.User, error) {
+	return r.find(func(u *users.User) bool { return u.Email == email })
+}
+
+func (r *userRepo) GetByID(_ context.Context, id uint32) (*users.User, error) {
+	return r.find(func(u *users.User) bool { return u.ID == id })
+}
+
+func (r *userRepo) UpdatePassword(context.Context, uint32, string, string) (bool, error) {
+	return true, nil
+}
+
+type loginRepo struct{}
+
+func (loginRepo) Add(context.Context, *logins.Attempt) (int64, error) {
+	return 1, nil
+}
+
+func (loginRepo) ListByUser(context.Context, uint32, int, int) ([]*logins.Attempt, error) {
+	return nil, nil
+}
+
+type lockoutRepo struct{}
+
+func (lockoutRepo) Reserve(context.Context, string, time.Time, func(int) time.Duration) (time.Time, int, error) {
+	return time.Time{}, 1, nil
+}
+
+func (lockoutRepo) Release(context.Context, string) error {
+	return nil
+}
+
+func (lockoutRepo) Delete(context.Context, string) (int64, error) {
+	return 0, nil
+}
+
+type clients struct{}
+
+func (clients) ClientExists(context.Context, string) (bool, error) {
+	return true, nil
+}
+
+type nopAuditor struct{}
+
+func (nopAuditor) Record(context.Context, *audit.Event) {}
+
+const testPassword = "Correct-Horse-42"
+
+// newTestClient serves the gRPC API with the interceptors of the service over an in-memory connection.
+func newTestClient(t *testing.T) (userv1.UserServiceClient, *session.SessionsJWTVer) {
+	argon := &password.ArgonParams{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	userManager := usecase.NewManager(&userRepo{}, nopAuditor{}, testPool, argon, 0, 0)
+	sessions := session.NewSessionsJWTVer([]byte("secret"), 1, userManager, nopAuditor{})
+	sessions.Clients = clients{}
+	h := NewHandler(
+		sessions,
+		userManager,
+		login_usecase.NewManager(loginRepo{}),
+		lockout_usecase.NewManager(lockoutRepo{}, nopAuditor{}, &lockout.Config{FreeAttempts: 100, AccountThreshold: 100, IPThreshold: 100}),
+		&password.Policy{MinLength: 6, MaxLength: 128},
+	)
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		middleware.UnaryReqID,
+		middleware.UnaryInjectLogger,
+		middleware.UnaryClientInfo(0),
+		middleware.UnaryRecover,
+		middleware.UnaryAuthentication(sessions, NoAuthMethods...),
+	))
+	userv1.RegisterUserServiceServer(srv, NewGrpcServer(h))
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.Dial(
+		"bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return userv1.NewUserServiceClient(conn), sessions
+}
+
+// testPool is shared, the pool publishes its metrics under a unique name.
+var testPool = password.NewPool("test_grpc_password_hashing", 1, time.Second)
+
+func withToken(token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), middleware.AutenticationHeader, token)
+}
+
+// assertStatus checks the status code and the stable error code of the errdetails.ErrorInfo.
+func assertStatus(t *testing.T, err error, code codes.Code, reason string) {
+	t.Helper()
+	st := status.Convert(err)
+	if st.Code() != code {
+		t.Fatalf("code %v, want %v: %v", st.Code(), code, err)
+	}
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			if info.Reason != reason || info.Domain != grpc_utils.ErrorDomain {
+				t.Fatalf("reason %s/%s, want %s/%s", info.Domain, info.Reason, grpc_utils.ErrorDomain, reason)
+			}
+			return
+		}
+	}
+	t.Fatalf("no ErrorInfo in %v", err)
+}
+
+func signup(t *testing.T, client userv1.UserServiceClient, email string) *userv1.AuthResponse {
+	t.Helper()
+	resp, err := client.Signup(context.Background(), &userv1.SignupRequest{
+		Email:     email,
+		Password:  testPassword,
+		FirstName: "John",
+		LastName:  "Doe",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestGrpcSignup(t *testing.T) {
+	client, _ := newTestClient(t)
+
+	resp := signup(t, client, "john@doe.com")
+	if resp.Token == "" || resp.UserId == 0 {
+		t.Fatalf("signup response %v", resp)
+	}
+	if resp.PasswordChangeRequired {
+		t.Error("new user has to change the password")
+	}
+
+	_, err := client.Signup(context.Background(), &userv1.SignupRequest{
+		Email:     "john@doe.com",
+		Password:  testPassword,
+		FirstName: "John",
+		LastName:  "Doe",
+	})
+	assertStatus(t, err, codes.AlreadyExists, CodeUserExists)
+
+	_, err = client.Signup(context.Background(), &userv1.SignupRequest{Email: "not an email", Password: testPassword})
+	assertStatus(t, err, codes.InvalidArgument, "validation_failed")
+}
+
+func TestGrpcLogin(t *testing.T) {
+	client, _ := newTestClient(t)
+	created := signup(t, client, "john@doe.com")
+
+	resp, err := client.Login(context.Background(), &userv1.LoginRequest{Email: "john@doe.com", Password: testPassword})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Token == "" || resp.UserId != created.UserId {
+		t.Fatalf("login response %v", resp)
+	}
+
+	_, err = client.Login(context.Background(), &userv1.LoginRequest{Email: "john@doe.com", Password: "Wrong-Horse-42"})
+	assertStatus(t, err, codes.Unauthenticated, CodeInvalidCredentials)
+	_, err = client.Login(context.Background(), &userv1.LoginRequest{Email: "jane@doe.com", Password: testPassword})
+	assertStatus(t, err, codes.Unauthenticated, CodeInvalidCredentials)
+}
+
+func TestGrpcAuthentication(t *testing.T) {
+	client, sessions := newTestClient(t)
+	created := signup(t, client, "john@doe.com")
+
+	_, err := client.GetUser(context.Background(), &userv1.GetUserRequest{Id: created.UserId})
+	assertStatus(t, err, codes.Unauthenticated, "unauthorized")
+	_, err = client.GetUser(withToken("not a token"), &userv1.GetUserRequest{Id: created.UserId})
+	assertStatus(t, err, codes.Unauthenticated, "unauthorized")
+
+	u, err := client.GetUser(withToken(created.Token), &userv1.GetUserRequest{Id: created.UserId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Email != "john@doe.com" {
+		t.Errorf("user %v", u)
+	}
+
+	// tokens issued to OAuth clients are for the OAuth resource endpoints
+	user := &users.User{ID: created.UserId, Email: "john@doe.com", PassHash: "hash"}
+	oauthToken, err := sessions.Issue(context.Background(), user, "c_test", "openid", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.GetUser(withToken(oauthToken), &userv1.GetUserRequest{Id: created.UserId})
+	assertStatus(t, err, codes.PermissionDenied, "forbidden")
+
+	// restricted tokens can only change the password over HTTP
+	user.MustChangePassword = true
+	restricted, err := sessions.Issue(context.Background(), user, "", "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.GetUser(withToken(restricted), &userv1.GetUserRequest{Id: created.UserId})
+	assertStatus(t, err, codes.PermissionDenied, middleware.CodePasswordChangeRequired)
+}
+
+func TestGrpcValidateToken(t *testing.T) {
+	client, sessions := newTestClient(t)
+	created := signup(t, client, "john@doe.com")
+
+	resp, err := client.ValidateToken(context.Background(), &userv1.ValidateTokenRequest{Token: created.Token})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.UserId != created.UserId || resp.SessionId == "" || resp.Role != "" || resp.Scope != "" {
+		t.Errorf("validate token response %v", resp)
+	}
+
+	_, err = client.ValidateToken(context.Background(), &userv1.ValidateTokenRequest{Token: "not a token"})
+	assertStatus(t, err, codes.Unauthenticated, "unauthorized")
+
+	user := &users.User{ID: created.UserId, Email: "john@doe.com", PassHash: "hash"}
+	oauthToken, err := sessions.Issue(context.Background(), user, "c_test", "openid", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.ValidateToken(context.Background(), &userv1.ValidateTokenRequest{Token: oauthToken})
+	assertStatus(t, err, codes.PermissionDenied, "forbidden")
+}
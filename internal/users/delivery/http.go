@@ -1,24 +1,37 @@
 package delivery
 
 import (
+	"math"
 	"net/http"
 	"strconv"
 
+	lockout_usecase "github.com/Ollub/user_service/internal/lockout/usecase"
+	login_usecase "github.com/Ollub/user_service/internal/logins/usecase"
 	"github.com/Ollub/user_service/internal/session"
 	"github.com/Ollub/user_service/internal/users"
 	"github.com/Ollub/user_service/internal/users/usecase"
 	"github.com/Ollub/user_service/pkg/log"
 	"github.com/Ollub/user_service/pkg/utils/http_utils"
+	"github.com/Ollub/user_service/pkg/utils/password"
 	"github.com/gorilla/mux"
 )
 
 type Handler struct {
 	sessions *session.SessionsJWTVer
 	users    *usecase.Manager
+	logins   *login_usecase.Manager
+	lockout  *lockout_usecase.Manager
+	policy   *password.Policy
 }
 
-func NewHandler(sessionManager *session.SessionsJWTVer, userManager *usecase.Manager) *Handler {
-	return &Handler{sessionManager, userManager}
+func NewHandler(
+	sessionManager *session.SessionsJWTVer,
+	userManager *usecase.Manager,
+	loginManager *login_usecase.Manager,
+	lockoutManager *lockout_usecase.Manager,
+	passwordPolicy *password.Policy,
+) *Handler {
+	return &Handler{sessionManager, userManager, loginManager, lockoutManager, passwordPolicy}
 }
 
 func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
@@ -26,46 +39,40 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 	loginReq, err := http_utils.FromBody[LoginReq](r)
 	if err != nil {
 		log.Clog(ctx).Error("Marshaling error", log.Fields{"err": err})
-		http_utils.HttpError(w, "Provided payload can not be marshalled", http.StatusBadRequest)
+		http_utils.HttpError(w, r, http_utils.CodeInvalidPayload, "Provided payload can not be marshalled", http.StatusBadRequest)
 		return
 	}
 
-	user, err := h.users.CheckPassByEmail(ctx, loginReq.Email, loginReq.Password)
+	// Unknown email and wrong password get the same response, so the endpoint can't be used to find registered emails
+	user, retryAfter, err := h.Authenticate(ctx, loginReq.Email, loginReq.Password)
 	switch err {
 	case nil:
 		// all is ok
-	case usecase.UserNotFoundError:
-		http_utils.HttpError(w, "User not found", http.StatusNotFound)
-	case usecase.BadPasswordError:
-		http_utils.HttpError(w, "Wrong password provided", http.StatusBadRequest)
+	case LoginThrottledError:
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		http_utils.HttpError(w, r, CodeLoginThrottled, "Too many failed login attempts, try again later", http.StatusTooManyRequests)
+	case usecase.UserNotFoundError, usecase.BadPasswordError:
+		http_utils.HttpError(w, r, CodeInvalidCredentials, "Invalid credentials", http.StatusUnauthorized)
+	case usecase.UserDisabledError:
+		http_utils.HttpError(w, r, CodeUserDisabled, "The user is disabled", http.StatusForbidden)
+	case usecase.ServiceBusyError:
+		w.Header().Set("Retry-After", "1")
+		http_utils.HttpError(w, r, http_utils.CodeServiceBusy, "Service is busy, try again later", http.StatusServiceUnavailable)
 	default:
 		log.Clog(ctx).Error("Error during checking user password", log.Fields{"err": err})
-		http_utils.HttpError(w, "Internal error", http.StatusInternalServerError)
-	}
-	if err != nil {
-		return
-	}
-	if err == usecase.UserNotFoundError {
-		http_utils.HttpError(w, "User not found", http.StatusNotFound)
-		return
-	}
-	if err == usecase.BadPasswordError {
-		http_utils.HttpError(w, "Wrong password provided", http.StatusBadRequest)
-		return
+		http_utils.HttpError(w, r, http_utils.CodeInternal, "Internal error", http.StatusInternalServerError)
 	}
 	if err != nil {
-		log.Clog(ctx).Error("Error during checking user password", log.Fields{"err": err})
-		http_utils.HttpError(w, "Internal error", http.StatusInternalServerError)
 		return
 	}
 
 	token, err := h.sessions.Create(r.Context(), user)
 	if err != nil {
 		log.Clog(ctx).Error("Cant issue token", log.Fields{"err": err.Error()})
-		http_utils.HttpError(w, "Internal error during token creation", http.StatusInternalServerError)
+		http_utils.HttpError(w, r, http_utils.CodeInternal, "Internal error during token creation", http.StatusInternalServerError)
 		return
 	}
-	http_utils.JsonResp(w, &LoginResp{token, user.ID}, http.StatusOK)
+	http_utils.JsonResp(w, &LoginResp{token, user.ID, h.users.PasswordChangeRequired(user)}, http.StatusOK)
 }
 
 func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
@@ -74,39 +81,44 @@ func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 	userIn, err := http_utils.FromBody[users.UserIn](r)
 	if err != nil {
 		log.Clog(ctx).Error("Marshaling error", log.Fields{"err": err})
-		http_utils.HttpError(w, "Provided payload can not be marshalled", http.StatusBadRequest)
+		http_utils.HttpError(w, r, http_utils.CodeInvalidPayload, "Provided payload can not be marshalled", http.StatusBadRequest)
 		return
 	}
 
-	if err := validateUser(userIn); err != nil {
-		http_utils.HttpError(w, err.Error(), http.StatusUnprocessableEntity)
+	if errs := validateUser(userIn, h.policy); len(errs) > 0 {
+		http_utils.ValidationError(w, r, errs, http.StatusUnprocessableEntity)
 		return
 	}
 
 	user, err := h.users.Create(ctx, userIn)
 	if err == usecase.UserExistsError {
-		http_utils.HttpError(w, "User exist", http.StatusBadRequest)
+		http_utils.HttpError(w, r, CodeUserExists, "User exist", http.StatusBadRequest)
+		return
+	}
+	if err == usecase.ServiceBusyError {
+		w.Header().Set("Retry-After", "1")
+		http_utils.HttpError(w, r, http_utils.CodeServiceBusy, "Service is busy, try again later", http.StatusServiceUnavailable)
 		return
 	}
 	if err != nil {
 		log.Clog(ctx).Error("Unexpected error during user creation", log.Fields{"err": err.Error()})
-		http_utils.HttpError(w, "Internal error during user creation", http.StatusInternalServerError)
+		http_utils.HttpError(w, r, http_utils.CodeInternal, "Internal error during user creation", http.StatusInternalServerError)
 		return
 	}
 
 	token, err := h.sessions.Create(r.Context(), user)
 	if err != nil {
 		log.Clog(ctx).Error("Cant issue token", log.Fields{"err": err.Error()})
-		http_utils.HttpError(w, "Internal error during token creation", http.StatusInternalServerError)
+		http_utils.HttpError(w, r, http_utils.CodeInternal, "Internal error during token creation", http.StatusInternalServerError)
 		return
 	}
-	http_utils.JsonResp(w, &LoginResp{token, user.ID}, http.StatusCreated)
+	http_utils.JsonResp(w, &LoginResp{Token: token, UserId: user.ID}, http.StatusCreated)
 }
 
 func (h *Handler) List(w http.ResponseWriter, r *http.Request) {
 	items, err := h.users.ListUsers(r.Context())
 	if err != nil {
-		http_utils.HttpError(w, "Internal error while listing users", http.StatusInternalServerError)
+		http_utils.HttpError(w, r, http_utils.CodeInternal, "Internal error while listing users", http.StatusInternalServerError)
 		return
 	}
 	http_utils.JsonResp(w, ListUsersResp{items}, http.StatusOK)
@@ -116,32 +128,166 @@ func (h *Handler) Update(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	userId, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		http_utils.HttpError(w, "Provided userId can not be converted to integer", http.StatusBadRequest)
+		fieldErr := http_utils.NewFieldError("id", http_utils.FieldInvalid, "should be an integer")
+		http_utils.ValidationError(w, r, []*http_utils.FieldError{fieldErr}, http.StatusBadRequest)
 		return
 	}
 	ctx := r.Context()
 	sess := session.FromContext(ctx)
 	if sess.UserID != uint32(userId) {
-		http_utils.HttpError(w, "User can update only his profile", http.StatusForbidden)
+		http_utils.HttpError(w, r, http_utils.CodeForbidden, "User can update only his profile", http.StatusForbidden)
 		return
 	}
 
 	payload, err := http_utils.FromBody[users.UserUpdate](r)
 	if err != nil {
 		log.Clog(ctx).Error("Marshaling error", log.Fields{"err": err})
-		http_utils.HttpError(w, "Provided payload can not be marshalled", http.StatusBadRequest)
+		http_utils.HttpError(w, r, http_utils.CodeInvalidPayload, "Provided payload can not be marshalled", http.StatusBadRequest)
 		return
 	}
 
 	user, err := h.users.PartialUpdate(ctx, uint32(userId), payload)
 	if err == usecase.UserNotFoundError {
-		http_utils.HttpError(w, "User not found", http.StatusNotFound)
+		http_utils.HttpError(w, r, http_utils.CodeNotFound, "User not found", http.StatusNotFound)
 		return
 	}
 	if err != nil {
 		log.Clog(ctx).Error("User update error", log.Fields{"userId": userId, "err": err.Error()})
-		http_utils.HttpError(w, "Internal during user update", http.StatusInternalServerError)
+		http_utils.HttpError(w, r, http_utils.CodeInternal, "Internal during user update", http.StatusInternalServerError)
 		return
 	}
 	http_utils.JsonResp(w, user, http.StatusOK)
 }
+
+// ChangePassword sets a new password of the current user and returns a new token,
+// tokens issued before the change are no longer valid.
+func (h *Handler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	payload, err := http_utils.FromBody[ChangePasswordReq](r)
+	if err != nil {
+		log.Clog(ctx).Error("Marshaling error", log.Fields{"err": err})
+		http_utils.HttpError(w, r, http_utils.CodeInvalidPayload, "Provided payload can not be marshalled", http.StatusBadRequest)
+		return
+	}
+
+	sess := session.FromContext(ctx)
+	user, err := h.users.GetUser(ctx, sess.UserID)
+	if err == usecase.UserNotFoundError {
+		http_utils.HttpError(w, r, http_utils.CodeNotFound, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http_utils.HttpError(w, r, http_utils.CodeInternal, "Internal error while retrieving user", http.StatusInternalServerError)
+		return
+	}
+	if errs := validatePasswordChange(payload, user, h.policy); len(errs) > 0 {
+		http_utils.ValidationError(w, r, errs, http.StatusUnprocessableEntity)
+		return
+	}
+
+	user, err = h.users.ChangePassword(ctx, user.ID, payload.CurrentPassword, payload.NewPassword)
+	switch err {
+	case nil:
+	case usecase.BadPasswordError:
+		http_utils.HttpError(w, r, CodeCurrentPasswordWrong, "Current password is wrong", http.StatusForbidden)
+	case usecase.PasswordReusedError:
+		fieldErr := http_utils.NewFieldError("newPassword", CodePasswordReused, "was used recently, choose another one")
+		http_utils.ValidationError(w, r, []*http_utils.FieldError{fieldErr}, http.StatusUnprocessableEntity)
+	case usecase.ServiceBusyError:
+		w.Header().Set("Retry-After", "1")
+		http_utils.HttpError(w, r, http_utils.CodeServiceBusy, "Service is busy, try again later", http.StatusServiceUnavailable)
+	default:
+		log.Clog(ctx).Error("Unexpected error during password change", log.Fields{"err": err.Error()})
+		http_utils.HttpError(w, r, http_utils.CodeInternal, "Internal error during password change", http.StatusInternalServerError)
+	}
+	if err != nil {
+		return
+	}
+
+	token, err := h.sessions.Create(ctx, user)
+	if err != nil {
+		log.Clog(ctx).Error("Cant issue token", log.Fields{"err": err.Error()})
+		http_utils.HttpError(w, r, http_utils.CodeInternal, "Internal error during token creation", http.StatusInternalServerError)
+		return
+	}
+	http_utils.JsonResp(w, &LoginResp{Token: token, UserId: user.ID}, http.StatusOK)
+}
+
+// Unlock removes the failed logins lock of the user account.
+func (h *Handler) Unlock(w http.ResponseWriter, r *http.Request) {
+	userId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		fieldErr := http_utils.NewFieldError("id", http_utils.FieldInvalid, "should be an integer")
+		http_utils.ValidationError(w, r, []*http_utils.FieldError{fieldErr}, http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+	user, err := h.users.GetUser(ctx, uint32(userId))
+	if err == usecase.UserNotFoundError {
+		http_utils.HttpError(w, r, http_utils.CodeNotFound, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http_utils.HttpError(w, r, http_utils.CodeInternal, "Internal error while retrieving user", http.StatusInternalServerError)
+		return
+	}
+	if err := h.lockout.Unlock(ctx, user.ID, user.Email); err != nil {
+		http_utils.HttpError(w, r, http_utils.CodeInternal, "Internal error while unlocking user", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RequirePasswordChange makes the user change the password on the next login.
+func (h *Handler) RequirePasswordChange(w http.ResponseWriter, r *http.Request) {
+	userId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		fieldErr := http_utils.NewFieldError("id", http_utils.FieldInvalid, "should be an integer")
+		http_utils.ValidationError(w, r, []*http_utils.FieldError{fieldErr}, http.StatusBadRequest)
+		return
+	}
+	err = h.users.RequirePasswordChange(r.Context(), uint32(userId))
+	if err == usecase.UserNotFoundError {
+		http_utils.HttpError(w, r, http_utils.CodeNotFound, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http_utils.HttpError(w, r, http_utils.CodeInternal, "Internal error while updating user", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Import creates users migrated from a legacy system with their bcrypt, pbkdf2 or scrypt password hashes.
+// Users which are invalid or already exist are skipped and reported.
+func (h *Handler) Import(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	payload, err := http_utils.FromBody[ImportReq](r)
+	if err != nil {
+		log.Clog(ctx).Error("Marshaling error", log.Fields{"err": err})
+		http_utils.HttpError(w, r, http_utils.CodeInvalidPayload, "Provided payload can not be marshalled", http.StatusBadRequest)
+		return
+	}
+
+	resp := &ImportResp{Created: []uint32{}, Skipped: []*ImportSkipped{}}
+	for _, in := range payload.Users {
+		if errs := validateImport(in); len(errs) > 0 {
+			resp.Skipped = append(resp.Skipped, &ImportSkipped{in.Email, errs})
+			continue
+		}
+		user, err := h.users.Import(ctx, in)
+		if err == usecase.UserExistsError {
+			fieldErr := http_utils.NewFieldError("email", CodeUserExists, "user exists")
+			resp.Skipped = append(resp.Skipped, &ImportSkipped{in.Email, []*http_utils.FieldError{fieldErr}})
+			continue
+		}
+		if err != nil {
+			log.Clog(ctx).Error("Unexpected error during user import", log.Fields{"err": err.Error()})
+			http_utils.HttpError(w, r, http_utils.CodeInternal, "Internal error during user import", http.StatusInternalServerError)
+			return
+		}
+		resp.Created = append(resp.Created, user.ID)
+	}
+	log.Clog(ctx).Info("Users imported", log.Fields{"created": len(resp.Created), "skipped": len(resp.Skipped)})
+	http_utils.JsonResp(w, resp, http.StatusOK)
+}
@@ -0,0 +1,46 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Ollub/user_service/internal/logins"
+	"github.com/Ollub/user_service/internal/users"
+	"github.com/Ollub/user_service/internal/users/usecase"
+)
+
+var LoginThrottledError = errors.New("too many failed login attempts")
+
+// Authenticate checks the credentials behind the brute-force protection and records the attempt.
+// LoginThrottledError is returned with the time to wait while the account or ip is locked,
+// other errors are the ones of Manager.CheckPassByEmail.
+func (h *Handler) Authenticate(ctx context.Context, email, pass string) (*users.User, time.Duration, error) {
+	// the attempt is counted as failed before the password is checked, parallel attempts can't skip the backoff
+	retryAfter, err := h.lockout.Begin(ctx, email)
+	if err != nil {
+		return nil, 0, err
+	}
+	if retryAfter > 0 {
+		h.logins.Record(ctx, email, false, logins.ReasonThrottled)
+		return nil, retryAfter, LoginThrottledError
+	}
+
+	user, err := h.users.CheckPassByEmail(ctx, email, pass)
+	switch err {
+	case nil:
+		h.lockout.Succeed(ctx, email)
+		h.logins.Record(ctx, email, true, "")
+	case usecase.UserNotFoundError:
+		h.logins.Record(ctx, email, false, logins.ReasonUserNotFound)
+	case usecase.BadPasswordError:
+		h.logins.Record(ctx, email, false, logins.ReasonBadPassword)
+	case usecase.UserDisabledError:
+		// the password was right, it is not a brute-force attempt
+		h.lockout.Release(ctx, email)
+		h.logins.Record(ctx, email, false, logins.ReasonDisabled)
+	default:
+		h.lockout.Release(ctx, email)
+	}
+	return user, 0, err
+}
@@ -1,10 +1,15 @@
 package delivery
 
-import "github.com/Ollub/user_service/internal/users"
+import (
+	"github.com/Ollub/user_service/internal/users"
+	"github.com/Ollub/user_service/pkg/utils/http_utils"
+)
 
 type LoginResp struct {
 	Token  string `json:"token"`
 	UserId uint32 `json:"userId"`
+	// The token is only good for PUT /me/password
+	PasswordChangeRequired bool `json:"passwordChangeRequired,omitempty"`
 }
 
 type LoginReq struct {
@@ -12,6 +17,25 @@ type LoginReq struct {
 	Password string
 }
 
+type ChangePasswordReq struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
 type ListUsersResp struct {
 	Users []*users.User `json:"users"`
 }
+
+type ImportReq struct {
+	Users []*users.UserImport `json:"users"`
+}
+
+type ImportSkipped struct {
+	Email  string                   `json:"email"`
+	Errors []*http_utils.FieldError `json:"errors"`
+}
+
+type ImportResp struct {
+	Created []uint32         `json:"created"`
+	Skipped []*ImportSkipped `json:"skipped"`
+}
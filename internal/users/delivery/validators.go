@@ -1,113 +1,86 @@
 package delivery
 
 import (
-	"errors"
-	"fmt"
 	"regexp"
-	"strings"
-	"unicode"
 
 	"github.com/Ollub/user_service/internal/users"
+	"github.com/Ollub/user_service/pkg/utils/http_utils"
+	"github.com/Ollub/user_service/pkg/utils/password"
 )
 
-const PASS_MIN_LENGTH = 5
+// Stable codes of users errors, clients may rely on them.
+const (
+	CodeUserExists            = "user_exists"
+	CodeInvalidCredentials    = "invalid_credentials"
+	CodeLoginThrottled        = "login_throttled"
+	CodeCurrentPasswordWrong  = "current_password_wrong"
+	CodePasswordReused        = "password_reused"
+	CodeUnsupportedHashFormat = "unsupported_hash_format"
+	CodeUserDisabled          = "user_disabled"
+)
 
-func validateUser(user *users.UserIn) error {
-	var errMsg []string
+func validateUser(user *users.UserIn, policy *password.Policy) []*http_utils.FieldError {
+	var errs []*http_utils.FieldError
 
 	if user.FirstName == "" {
-		errMsg = append(errMsg, "firstName: may not be empty")
+		errs = append(errs, http_utils.NewFieldError("firstName", http_utils.FieldRequired, "may not be empty"))
 	}
 	if user.LastName == "" {
-		errMsg = append(errMsg, "lastName: may not be empty")
+		errs = append(errs, http_utils.NewFieldError("lastName", http_utils.FieldRequired, "may not be empty"))
 	}
 	if user.Email == "" {
-		errMsg = append(errMsg, "email: may not be empty")
+		errs = append(errs, http_utils.NewFieldError("email", http_utils.FieldRequired, "may not be empty"))
 	} else {
 		if ok := isEmailValid(user.Email); !ok {
-			errMsg = append(errMsg, "email: invalid")
-		}
-	}
-	if user.Password == "" {
-		errMsg = append(errMsg, "password: may not be empty")
-	} else {
-		verifier := NewPassVerifier(user.Password)
-		verifier.Verify()
-		if ok := verifier.IsValid(); !ok {
-			for _, msg := range verifier.ErrorMessages() {
-				errMsg = append(errMsg, fmt.Sprintf("password: %s", msg))
-			}
+			errs = append(errs, http_utils.NewFieldError("email", http_utils.FieldInvalid, "invalid"))
 		}
 	}
-
-	if len(errMsg) > 0 {
-		return errors.New(strings.Join(errMsg, "; "))
-	}
-	return nil
+	errs = append(errs, validatePassword("password", user.Password, policy, user.Email, user.FirstName, user.LastName)...)
+	return errs
 }
 
-func isEmailValid(e string) bool {
-	emailRegex := regexp.MustCompile(`^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,4}$`)
-	return emailRegex.MatchString(e)
-}
+func validatePasswordChange(req *ChangePasswordReq, user *users.User, policy *password.Policy) []*http_utils.FieldError {
+	var errs []*http_utils.FieldError
 
-type PassVerifier struct {
-	// initial args
-	password string
-	// config
-	minLength int
-	// validation result
-	length         bool
-	containLetters bool
-	containNumbers bool
-	containUpper   bool
-	containSpecial bool
-}
-
-func NewPassVerifier(password string) *PassVerifier {
-	return &PassVerifier{password: password, minLength: PASS_MIN_LENGTH}
-}
-
-func (v *PassVerifier) IsValid() bool {
-	return v.length && v.containLetters && v.containNumbers && v.containUpper && v.containSpecial
+	if req.CurrentPassword == "" {
+		errs = append(errs, http_utils.NewFieldError("currentPassword", http_utils.FieldRequired, "may not be empty"))
+	}
+	errs = append(errs, validatePassword("newPassword", req.NewPassword, policy, user.Email, user.FirstName, user.LastName)...)
+	return errs
 }
 
-func (v *PassVerifier) Verify() {
-	letters := 0
-	for _, c := range v.password {
-		switch {
-		case unicode.IsNumber(c):
-			v.containNumbers = true
-		case unicode.IsUpper(c):
-			v.containUpper = true
-			letters++
-		case unicode.IsPunct(c) || unicode.IsSymbol(c):
-			v.containSpecial = true
-		case unicode.IsLetter(c) || c == ' ':
-			v.containLetters = true
-		}
+func validatePassword(field, pass string, policy *password.Policy, personalInfo ...string) []*http_utils.FieldError {
+	if pass == "" {
+		return []*http_utils.FieldError{http_utils.NewFieldError(field, http_utils.FieldRequired, "may not be empty")}
 	}
-	if len(v.password) > 5 {
-		v.length = true
+	var errs []*http_utils.FieldError
+	for _, v := range policy.Check(pass, personalInfo...) {
+		errs = append(errs, &http_utils.FieldError{Field: field, Code: v.Code, Message: v.Message, Params: v.Params})
 	}
+	return errs
 }
 
-func (v *PassVerifier) ErrorMessages() []string {
-	var errMsg []string
-	if !v.length {
-		errMsg = append(errMsg, fmt.Sprintf("length should be greater then %d", v.minLength))
-	}
-	if !v.containLetters {
-		errMsg = append(errMsg, fmt.Sprintf("should contain letters"))
+func validateImport(user *users.UserImport) []*http_utils.FieldError {
+	var errs []*http_utils.FieldError
+
+	if user.FirstName == "" {
+		errs = append(errs, http_utils.NewFieldError("firstName", http_utils.FieldRequired, "may not be empty"))
 	}
-	if !v.containNumbers {
-		errMsg = append(errMsg, fmt.Sprintf("should contain numbers"))
+	if user.LastName == "" {
+		errs = append(errs, http_utils.NewFieldError("lastName", http_utils.FieldRequired, "may not be empty"))
 	}
-	if !v.containUpper {
-		errMsg = append(errMsg, fmt.Sprintf("should contain upper case letters"))
+	if ok := isEmailValid(user.Email); !ok {
+		errs = append(errs, http_utils.NewFieldError("email", http_utils.FieldInvalid, "invalid"))
 	}
-	if !v.containSpecial {
-		errMsg = append(errMsg, fmt.Sprintf("should contain special characters"))
+	if err := password.CheckHash(user.PasswordHash); err == password.UnsupportedHashError {
+		errs = append(errs, http_utils.NewFieldError("passwordHash", CodeUnsupportedHashFormat, "unsupported format"))
+	} else if err != nil {
+		errs = append(errs, http_utils.NewFieldError("passwordHash", http_utils.FieldInvalid, err.Error()))
 	}
-	return errMsg
+	return errs
+}
+
+func isEmailValid(e string) bool {
+	emailRegex := regexp.MustCompile(`^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,4}$`)
+	return emailRegex.MatchString(e)
 }
@@ -3,10 +3,34 @@ package repo
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgconn"
 
 	"github.com/Ollub/user_service/internal/users"
 )
 
+// exportBatchSize is the number of rows fetched from the server-side cursor at once.
+const exportBatchSize = 500
+
+// emailConstraint is the unique constraint of the users email, see the init migration.
+const emailConstraint = "uix_user_email"
+
+// uniqueViolation is the postgres error code of the unique constraint violation.
+const uniqueViolation = "23505"
+
+// storeError reports the violation of the email uniqueness as users.EmailTakenError,
+// the check before the insert or update doesn't guard against the concurrent requests.
+func storeError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation && pgErr.ConstraintName == emailConstraint {
+		return users.EmailTakenError
+	}
+	return err
+}
+
 type RepoPgx struct {
 	DB *sql.DB
 }
@@ -34,12 +58,133 @@ func (repo *RepoPgx) GetAll(ctx context.Context) ([]*users.User, error) {
 	return items, nil
 }
 
+// Iterate streams users matching the filter to fn using a server-side cursor,
+// so the result set is never fully loaded into memory. Password hashes are not selected.
+func (repo *RepoPgx) Iterate(ctx context.Context, f *users.Filter, fn func(*users.User) error) error {
+	where, args := filterClause(f)
+
+	tx, err := repo.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(
+		ctx,
+		`DECLARE users_export NO SCROLL CURSOR FOR `+
+			`SELECT id, first_name, last_name, email, role, version, created_at, updated_at FROM users`+
+			where+` ORDER BY id`,
+		args...,
+	)
+	if err != nil {
+		return err
+	}
+
+	for {
+		rows, err := tx.QueryContext(ctx, fmt.Sprintf("FETCH %d FROM users_export", exportBatchSize))
+		if err != nil {
+			return err
+		}
+		fetched := 0
+		for rows.Next() {
+			fetched++
+			u := &users.User{}
+			err = rows.Scan(&u.ID, &u.FirstName, &u.LastName, &u.Email, &u.Role, &u.Ver, &u.CreatedAt, &u.UpdatedAt)
+			if err == nil {
+				err = fn(u)
+			}
+			if err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		if err = rows.Err(); err != nil {
+			rows.Close()
+			return err
+		}
+		rows.Close()
+		if fetched < exportBatchSize {
+			break
+		}
+	}
+	return tx.Commit()
+}
+
+// List returns a page of the users matching the filter ordered by id. Password hashes are not selected.
+func (repo *RepoPgx) List(ctx context.Context, f *users.Filter, offset, limit int) ([]*users.User, error) {
+	where, args := filterClause(f)
+	items := []*users.User{}
+	rows, err := repo.DB.QueryContext(
+		ctx,
+		`SELECT id, first_name, last_name, email, role, version, created_at, updated_at, disabled, external_id FROM users`+
+			where+fmt.Sprintf(` ORDER BY id OFFSET %d LIMIT %d`, offset, limit),
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		u := &users.User{}
+		err = rows.Scan(&u.ID, &u.FirstName, &u.LastName, &u.Email, &u.Role, &u.Ver, &u.CreatedAt, &u.UpdatedAt, &u.Disabled, &u.ExternalID)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, u)
+	}
+	return items, rows.Err()
+}
+
+func (repo *RepoPgx) Count(ctx context.Context, f *users.Filter) (int, error) {
+	where, args := filterClause(f)
+	var count int
+	err := repo.DB.QueryRowContext(ctx, `SELECT count(*) FROM users`+where, args...).Scan(&count)
+	return count, err
+}
+
+// likeEscaper escapes the LIKE wildcards, so the email filter matches them literally.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func filterClause(f *users.Filter) (string, []interface{}) {
+	if f == nil {
+		return "", nil
+	}
+	var conds []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+	if f.Email != "" {
+		add("email ILIKE '%%' || $%d || '%%'", likeEscaper.Replace(f.Email))
+	}
+	if f.EmailExact != "" {
+		add("lower(email) = lower($%d)", f.EmailExact)
+	}
+	if f.ExternalID != "" {
+		add("external_id = $%d", f.ExternalID)
+	}
+	if f.Role != "" {
+		add("role = $%d", f.Role)
+	}
+	if !f.CreatedAfter.IsZero() {
+		add("created_at >= $%d", f.CreatedAfter)
+	}
+	if !f.CreatedBefore.IsZero() {
+		add("created_at < $%d", f.CreatedBefore)
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
 func (repo *RepoPgx) GetByID(ctx context.Context, id uint32) (*users.User, error) {
 	u := &users.User{}
 
 	err := repo.DB.
-		QueryRowContext(ctx, `SELECT id, first_name, last_name, email, version, password FROM users WHERE id = $1`, id).
-		Scan(&u.ID, &u.FirstName, &u.LastName, &u.Email, &u.Ver, &u.PassHash)
+		QueryRowContext(ctx, `SELECT id, first_name, last_name, email, role, version, password, created_at, updated_at, last_login_at, password_changed_at, must_change_password, disabled, external_id FROM users WHERE id = $1`, id).
+		Scan(&u.ID, &u.FirstName, &u.LastName, &u.Email, &u.Role, &u.Ver, &u.PassHash, &u.CreatedAt, &u.UpdatedAt, &u.LastLoginAt, &u.PasswordChangedAt, &u.MustChangePassword, &u.Disabled, &u.ExternalID)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -53,8 +198,8 @@ func (repo *RepoPgx) GetByEmail(ctx context.Context, email string) (*users.User,
 	u := &users.User{}
 
 	err := repo.DB.
-		QueryRowContext(ctx, `SELECT id, first_name, last_name, email, version, password FROM users WHERE email = $1`, email).
-		Scan(&u.ID, &u.FirstName, &u.LastName, &u.Email, &u.Ver, &u.PassHash)
+		QueryRowContext(ctx, `SELECT id, first_name, last_name, email, role, version, password, created_at, updated_at, last_login_at, password_changed_at, must_change_password, disabled, external_id FROM users WHERE email = $1`, email).
+		Scan(&u.ID, &u.FirstName, &u.LastName, &u.Email, &u.Role, &u.Ver, &u.PassHash, &u.CreatedAt, &u.UpdatedAt, &u.LastLoginAt, &u.PasswordChangedAt, &u.MustChangePassword, &u.Disabled, &u.ExternalID)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -64,19 +209,23 @@ func (repo *RepoPgx) GetByEmail(ctx context.Context, email string) (*users.User,
 	return u, nil
 }
 
+// Add stores the user and fills the timestamps set by the database.
 func (repo *RepoPgx) Add(ctx context.Context, u *users.User) (int64, error) {
 	var lastInsertId int64
 	err := repo.DB.QueryRowContext(
 		ctx,
-		`INSERT INTO users (first_name, last_name, email, version, password) VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		`INSERT INTO users (first_name, last_name, email, version, password, disabled, external_id) VALUES ($1, $2, $3, $4, $5, $6, $7) `+
+			`RETURNING id, created_at, updated_at, password_changed_at`,
 		u.FirstName,
 		u.LastName,
 		u.Email,
 		u.Ver,
 		u.PassHash,
-	).Scan(&lastInsertId)
+		u.Disabled,
+		u.ExternalID,
+	).Scan(&lastInsertId, &u.CreatedAt, &u.UpdatedAt, &u.PasswordChangedAt)
 	if err != nil {
-		return 0, err
+		return 0, storeError(err)
 	}
 	return lastInsertId, nil
 }
@@ -90,13 +239,107 @@ func (repo *RepoPgx) Update(ctx context.Context, u *users.User) (int64, error) {
 			`,"last_name" = $2`+
 			`,"email" = $3`+
 			`,"version" = $4`+
-			`WHERE id = $5`,
+			`,"must_change_password" = $5`+
+			`,"disabled" = $6`+
+			`,"external_id" = $7`+
+			`WHERE id = $8`,
 		u.FirstName,
 		u.LastName,
 		u.Email,
 		u.Ver,
+		u.MustChangePassword,
+		u.Disabled,
+		u.ExternalID,
 		u.ID,
 	)
+	if err != nil {
+		return 0, storeError(err)
+	}
+	return result.RowsAffected()
+}
+
+// ChangePassword stores the new password hash with the bumped version, so tokens issued before become invalid,
+// and clears the forced password change flag.
+// The previous hash is added to the password history, only the last keep hashes of the user are retained.
+func (repo *RepoPgx) ChangePassword(ctx context.Context, u *users.User, prevHash string, keep int) error {
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(
+		ctx,
+		`UPDATE users SET password = $1, version = $2, password_changed_at = now(), must_change_password = false `+
+			`WHERE id = $3 RETURNING password_changed_at`,
+		u.PassHash,
+		u.Ver,
+		u.ID,
+	).Scan(&u.PasswordChangedAt)
+	if err != nil {
+		return err
+	}
+	if keep > 0 {
+		_, err = tx.ExecContext(ctx, `INSERT INTO password_history (user_id, password) VALUES ($1, $2)`, u.ID, prevHash)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(
+		ctx,
+		`DELETE FROM password_history WHERE user_id = $1 AND id NOT IN `+
+			`(SELECT id FROM password_history WHERE user_id = $1 ORDER BY id DESC LIMIT $2)`,
+		u.ID,
+		keep,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// PasswordHistory returns up to limit previous password hashes of the user, newest first.
+func (repo *RepoPgx) PasswordHistory(ctx context.Context, userID uint32, limit int) ([]string, error) {
+	items := []string{}
+	rows, err := repo.DB.QueryContext(
+		ctx,
+		`SELECT password FROM password_history WHERE user_id = $1 ORDER BY id DESC LIMIT $2`,
+		userID,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hash string
+		if err = rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		items = append(items, hash)
+	}
+	return items, rows.Err()
+}
+
+// UpdatePassword replaces the hash only if it is still oldHash, false is returned if the password
+// was changed meanwhile.
+func (repo *RepoPgx) UpdatePassword(ctx context.Context, id uint32, oldHash, passHash string) (bool, error) {
+	result, err := repo.DB.ExecContext(
+		ctx,
+		`UPDATE users SET password = $1 WHERE id = $2 AND password = $3`,
+		passHash,
+		id,
+		oldHash,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (repo *RepoPgx) Delete(ctx context.Context, id uint32) (int64, error) {
+	result, err := repo.DB.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, id)
 	if err != nil {
 		return 0, err
 	}
@@ -5,3 +5,6 @@ import "errors"
 var UserExistsError = errors.New("user already exists")
 var UserNotFoundError = errors.New("user not found")
 var BadPasswordError = errors.New("passwords dont match")
+var PasswordReusedError = errors.New("password was used recently")
+var ServiceBusyError = errors.New("service is busy, try again later")
+var UserDisabledError = errors.New("user is disabled")
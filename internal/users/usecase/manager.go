@@ -3,9 +3,13 @@ package usecase
 import (
 	"context"
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/Ollub/user_service/internal/audit"
 	"github.com/Ollub/user_service/internal/users"
 	"github.com/Ollub/user_service/pkg/log"
+	"github.com/Ollub/user_service/pkg/utils"
 	"github.com/Ollub/user_service/pkg/utils/password"
 )
 
@@ -14,25 +18,68 @@ type Repo interface {
 	GetByEmail(context.Context, string) (*users.User, error)
 	GetByID(ctx context.Context, id uint32) (*users.User, error)
 	GetAll(ctx context.Context) ([]*users.User, error)
+	Iterate(ctx context.Context, f *users.Filter, fn func(*users.User) error) error
 	Update(ctx context.Context, u *users.User) (int64, error)
+	UpdatePassword(ctx context.Context, id uint32, oldHash, passHash string) (bool, error)
+	ChangePassword(ctx context.Context, u *users.User, prevHash string, keep int) error
+	PasswordHistory(ctx context.Context, userID uint32, limit int) ([]string, error)
+	List(ctx context.Context, f *users.Filter, offset, limit int) ([]*users.User, error)
+	Count(ctx context.Context, f *users.Filter) (int, error)
+	Delete(ctx context.Context, id uint32) (int64, error)
+}
+
+type Auditor interface {
+	Record(ctx context.Context, e *audit.Event)
+}
+
+// Authenticator checks passwords against an external directory, e.g. LDAP.
+// Authenticate returns the profile of the directory user, nil if the directory has no user with the email,
+// or BadPasswordError if the password is wrong.
+type Authenticator interface {
+	Name() string
+	Authenticate(ctx context.Context, email, pass string) (*users.UserIn, error)
 }
 
 type Manager struct {
 	repo        Repo
+	auditor     Auditor
+	hasher      *password.Pool
 	argonParams *password.ArgonParams
+	// Number of previous passwords which can not be reused
+	historySize int
+	// Passwords older than that have to be changed, zero disables the expiry
+	passwordMaxAge time.Duration
+	// dummyHash is verified for unknown emails, so they take as long as existing ones
+	dummyHash string
+
+	// authenticators are asked in order for the users without a password of the service
+	authenticators []Authenticator
 }
 
-func NewManager(repo Repo) *Manager {
-	return &Manager{
-		repo: repo,
-		argonParams: &password.ArgonParams{
-			Memory:      64 * 1024, // 64 MB
-			Iterations:  3,
-			Parallelism: 1,
-			SaltLength:  16,
-			KeyLength:   32,
-		},
+func NewManager(
+	repo Repo,
+	auditor Auditor,
+	hasher *password.Pool,
+	argonParams *password.ArgonParams,
+	historySize int,
+	passwordMaxAge time.Duration,
+	authenticators ...Authenticator,
+) *Manager {
+	m := &Manager{
+		repo:           repo,
+		auditor:        auditor,
+		hasher:         hasher,
+		argonParams:    argonParams,
+		historySize:    historySize,
+		passwordMaxAge: passwordMaxAge,
+		authenticators: authenticators,
+	}
+	dummyHash, err := password.GenerateHash(utils.RandStringRunes(16), m.argonParams)
+	if err != nil {
+		panic(err)
 	}
+	m.dummyHash = dummyHash
+	return m
 }
 
 func (m *Manager) Create(ctx context.Context, in *users.UserIn) (*users.User, error) {
@@ -46,39 +93,303 @@ func (m *Manager) Create(ctx context.Context, in *users.UserIn) (*users.User, er
 		return nil, UserExistsError
 	}
 
-	pass, err := password.GenerateHash(in.Password, m.argonParams)
+	pass, err := m.hasher.GenerateHash(ctx, in.Password, m.argonParams)
+	if err == password.PoolSaturatedError {
+		log.Clog(ctx).Warn("Password hashing pool is saturated")
+		return nil, ServiceBusyError
+	}
 	if err != nil {
 		log.Clog(ctx).Info("Error during hash generation", log.Fields{"error": err})
 		return nil, fmt.Errorf("create user: %w", err)
 	}
 
+	user := &users.User{
+		LastName:          in.LastName,
+		FirstName:         in.FirstName,
+		Email:             in.Email,
+		Ver:               0,
+		PassHash:          pass,
+		PasswordChangedAt: time.Now(),
+	}
+
+	lastId, err := m.repo.Add(ctx, user)
+	if err == users.EmailTakenError {
+		return nil, UserExistsError
+	}
+	if err != nil {
+		return nil, fmt.Errorf("new store: %w", err)
+	}
+	user.ID = uint32(lastId)
+	log.Clog(ctx).Info("UserId created", log.Fields{"id": user.ID, "email": user.Email})
+	m.auditor.Record(ctx, &audit.Event{
+		ActorID:  audit.UserRef(user.ID),
+		TargetID: audit.UserRef(user.ID),
+		Action:   audit.ActionUserCreated,
+		Changes: audit.Diff(nil, map[string]interface{}{
+			"email":     user.Email,
+			"firstName": user.FirstName,
+			"lastName":  user.LastName,
+		}),
+	})
+	return user, nil
+}
+
+// Import creates a user with an already hashed password, e.g. bcrypt or pbkdf2 from a legacy system.
+// The hash is upgraded to argon2id on the first successful login.
+func (m *Manager) Import(ctx context.Context, in *users.UserImport) (*users.User, error) {
+	if !password.IsSupported(in.PasswordHash) {
+		return nil, password.UnsupportedHashError
+	}
+	u, err := m.repo.GetByEmail(ctx, in.Email)
+	if err != nil {
+		log.Clog(ctx).Error("Error while check user exists", log.Fields{"error": err.Error()})
+		return nil, fmt.Errorf("import user: %w", err)
+	}
+	if u != nil {
+		return nil, UserExistsError
+	}
+
 	user := &users.User{
 		LastName:  in.LastName,
 		FirstName: in.FirstName,
 		Email:     in.Email,
 		Ver:       0,
-		PassHash:  pass,
+		PassHash:  in.PasswordHash,
+	}
+	lastId, err := m.repo.Add(ctx, user)
+	if err == users.EmailTakenError {
+		return nil, UserExistsError
 	}
+	if err != nil {
+		return nil, fmt.Errorf("import user: %w", err)
+	}
+	user.ID = uint32(lastId)
+	m.auditor.Record(ctx, &audit.Event{
+		TargetID: audit.UserRef(user.ID),
+		Action:   audit.ActionUserImported,
+		Changes: audit.Diff(nil, map[string]interface{}{
+			"email":     user.Email,
+			"firstName": user.FirstName,
+			"lastName":  user.LastName,
+		}),
+	})
+	return user, nil
+}
+
+// Provision creates a user signing in with an external identity provider, the user gets no password.
+func (m *Manager) Provision(ctx context.Context, in *users.UserIn, provider string) (*users.User, error) {
+	return m.CreateAccount(ctx, &users.Account{Email: in.Email, FirstName: in.FirstName, LastName: in.LastName}, provider)
+}
 
+// CreateAccount creates a user pushed by a provisioning client or an external identity provider.
+// The user gets no password unless the account has one.
+func (m *Manager) CreateAccount(ctx context.Context, a *users.Account, provider string) (*users.User, error) {
+	u, err := m.repo.GetByEmail(ctx, a.Email)
+	if err != nil {
+		log.Clog(ctx).Error("Error while check user exists", log.Fields{"error": err.Error()})
+		return nil, fmt.Errorf("provision user: %w", err)
+	}
+	if u != nil {
+		return nil, UserExistsError
+	}
+
+	user := &users.User{
+		LastName:   a.LastName,
+		FirstName:  a.FirstName,
+		Email:      a.Email,
+		Ver:        0,
+		Disabled:   a.Disabled,
+		ExternalID: a.ExternalID,
+	}
+	if a.Password != "" {
+		user.PasswordChangedAt = time.Now()
+		user.PassHash, err = m.hasher.GenerateHash(ctx, a.Password, m.argonParams)
+		if err == password.PoolSaturatedError {
+			log.Clog(ctx).Warn("Password hashing pool is saturated")
+			return nil, ServiceBusyError
+		}
+		if err != nil {
+			return nil, fmt.Errorf("provision user: %w", err)
+		}
+	}
 	lastId, err := m.repo.Add(ctx, user)
+	if err == users.EmailTakenError {
+		return nil, UserExistsError
+	}
 	if err != nil {
-		return nil, fmt.Errorf("new store: %w", err)
+		return nil, fmt.Errorf("provision user: %w", err)
 	}
 	user.ID = uint32(lastId)
-	log.Clog(ctx).Info("UserId created", log.Fields{"id": user.ID, "email": user.Email})
+	log.Clog(ctx).Info("User provisioned", log.Fields{"id": user.ID, "email": user.Email, "provider": provider})
+	m.auditor.Record(ctx, &audit.Event{
+		ActorID:  audit.UserRef(user.ID),
+		TargetID: audit.UserRef(user.ID),
+		Action:   audit.ActionUserProvisioned,
+		Changes:  audit.Diff(nil, accountFields(user)),
+		Details:  map[string]interface{}{"provider": provider},
+	})
 	return user, nil
 }
 
-func (m *Manager) GetUserVersion(ctx context.Context, userId uint32) (int, error) {
+// UpdateAccount replaces the profile of the user with the account pushed by a provisioning client.
+// Disabling the user or changing the email bumps the version, so the tokens issued before are rejected.
+func (m *Manager) UpdateAccount(ctx context.Context, userId uint32, a *users.Account, provider string) (*users.User, error) {
+	u, err := m.GetUser(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(u.Email, a.Email) {
+		other, err := m.repo.GetByEmail(ctx, a.Email)
+		if err != nil {
+			log.Clog(ctx).Error("Error while check user exists", log.Fields{"error": err.Error()})
+			return nil, fmt.Errorf("update account: %w", err)
+		}
+		if other != nil {
+			return nil, UserExistsError
+		}
+	}
+
+	before := accountFields(u)
+	if (a.Disabled && !u.Disabled) || u.Email != a.Email {
+		u.Ver++
+	}
+	u.Email, u.FirstName, u.LastName = a.Email, a.FirstName, a.LastName
+	u.ExternalID, u.Disabled = a.ExternalID, a.Disabled
+	_, err = m.repo.Update(ctx, u)
+	if err == users.EmailTakenError {
+		return nil, UserExistsError
+	}
+	if err != nil {
+		log.Clog(ctx).Error("Error while updating user", log.Fields{"userId": userId, "error": err.Error()})
+		return nil, fmt.Errorf("update account: %w", err)
+	}
+	if changes := audit.Diff(before, accountFields(u)); len(changes) > 0 {
+		m.auditor.Record(ctx, &audit.Event{
+			TargetID: audit.UserRef(u.ID),
+			Action:   audit.ActionUserUpdated,
+			Changes:  changes,
+			Details:  map[string]interface{}{"provider": provider},
+		})
+	}
+	if a.Password == "" {
+		return u, nil
+	}
+
+	hash, err := m.hasher.GenerateHash(ctx, a.Password, m.argonParams)
+	if err == password.PoolSaturatedError {
+		log.Clog(ctx).Warn("Password hashing pool is saturated")
+		return nil, ServiceBusyError
+	}
+	if err != nil {
+		return nil, fmt.Errorf("update account: %w", err)
+	}
+	// there is nothing to keep in the history for users without a password
+	keep, prevHash := 0, u.PassHash
+	if u.HasPassword() {
+		keep = m.historySize
+	}
+	u.PassHash = hash
+	u.Ver++
+	if err := m.repo.ChangePassword(ctx, u, prevHash, keep); err != nil {
+		log.Clog(ctx).Error("Error while changing password", log.Fields{"userId": u.ID, "error": err.Error()})
+		return nil, fmt.Errorf("update account: %w", err)
+	}
+	u.MustChangePassword = false
+	m.auditor.Record(ctx, &audit.Event{
+		TargetID: audit.UserRef(u.ID),
+		Action:   audit.ActionPasswordChanged,
+		Details:  map[string]interface{}{"provider": provider},
+	})
+	return u, nil
+}
+
+// DeleteUser removes the user with its sessions, identities and history.
+func (m *Manager) DeleteUser(ctx context.Context, userId uint32, provider string) error {
+	n, err := m.repo.Delete(ctx, userId)
+	if err != nil {
+		log.Clog(ctx).Error("Error while deleting user", log.Fields{"userId": userId, "error": err.Error()})
+		return fmt.Errorf("delete user: %w", err)
+	}
+	if n == 0 {
+		return UserNotFoundError
+	}
+	log.Clog(ctx).Info("User deleted", log.Fields{"id": userId, "provider": provider})
+	m.auditor.Record(ctx, &audit.Event{
+		TargetID: audit.UserRef(userId),
+		Action:   audit.ActionUserDeleted,
+		Details:  map[string]interface{}{"provider": provider},
+	})
+	return nil
+}
+
+func accountFields(u *users.User) map[string]interface{} {
+	return map[string]interface{}{
+		"email":      u.Email,
+		"firstName":  u.FirstName,
+		"lastName":   u.LastName,
+		"externalId": u.ExternalID,
+		"disabled":   u.Disabled,
+	}
+}
+
+func (m *Manager) GetUser(ctx context.Context, userId uint32) (*users.User, error) {
 	u, err := m.repo.GetByID(ctx, userId)
 	if err != nil {
 		log.Clog(ctx).Error("Error while retrieving the user", log.Fields{"userId": userId, "error": err.Error()})
-		return 0, fmt.Errorf("get user version: %w", err)
+		return nil, fmt.Errorf("get user: %w", err)
 	}
 	if u == nil {
-		return 0, UserNotFoundError
+		return nil, UserNotFoundError
 	}
-	return u.Ver, nil
+	return u, nil
+}
+
+func (m *Manager) GetUserByEmail(ctx context.Context, email string) (*users.User, error) {
+	u, err := m.repo.GetByEmail(ctx, email)
+	if err != nil {
+		log.Clog(ctx).Error("Error while retrieving the user", log.Fields{"userEmail": email, "error": err.Error()})
+		return nil, fmt.Errorf("get user by email: %w", err)
+	}
+	if u == nil {
+		return nil, UserNotFoundError
+	}
+	return u, nil
+}
+
+func (m *Manager) GetProfile(ctx context.Context, userId uint32) (*users.Profile, error) {
+	u, err := m.GetUser(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	return &users.Profile{
+		ID:        u.ID,
+		Email:     u.Email,
+		FirstName: u.FirstName,
+		LastName:  u.LastName,
+		Role:      u.Role,
+		CreatedAt: u.CreatedAt,
+		UpdatedAt: u.UpdatedAt,
+
+		LastLoginAt: u.LastLoginAt,
+	}, nil
+}
+
+// ListPage returns the users matching the filter from offset and the total number of them.
+func (m *Manager) ListPage(ctx context.Context, f *users.Filter, offset, limit int) ([]*users.User, int, error) {
+	total, err := m.repo.Count(ctx, f)
+	if err != nil {
+		log.Clog(ctx).Error("Error while counting users", log.Fields{"error": err.Error()})
+		return nil, 0, fmt.Errorf("list users: %w", err)
+	}
+	if limit == 0 || offset >= total {
+		return []*users.User{}, total, nil
+	}
+	items, err := m.repo.List(ctx, f, offset, limit)
+	if err != nil {
+		log.Clog(ctx).Error("Error while listing users", log.Fields{"error": err.Error()})
+		return nil, 0, fmt.Errorf("list users: %w", err)
+	}
+	return items, total, nil
 }
 
 func (m *Manager) ListUsers(ctx context.Context) ([]*users.User, error) {
@@ -90,6 +401,15 @@ func (m *Manager) ListUsers(ctx context.Context) ([]*users.User, error) {
 	return items, nil
 }
 
+// ExportUsers streams users matching the filter to fn without loading them all into memory.
+func (m *Manager) ExportUsers(ctx context.Context, f *users.Filter, fn func(*users.User) error) error {
+	if err := m.repo.Iterate(ctx, f, fn); err != nil {
+		log.Clog(ctx).Error("Error while exporting users", log.Fields{"error": err.Error()})
+		return fmt.Errorf("export users: %w", err)
+	}
+	return nil
+}
+
 func (m *Manager) PartialUpdate(ctx context.Context, userId uint32, payload *users.UserUpdate) (*users.User, error) {
 	u, err := m.repo.GetByID(ctx, userId)
 	if err != nil {
@@ -99,6 +419,7 @@ func (m *Manager) PartialUpdate(ctx context.Context, userId uint32, payload *use
 	if u == nil {
 		return nil, UserNotFoundError
 	}
+	before := map[string]interface{}{"firstName": u.FirstName, "lastName": u.LastName}
 	if payload.LastName != "" {
 		u.LastName = payload.LastName
 	}
@@ -111,6 +432,11 @@ func (m *Manager) PartialUpdate(ctx context.Context, userId uint32, payload *use
 		log.Clog(ctx).Error("Error while updating user", log.Fields{"userId": userId, "error": err.Error()})
 		return nil, fmt.Errorf("update user: %w", err)
 	}
+	m.auditor.Record(ctx, &audit.Event{
+		TargetID: audit.UserRef(u.ID),
+		Action:   audit.ActionUserUpdated,
+		Changes:  audit.Diff(before, map[string]interface{}{"firstName": u.FirstName, "lastName": u.LastName}),
+	})
 	return u, nil
 }
 
@@ -120,11 +446,315 @@ func (m *Manager) CheckPassByEmail(ctx context.Context, email, pass string) (*us
 		log.Clog(ctx).Error("Error while retrieving the user", log.Fields{"userEmail": email, "error": err.Error()})
 		return nil, fmt.Errorf("check user password: %w", err)
 	}
+	if u == nil || !u.HasPassword() {
+		if du, err := m.checkDirectories(ctx, u, email, pass); du != nil || err != nil {
+			return du, err
+		}
+	}
 	if u == nil {
+		if _, err := m.hasher.VerifyPassword(ctx, pass, m.dummyHash); err == password.PoolSaturatedError {
+			log.Clog(ctx).Warn("Password hashing pool is saturated")
+			return nil, ServiceBusyError
+		}
+		m.auditor.Record(ctx, &audit.Event{
+			Action:  audit.ActionLoginFailed,
+			Details: map[string]interface{}{"email": email, "reason": "user not found"},
+		})
 		return nil, UserNotFoundError
 	}
-	if ok, err := password.VerifyPassword(pass, u.PassHash); !ok || err != nil {
+	if !u.HasPassword() {
+		if _, err := m.hasher.VerifyPassword(ctx, pass, m.dummyHash); err == password.PoolSaturatedError {
+			log.Clog(ctx).Warn("Password hashing pool is saturated")
+			return nil, ServiceBusyError
+		}
+		m.auditor.Record(ctx, &audit.Event{
+			TargetID: audit.UserRef(u.ID),
+			Action:   audit.ActionLoginFailed,
+			Details:  map[string]interface{}{"email": email, "reason": "no password"},
+		})
+		return nil, BadPasswordError
+	}
+	ok, err := m.hasher.VerifyPassword(ctx, pass, u.PassHash)
+	if err == password.PoolSaturatedError {
+		log.Clog(ctx).Warn("Password hashing pool is saturated")
+		return nil, ServiceBusyError
+	}
+	if err != nil {
+		// e.g. unsupported hash format or the hash pepper was removed from the config
+		log.Clog(ctx).Error("Error while verifying password", log.Fields{"userId": u.ID, "error": err.Error()})
+	}
+	if !ok || err != nil {
+		m.auditor.Record(ctx, &audit.Event{
+			TargetID: audit.UserRef(u.ID),
+			Action:   audit.ActionLoginFailed,
+			Details:  map[string]interface{}{"email": email, "reason": "bad password"},
+		})
+		return nil, BadPasswordError
+	}
+	if u.Disabled {
+		m.auditor.Record(ctx, &audit.Event{
+			TargetID: audit.UserRef(u.ID),
+			Action:   audit.ActionLoginFailed,
+			Details:  map[string]interface{}{"email": email, "reason": "disabled"},
+		})
+		return nil, UserDisabledError
+	}
+	m.auditor.Record(ctx, &audit.Event{
+		ActorID:  audit.UserRef(u.ID),
+		TargetID: audit.UserRef(u.ID),
+		Action:   audit.ActionLoginSucceeded,
+	})
+	if password.NeedsRehash(u.PassHash, m.argonParams) {
+		m.rehash(ctx, u, pass)
+	}
+	return u, nil
+}
+
+// checkDirectories checks the password with the first authenticator knowing the email. The user is provisioned
+// on the first login and the first and last name are synced from the directory on every login.
+// It returns nil, nil if no directory knows the email.
+func (m *Manager) checkDirectories(ctx context.Context, u *users.User, email, pass string) (*users.User, error) {
+	var target *uint32
+	if u != nil {
+		target = audit.UserRef(u.ID)
+	}
+	for _, a := range m.authenticators {
+		profile, err := a.Authenticate(ctx, email, pass)
+		if err == BadPasswordError {
+			m.auditor.Record(ctx, &audit.Event{
+				TargetID: target,
+				Action:   audit.ActionLoginFailed,
+				Details:  map[string]interface{}{"email": email, "reason": "bad password", "provider": a.Name()},
+			})
+			return nil, BadPasswordError
+		}
+		if err != nil {
+			log.Clog(ctx).Error("Error while checking password with the directory", log.Fields{"provider": a.Name(), "userEmail": email, "error": err.Error()})
+			return nil, fmt.Errorf("check user password: %w", err)
+		}
+		if profile == nil {
+			continue
+		}
+
+		if u == nil {
+			u, err = m.Provision(ctx, profile, a.Name())
+			if err != nil {
+				return nil, err
+			}
+		} else if err := m.syncProfile(ctx, u, profile, a.Name()); err != nil {
+			return nil, err
+		}
+		if u.Disabled {
+			m.auditor.Record(ctx, &audit.Event{
+				TargetID: audit.UserRef(u.ID),
+				Action:   audit.ActionLoginFailed,
+				Details:  map[string]interface{}{"email": email, "reason": "disabled", "provider": a.Name()},
+			})
+			return nil, UserDisabledError
+		}
+		m.auditor.Record(ctx, &audit.Event{
+			ActorID:  audit.UserRef(u.ID),
+			TargetID: audit.UserRef(u.ID),
+			Action:   audit.ActionLoginSucceeded,
+			Details:  map[string]interface{}{"provider": a.Name()},
+		})
+		return u, nil
+	}
+	return nil, nil
+}
+
+// syncProfile stores the name of the directory user if it was changed there, empty directory values are ignored.
+// The version is not bumped, the tokens of the user stay valid.
+func (m *Manager) syncProfile(ctx context.Context, u *users.User, profile *users.UserIn, provider string) error {
+	before := map[string]interface{}{"firstName": u.FirstName, "lastName": u.LastName}
+	changed := false
+	if profile.FirstName != "" && profile.FirstName != u.FirstName {
+		u.FirstName, changed = profile.FirstName, true
+	}
+	if profile.LastName != "" && profile.LastName != u.LastName {
+		u.LastName, changed = profile.LastName, true
+	}
+	if !changed {
+		return nil
+	}
+	if _, err := m.repo.Update(ctx, u); err != nil {
+		log.Clog(ctx).Error("Error while updating user", log.Fields{"userId": u.ID, "error": err.Error()})
+		return fmt.Errorf("sync user profile: %w", err)
+	}
+	m.auditor.Record(ctx, &audit.Event{
+		TargetID: audit.UserRef(u.ID),
+		Action:   audit.ActionUserUpdated,
+		Changes:  audit.Diff(before, map[string]interface{}{"firstName": u.FirstName, "lastName": u.LastName}),
+		Details:  map[string]interface{}{"provider": provider},
+	})
+	return nil
+}
+
+// ChangePassword replaces the user password after checking the current one.
+// The new password must differ from the current one and the last historySize ones.
+// The user version is bumped, so all the tokens issued before are rejected.
+func (m *Manager) ChangePassword(ctx context.Context, userId uint32, current, newPass string) (*users.User, error) {
+	u, err := m.GetUser(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := m.hasher.VerifyPassword(ctx, current, u.PassHash)
+	if err == password.PoolSaturatedError {
+		log.Clog(ctx).Warn("Password hashing pool is saturated")
+		return nil, ServiceBusyError
+	}
+	if err != nil {
+		log.Clog(ctx).Error("Error while verifying password", log.Fields{"userId": u.ID, "error": err.Error()})
+	}
+	if !ok || err != nil {
 		return nil, BadPasswordError
 	}
+	if err := m.checkReuse(ctx, u, newPass); err != nil {
+		return nil, err
+	}
+
+	// legacy or outdated hashes are not kept in the history, the current password is hashed again instead
+	prevHash := u.PassHash
+	if m.historySize > 0 && password.NeedsRehash(prevHash, m.argonParams) {
+		prevHash, err = m.hasher.GenerateHash(ctx, current, m.argonParams)
+		if err == password.PoolSaturatedError {
+			log.Clog(ctx).Warn("Password hashing pool is saturated")
+			return nil, ServiceBusyError
+		}
+		if err != nil {
+			return nil, fmt.Errorf("change password: %w", err)
+		}
+	}
+
+	hash, err := m.hasher.GenerateHash(ctx, newPass, m.argonParams)
+	if err == password.PoolSaturatedError {
+		log.Clog(ctx).Warn("Password hashing pool is saturated")
+		return nil, ServiceBusyError
+	}
+	if err != nil {
+		return nil, fmt.Errorf("change password: %w", err)
+	}
+	u.PassHash = hash
+	u.Ver++
+	if err := m.repo.ChangePassword(ctx, u, prevHash, m.historySize); err != nil {
+		log.Clog(ctx).Error("Error while changing password", log.Fields{"userId": u.ID, "error": err.Error()})
+		return nil, fmt.Errorf("change password: %w", err)
+	}
+	u.MustChangePassword = false
+	log.Clog(ctx).Info("Password changed", log.Fields{"userId": u.ID})
+	m.auditor.Record(ctx, &audit.Event{
+		ActorID:  audit.UserRef(u.ID),
+		TargetID: audit.UserRef(u.ID),
+		Action:   audit.ActionPasswordChanged,
+	})
 	return u, nil
 }
+
+// PasswordChangeRequired reports whether the user may only change the password until it is done.
+func (m *Manager) PasswordChangeRequired(u *users.User) bool {
+	return u.PasswordChangeRequired(m.passwordMaxAge, time.Now())
+}
+
+// DropPassword removes the password of the user, who can sign in with the external identity provider only then.
+// Emails of the service are not verified, so the password may have been set by somebody registering the email
+// before its owner signed in with the provider. The user version is bumped, so the current tokens are rejected.
+func (m *Manager) DropPassword(ctx context.Context, userId uint32, provider string) (*users.User, error) {
+	u, err := m.GetUser(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if !u.HasPassword() {
+		return u, nil
+	}
+	prevHash := u.PassHash
+	u.PassHash = ""
+	u.Ver++
+	if err := m.repo.ChangePassword(ctx, u, prevHash, m.historySize); err != nil {
+		log.Clog(ctx).Error("Error while dropping password", log.Fields{"userId": u.ID, "error": err.Error()})
+		return nil, fmt.Errorf("drop password: %w", err)
+	}
+	u.MustChangePassword = false
+	m.auditor.Record(ctx, &audit.Event{
+		TargetID: audit.UserRef(u.ID),
+		Action:   audit.ActionPasswordChanged,
+		Details:  map[string]interface{}{"reason": "password dropped on identity link", "provider": provider},
+	})
+	return u, nil
+}
+
+// RequirePasswordChange forces the user to change the password.
+// The user version is bumped, so the current tokens are rejected and the next login gets a restricted one.
+func (m *Manager) RequirePasswordChange(ctx context.Context, userId uint32) error {
+	u, err := m.GetUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+	u.MustChangePassword = true
+	u.Ver++
+	if _, err := m.repo.Update(ctx, u); err != nil {
+		log.Clog(ctx).Error("Error while updating user", log.Fields{"userId": userId, "error": err.Error()})
+		return fmt.Errorf("require password change: %w", err)
+	}
+	m.auditor.Record(ctx, &audit.Event{
+		TargetID: audit.UserRef(u.ID),
+		Action:   audit.ActionPasswordChangeRequired,
+	})
+	return nil
+}
+
+// checkReuse returns PasswordReusedError if pass matches the current password or one of the previous ones.
+func (m *Manager) checkReuse(ctx context.Context, u *users.User, pass string) error {
+	hashes := []string{u.PassHash}
+	if m.historySize > 0 {
+		history, err := m.repo.PasswordHistory(ctx, u.ID, m.historySize)
+		if err != nil {
+			log.Clog(ctx).Error("Error while retrieving password history", log.Fields{"userId": u.ID, "error": err.Error()})
+			return fmt.Errorf("check password reuse: %w", err)
+		}
+		hashes = append(hashes, history...)
+	}
+	for _, hash := range hashes {
+		ok, err := m.hasher.VerifyPassword(ctx, pass, hash)
+		if err == password.PoolSaturatedError {
+			log.Clog(ctx).Warn("Password hashing pool is saturated")
+			return ServiceBusyError
+		}
+		if err != nil {
+			// e.g. the pepper of an old hash was removed, such a hash can't be checked anymore
+			log.Clog(ctx).Warn("Error while verifying previous password", log.Fields{"userId": u.ID, "error": err.Error()})
+			continue
+		}
+		if ok {
+			return PasswordReusedError
+		}
+	}
+	return nil
+}
+
+// rehash upgrades the stored hash to the current argon params.
+// It is done on login because it is the only moment the plain password is known.
+// Failures are only logged, the user is already authenticated.
+func (m *Manager) rehash(ctx context.Context, u *users.User, pass string) {
+	hash, err := m.hasher.GenerateHash(ctx, pass, m.argonParams)
+	if err != nil {
+		log.Clog(ctx).Warn("Password rehash skipped", log.Fields{"userId": u.ID, "error": err.Error()})
+		return
+	}
+	// the verified hash is compared, a password changed since the verification is not overwritten
+	updated, err := m.repo.UpdatePassword(ctx, u.ID, u.PassHash, hash)
+	if err != nil {
+		log.Clog(ctx).Error("Error while storing rehashed password", log.Fields{"userId": u.ID, "error": err.Error()})
+		return
+	}
+	if !updated {
+		log.Clog(ctx).Info("Password rehash skipped, password changed meanwhile", log.Fields{"userId": u.ID})
+		return
+	}
+	u.PassHash = hash
+	log.Clog(ctx).Info("Password rehashed", log.Fields{"userId": u.ID})
+	m.auditor.Record(ctx, &audit.Event{
+		ActorID:  audit.UserRef(u.ID),
+		TargetID: audit.UserRef(u.ID),
+		Action:   audit.ActionPasswordRehashed,
+	})
+}
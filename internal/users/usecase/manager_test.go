@@ -0,0 +1,170 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Ollub/user_service/internal/audit"
+	"github.com/Ollub/user_service/internal/users"
+	"github.com/Ollub/user_service/pkg/utils/password"
+)
+
+// fakeRepo keeps the users in memory, the methods the tests don't need panic on the nil Repo.
+type fakeRepo struct {
+	Repo
+	users map[string]*users.User
+}
+
+func newFakeRepo() *fakeRepo {
+	return &fakeRepo{users: map[string]*users.User{}}
+}
+
+// Add fails like the unique constraint of the database if the email is stored.
+func (r *fakeRepo) Add(_ context.Context, u *users.User) (int64, error) {
+	if _, ok := r.users[u.Email]; ok {
+		return 0, users.EmailTakenError
+	}
+	u.ID = uint32(len(r.users) + 1)
+	stored := *u
+	r.users[u.Email] = &stored
+	return int64(u.ID), nil
+}
+
+func (r *fakeRepo) GetByEmail(_ context.Context, email string) (*users.User, error) {
+	if u, ok := r.users[email]; ok {
+		stored := *u
+		return &stored, nil
+	}
+	return nil, nil
+}
+
+func (r *fakeRepo) GetByID(_ context.Context, id uint32) (*users.User, error) {
+	for _, u := range r.users {
+		if u.ID == id {
+			stored := *u
+			return &stored, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakeRepo) Update(_ context.Context, u *users.User) (int64, error) {
+	for email, stored := range r.users {
+		if email == u.Email && stored.ID != u.ID {
+			return 0, users.EmailTakenError
+		}
+	}
+	for email, stored := range r.users {
+		if stored.ID == u.ID {
+			delete(r.users, email)
+		}
+	}
+	updated := *u
+	r.users[u.Email] = &updated
+	return 1, nil
+}
+
+// racingRepo misses the users in GetByEmail, as if they were stored by a concurrent request after the check.
+type racingRepo struct {
+	*fakeRepo
+}
+
+func (r racingRepo) GetByEmail(context.Context, string) (*users.User, error) {
+	return nil, nil
+}
+
+type nopAuditor struct{}
+
+func (nopAuditor) Record(context.Context, *audit.Event) {}
+
+// testPool is shared, the pool publishes its metrics under a unique name.
+var testPool = password.NewPool("test_password_hashing", 1, time.Second)
+
+func newTestManager(repo Repo, passwordMaxAge time.Duration) *Manager {
+	params := &password.ArgonParams{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	return NewManager(repo, nopAuditor{}, testPool, params, 0, passwordMaxAge)
+}
+
+// With PASSWORD_MAX_AGE_DAYS set a new user must not get a token restricted to the password change.
+func TestCreateWithPasswordMaxAge(t *testing.T) {
+	m := newTestManager(newFakeRepo(), 90*24*time.Hour)
+
+	u, err := m.Create(context.Background(), &users.UserIn{
+		FirstName: "John",
+		LastName:  "Doe",
+		Email:     "john@doe.com",
+		Password:  "correct horse battery staple",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.PasswordChangedAt.IsZero() {
+		t.Error("password change time of the new user is not set")
+	}
+	if m.PasswordChangeRequired(u) {
+		t.Error("new user has to change the password")
+	}
+}
+
+func TestCreateAccountWithPasswordMaxAge(t *testing.T) {
+	m := newTestManager(newFakeRepo(), 90*24*time.Hour)
+
+	u, err := m.CreateAccount(context.Background(), &users.Account{
+		Email:    "jane@doe.com",
+		Password: "correct horse battery staple",
+	}, "scim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.PasswordChangeRequired(u) {
+		t.Error("new provisioned user has to change the password")
+	}
+}
+
+// The concurrent request wins the unique constraint, the loser gets the uniqueness error and not the internal one.
+func TestCreateAccountRace(t *testing.T) {
+	repo := newFakeRepo()
+	m := newTestManager(racingRepo{repo}, 0)
+	ctx := context.Background()
+
+	if _, err := m.CreateAccount(ctx, &users.Account{Email: "jane@doe.com"}, "scim"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.CreateAccount(ctx, &users.Account{Email: "jane@doe.com"}, "scim"); err != UserExistsError {
+		t.Errorf("got error %v, want %v", err, UserExistsError)
+	}
+
+	other, err := m.CreateAccount(ctx, &users.Account{Email: "john@doe.com"}, "scim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.UpdateAccount(ctx, other.ID, &users.Account{Email: "jane@doe.com"}, "scim"); err != UserExistsError {
+		t.Errorf("got error %v on email change, want %v", err, UserExistsError)
+	}
+}
+
+// The tokens issued for the old email must not be accepted after the change.
+func TestUpdateAccountEmailBumpsVersion(t *testing.T) {
+	m := newTestManager(newFakeRepo(), 0)
+	ctx := context.Background()
+
+	u, err := m.CreateAccount(ctx, &users.Account{Email: "jane@doe.com"}, "scim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	same, err := m.UpdateAccount(ctx, u.ID, &users.Account{Email: "jane@doe.com", FirstName: "Jane"}, "scim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if same.Ver != u.Ver {
+		t.Errorf("version changed to %d without an email change", same.Ver)
+	}
+	changed, err := m.UpdateAccount(ctx, u.ID, &users.Account{Email: "jane@example.com", Disabled: true}, "scim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed.Ver != u.Ver+1 {
+		t.Errorf("got version %d after the email change, want %d", changed.Ver, u.Ver+1)
+	}
+}
@@ -1,12 +1,68 @@
 package users
 
+import (
+	"errors"
+	"time"
+)
+
+// EmailTakenError is returned by the repository when the email is stored for another user.
+var EmailTakenError = errors.New("email is taken")
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 type User struct {
-	ID        uint32 `json:"id"`
-	Email     string `json:"email"`
-	FirstName string `json:"firstName"`
-	LastName  string `json:"lastName"`
-	Ver       int    `json:"-"`
-	PassHash  string `json:"-"`
+	ID        uint32    `json:"id"`
+	Email     string    `json:"email"`
+	FirstName string    `json:"firstName"`
+	LastName  string    `json:"lastName"`
+	Role      string    `json:"-"`
+	Ver       int       `json:"-"`
+	PassHash  string    `json:"-"` // empty for users signing in with an external identity provider only
+	CreatedAt time.Time `json:"-"`
+	UpdatedAt time.Time `json:"-"`
+
+	LastLoginAt *time.Time `json:"-"`
+	// The password is changed by the user itself, not rehashed
+	PasswordChangedAt  time.Time `json:"-"`
+	MustChangePassword bool      `json:"-"`
+
+	// Disabled users can't sign in and their tokens are rejected
+	Disabled bool `json:"-"`
+	// Id of the user in the provisioning client, e.g. the HR system pushing users over SCIM
+	ExternalID string `json:"-"`
+}
+
+// PasswordChangeRequired reports whether the user has to change the password before using the service:
+// an admin demanded it or the password is older than maxAge. Zero maxAge disables the expiry.
+func (u *User) PasswordChangeRequired(maxAge time.Duration, now time.Time) bool {
+	if !u.HasPassword() {
+		return false
+	}
+	if u.MustChangePassword {
+		return true
+	}
+	return maxAge > 0 && now.Sub(u.PasswordChangedAt) > maxAge
+}
+
+// HasPassword reports whether the user can sign in with a password of the service.
+func (u *User) HasPassword() bool {
+	return u.PassHash != ""
+}
+
+// Profile is the personal data of the user as it is handed over to the user itself.
+type Profile struct {
+	ID        uint32    `json:"id"`
+	Email     string    `json:"email"`
+	FirstName string    `json:"firstName"`
+	LastName  string    `json:"lastName"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	LastLoginAt *time.Time `json:"lastLoginAt"`
 }
 
 type UserIn struct {
@@ -16,7 +72,37 @@ type UserIn struct {
 	LastName  string `json:"lastName"`
 }
 
+// UserImport is a user migrated from a legacy system with its password hash as is.
+type UserImport struct {
+	Email        string `json:"email"`
+	FirstName    string `json:"firstName"`
+	LastName     string `json:"lastName"`
+	PasswordHash string `json:"passwordHash"`
+}
+
+// Account is the user as it is managed by a provisioning client.
+type Account struct {
+	Email      string
+	FirstName  string
+	LastName   string
+	ExternalID string
+	Disabled   bool
+	// Replaces the password if not empty
+	Password string
+}
+
 type UserUpdate struct {
 	FirstName string `json:"firstName"`
 	LastName  string `json:"lastName"`
 }
+
+// Filter narrows down the set of users returned by bulk queries.
+// Zero values are ignored.
+type Filter struct {
+	Email         string // substring match
+	EmailExact    string // case-insensitive match
+	ExternalID    string
+	Role          string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+}
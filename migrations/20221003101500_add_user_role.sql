@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'user';
+CREATE INDEX ix_users_created_at ON users (created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP INDEX IF EXISTS ix_users_created_at;
+ALTER TABLE users DROP COLUMN IF EXISTS role;
+-- +goose StatementEnd
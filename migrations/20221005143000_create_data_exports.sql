@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+
+CREATE TABLE data_exports(
+  id serial PRIMARY KEY,
+  user_id INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  status TEXT NOT NULL,
+  error TEXT NOT NULL DEFAULT '',
+  archive BYTEA,
+
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  finished_at TIMESTAMP WITH TIME ZONE,
+  expires_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX ix_data_exports_user_id ON data_exports (user_id);
+CREATE INDEX ix_data_exports_pending ON data_exports (created_at) WHERE status = 'pending';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP TABLE IF EXISTS data_exports;
+-- +goose StatementEnd
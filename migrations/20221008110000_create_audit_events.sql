@@ -0,0 +1,42 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+
+CREATE TABLE audit_events(
+  id bigserial PRIMARY KEY,
+  actor_id INT,
+  target_id INT,
+  action TEXT NOT NULL,
+  ip TEXT NOT NULL DEFAULT '',
+  user_agent TEXT NOT NULL DEFAULT '',
+  request_id TEXT NOT NULL DEFAULT '',
+  changes JSONB,
+  details JSONB,
+
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE INDEX ix_audit_events_actor_id ON audit_events (actor_id, id);
+CREATE INDEX ix_audit_events_target_id ON audit_events (target_id, id);
+CREATE INDEX ix_audit_events_action ON audit_events (action, id);
+CREATE INDEX ix_audit_events_created_at ON audit_events (created_at);
+
+CREATE OR REPLACE FUNCTION trigger_audit_events_append_only()
+    RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_append_only
+    BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW
+    EXECUTE PROCEDURE trigger_audit_events_append_only();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP TABLE IF EXISTS audit_events;
+DROP FUNCTION IF EXISTS trigger_audit_events_append_only();
+-- +goose StatementEnd
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+
+CREATE TABLE login_throttles(
+  key TEXT PRIMARY KEY,
+  failures INT NOT NULL,
+  last_failure_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  blocked_until TIMESTAMP WITH TIME ZONE
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP TABLE IF EXISTS login_throttles;
+-- +goose StatementEnd
@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+
+ALTER TABLE oauth_codes
+  ADD COLUMN nonce TEXT NOT NULL DEFAULT '',
+  ADD COLUMN auth_time TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+ALTER TABLE oauth_codes DROP COLUMN IF EXISTS nonce, DROP COLUMN IF EXISTS auth_time;
+-- +goose StatementEnd
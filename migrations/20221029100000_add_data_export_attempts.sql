@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+
+ALTER TABLE data_exports ADD COLUMN started_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE data_exports ADD COLUMN attempts INT NOT NULL DEFAULT 0;
+CREATE INDEX ix_data_exports_running ON data_exports (started_at) WHERE status = 'running';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP INDEX IF EXISTS ix_data_exports_running;
+ALTER TABLE data_exports DROP COLUMN IF EXISTS attempts;
+ALTER TABLE data_exports DROP COLUMN IF EXISTS started_at;
+-- +goose StatementEnd
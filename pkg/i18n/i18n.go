@@ -0,0 +1,160 @@
+// Package i18n translates messages identified by stable codes.
+//
+// Messages in the code are English, catalogs hold translations to other languages:
+// one JSON file per language named by its tag (de.json, pt-BR.json, ...) mapping codes to messages.
+// Messages may reference params as {name}.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultLanguage is the language of messages in the code, it needs no catalog.
+const DefaultLanguage = "en"
+
+//go:embed locales/*.json
+var builtin embed.FS
+
+type Config struct {
+	// Directory with extra catalogs, they override the built-in translations of the same codes
+	Dir string `envconfig:"LOCALES_DIR"`
+}
+
+var (
+	mu       sync.RWMutex
+	catalogs = mustLoadBuiltin()
+)
+
+// Setup adds the catalogs from cfg to the built-in ones.
+func Setup(cfg *Config) error {
+	loaded := mustLoadBuiltin()
+	if cfg.Dir != "" {
+		if err := loadDir(loaded, os.DirFS(cfg.Dir), "."); err != nil {
+			return fmt.Errorf("load locales: %w", err)
+		}
+	}
+	mu.Lock()
+	catalogs = loaded
+	mu.Unlock()
+	return nil
+}
+
+// Match picks the supported language preferred by the Accept-Language header value.
+// Region specific tags fall back to the base language, e.g. de-AT to de. DefaultLanguage is returned if nothing matches.
+func Match(acceptLanguage string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, tag := range parseAcceptLanguage(acceptLanguage) {
+		for candidate := tag; ; {
+			if _, ok := catalogs[candidate]; ok {
+				return candidate
+			}
+			if candidate == DefaultLanguage {
+				return candidate
+			}
+			i := strings.LastIndex(candidate, "-")
+			if i < 0 {
+				break
+			}
+			candidate = candidate[:i]
+		}
+	}
+	return DefaultLanguage
+}
+
+// Translate returns the message of code in lang with params substituted.
+// Codes missing in a region specific catalog are looked up in the base language one,
+// fallback is returned as is for DefaultLanguage and codes missing in the catalogs.
+func Translate(lang, code string, params map[string]interface{}, fallback string) string {
+	mu.RLock()
+	msg, ok := catalogs[lang][code]
+	for !ok && strings.Contains(lang, "-") {
+		lang = lang[:strings.LastIndex(lang, "-")]
+		msg, ok = catalogs[lang][code]
+	}
+	mu.RUnlock()
+	if !ok {
+		return fallback
+	}
+	for name, value := range params {
+		msg = strings.ReplaceAll(msg, "{"+name+"}", fmt.Sprint(value))
+	}
+	return msg
+}
+
+type acceptedTag struct {
+	tag string
+	q   float64
+}
+
+// parseAcceptLanguage returns language tags ordered by preference, lower cased.
+func parseAcceptLanguage(header string) []string {
+	var accepted []acceptedTag
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			accepted = append(accepted, acceptedTag{tag, q})
+		}
+	}
+	sort.SliceStable(accepted, func(i, j int) bool { return accepted[i].q > accepted[j].q })
+	tags := make([]string, len(accepted))
+	for i, a := range accepted {
+		tags[i] = a.tag
+	}
+	return tags
+}
+
+func mustLoadBuiltin() map[string]map[string]string {
+	loaded := map[string]map[string]string{}
+	if err := loadDir(loaded, builtin, "locales"); err != nil {
+		panic(err)
+	}
+	return loaded
+}
+
+func loadDir(dst map[string]map[string]string, fsys fs.FS, dir string) error {
+	files, err := fs.Glob(fsys, path.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return err
+		}
+		messages := map[string]string{}
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		lang := strings.ToLower(strings.TrimSuffix(path.Base(file), ".json"))
+		if dst[lang] == nil {
+			dst[lang] = map[string]string{}
+		}
+		for code, msg := range messages {
+			dst[lang][code] = msg
+		}
+	}
+	return nil
+}
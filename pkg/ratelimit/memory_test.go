@@ -0,0 +1,125 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// clock is a manually advanced time source of the memory store.
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time {
+	return c.t
+}
+
+func (c *clock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+func newTestMemoryStore() (*MemoryStore, *clock) {
+	c := &clock{t: time.Date(2022, 10, 14, 12, 0, 0, 0, time.UTC)}
+	s := NewMemoryStore()
+	s.now = c.now
+	return s, c
+}
+
+func TestMemoryStoreBurst(t *testing.T) {
+	s, _ := newTestMemoryStore()
+	limit := PerMinute(60, 3)
+
+	for i := 0; i < 3; i++ {
+		res, err := s.Take(context.Background(), "k", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Allowed {
+			t.Fatalf("take %d: denied within the burst", i+1)
+		}
+		if res.Remaining != 2-i {
+			t.Errorf("take %d: remaining %d, want %d", i+1, res.Remaining, 2-i)
+		}
+	}
+	res, err := s.Take(context.Background(), "k", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Allowed {
+		t.Fatal("allowed above the burst")
+	}
+	if res.RetryAfter != time.Second {
+		t.Errorf("retry after %v, want 1s", res.RetryAfter)
+	}
+	if res.ResetAfter != 3*time.Second {
+		t.Errorf("reset after %v, want 3s", res.ResetAfter)
+	}
+}
+
+func TestMemoryStoreRefill(t *testing.T) {
+	s, c := newTestMemoryStore()
+	limit := PerMinute(60, 2)
+
+	for i := 0; i < 2; i++ {
+		if res, _ := s.Take(context.Background(), "k", limit); !res.Allowed {
+			t.Fatalf("take %d: denied within the burst", i+1)
+		}
+	}
+	c.advance(500 * time.Millisecond)
+	if res, _ := s.Take(context.Background(), "k", limit); res.Allowed {
+		t.Fatal("allowed before a token is refilled")
+	}
+	c.advance(500 * time.Millisecond)
+	if res, _ := s.Take(context.Background(), "k", limit); !res.Allowed {
+		t.Fatal("denied after a token is refilled")
+	}
+	// the bucket is never refilled above the burst
+	c.advance(time.Hour)
+	for i := 0; i < 2; i++ {
+		if res, _ := s.Take(context.Background(), "k", limit); !res.Allowed {
+			t.Fatalf("take %d after idle: denied within the burst", i+1)
+		}
+	}
+	if res, _ := s.Take(context.Background(), "k", limit); res.Allowed {
+		t.Fatal("allowed above the burst after idle")
+	}
+}
+
+func TestMemoryStoreKeys(t *testing.T) {
+	s, _ := newTestMemoryStore()
+	limit := PerMinute(1, 1)
+
+	if res, _ := s.Take(context.Background(), "a", limit); !res.Allowed {
+		t.Fatal("first take of a denied")
+	}
+	if res, _ := s.Take(context.Background(), "a", limit); res.Allowed {
+		t.Fatal("second take of a allowed")
+	}
+	if res, _ := s.Take(context.Background(), "b", limit); !res.Allowed {
+		t.Fatal("buckets of different keys are shared")
+	}
+}
+
+func TestMemoryStoreCleanup(t *testing.T) {
+	s, c := newTestMemoryStore()
+	limit := PerMinute(60, 1)
+
+	s.Take(context.Background(), "old", limit)
+	c.advance(2 * time.Hour)
+	s.Take(context.Background(), "new", limit)
+
+	removed, err := s.Cleanup(context.Background(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Errorf("removed %d buckets, want 1", removed)
+	}
+	if _, ok := s.buckets["old"]; ok {
+		t.Error("idle bucket is kept")
+	}
+	if _, ok := s.buckets["new"]; !ok {
+		t.Error("recent bucket is removed")
+	}
+}
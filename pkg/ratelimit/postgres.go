@@ -0,0 +1,52 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// PostgresStore keeps buckets in the rate_limit_buckets table, so the limits are shared by all replicas.
+// Refill and take are done in a single upsert to stay atomic under concurrent requests.
+type PostgresStore struct {
+	DB *sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{DB: db}
+}
+
+// refillExpr is the amount of tokens in the existing bucket after the refill, $2 is burst, $3 is rate.
+const refillExpr = `LEAST($2::float8, b.tokens + GREATEST(0, EXTRACT(EPOCH FROM (now() - b.updated_at))::float8) * $3::float8)`
+
+var takeQuery = strings.ReplaceAll(
+	`INSERT INTO rate_limit_buckets AS b (key, tokens, allowed, updated_at) VALUES ($1, $2::float8 - 1, true, now()) `+
+		`ON CONFLICT (key) DO UPDATE SET `+
+		`tokens = CASE WHEN {refill} >= 1 THEN {refill} - 1 ELSE {refill} END, `+
+		`allowed = {refill} >= 1, `+
+		`updated_at = now() `+
+		`RETURNING b.tokens, b.allowed`,
+	"{refill}",
+	refillExpr,
+)
+
+func (s *PostgresStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	var tokens float64
+	var allowed bool
+	err := s.DB.QueryRowContext(ctx, takeQuery, key, float64(limit.Burst), limit.Rate).Scan(&tokens, &allowed)
+	if err != nil {
+		return Result{}, err
+	}
+	return newResult(limit, tokens, allowed), nil
+}
+
+// Cleanup deletes buckets untouched for longer than idle.
+func (s *PostgresStore) Cleanup(ctx context.Context, idle time.Duration) (int, error) {
+	result, err := s.DB.ExecContext(ctx, `DELETE FROM rate_limit_buckets WHERE updated_at < $1`, time.Now().Add(-idle))
+	if err != nil {
+		return 0, err
+	}
+	removed, err := result.RowsAffected()
+	return int(removed), err
+}
@@ -0,0 +1,122 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/Ollub/user_service/pkg/log"
+)
+
+type Config struct {
+	// Backend is either "memory" or "postgres". Memory buckets are not shared between service replicas.
+	Backend string `envconfig:"RATE_LIMIT_BACKEND" default:"memory"`
+
+	SignupPerMinute float64 `envconfig:"RATE_LIMIT_SIGNUP_PER_MINUTE" default:"5"`
+	SignupBurst     int     `envconfig:"RATE_LIMIT_SIGNUP_BURST" default:"5"`
+	LoginPerMinute  float64 `envconfig:"RATE_LIMIT_LOGIN_PER_MINUTE" default:"20"`
+	LoginBurst      int     `envconfig:"RATE_LIMIT_LOGIN_BURST" default:"10"`
+	APIPerMinute    float64 `envconfig:"RATE_LIMIT_API_PER_MINUTE" default:"600"`
+	APIBurst        int     `envconfig:"RATE_LIMIT_API_BURST" default:"100"`
+}
+
+// Validate rejects an unknown backend and the limits Limit.Validate rejects.
+func (c *Config) Validate() error {
+	if c.Backend != "memory" && c.Backend != "postgres" {
+		return fmt.Errorf("unknown rate limit backend %q", c.Backend)
+	}
+	limits := []struct {
+		name  string
+		limit Limit
+	}{
+		{"SIGNUP", PerMinute(c.SignupPerMinute, c.SignupBurst)},
+		{"LOGIN", PerMinute(c.LoginPerMinute, c.LoginBurst)},
+		{"API", PerMinute(c.APIPerMinute, c.APIBurst)},
+	}
+	for _, l := range limits {
+		if err := l.limit.Validate(); err != nil {
+			return fmt.Errorf("RATE_LIMIT_%s: %w", l.name, err)
+		}
+	}
+	return nil
+}
+
+// Limit of a token bucket: it holds up to Burst tokens and is refilled with Rate tokens per second.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+func PerMinute(n float64, burst int) Limit {
+	return Limit{Rate: n / 60, Burst: burst}
+}
+
+// Validate rejects the limits which never refill or hold no token, the retry time of them is infinite.
+func (l Limit) Validate() error {
+	if !(l.Rate > 0) || math.IsInf(l.Rate, 0) {
+		return fmt.Errorf("rate %v should be a positive number", l.Rate)
+	}
+	if l.Burst < 1 {
+		return fmt.Errorf("burst %d should be positive", l.Burst)
+	}
+	return nil
+}
+
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is the time until the next token, set only for denied requests
+	RetryAfter time.Duration
+	// ResetAfter is the time until the bucket is full again
+	ResetAfter time.Duration
+}
+
+// Store keeps token buckets. Take removes one token from the bucket of the key if there is any.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// Cleaner is implemented by stores which need stale buckets to be removed.
+type Cleaner interface {
+	Cleanup(ctx context.Context, idle time.Duration) (int, error)
+}
+
+// RunCleanup removes buckets idle for longer than idle every interval until ctx is cancelled.
+func RunCleanup(ctx context.Context, c Cleaner, interval, idle time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n, err := c.Cleanup(ctx, idle); err != nil {
+				log.Error("Error while removing stale rate limit buckets", log.Fields{"error": err.Error()})
+			} else if n > 0 {
+				log.Debug("Stale rate limit buckets removed", log.Fields{"count": n})
+			}
+		}
+	}
+}
+
+func newResult(limit Limit, tokens float64, allowed bool) Result {
+	res := Result{
+		Allowed:    allowed,
+		Limit:      limit.Burst,
+		Remaining:  int(math.Floor(tokens)),
+		ResetAfter: secondsToDuration((float64(limit.Burst) - tokens) / limit.Rate),
+	}
+	if !allowed {
+		res.RetryAfter = secondsToDuration((1 - tokens) / limit.Rate)
+	}
+	return res
+}
+
+func secondsToDuration(s float64) time.Duration {
+	if s <= 0 {
+		return 0
+	}
+	return time.Duration(s * float64(time.Second))
+}
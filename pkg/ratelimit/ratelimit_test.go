@@ -0,0 +1,42 @@
+package ratelimit
+
+import (
+	"math"
+	"testing"
+)
+
+func TestConfigValidate(t *testing.T) {
+	valid := func() *Config {
+		return &Config{
+			Backend:         "memory",
+			SignupPerMinute: 5, SignupBurst: 5,
+			LoginPerMinute: 20, LoginBurst: 10,
+			APIPerMinute: 600, APIBurst: 100,
+		}
+	}
+	if err := valid().Validate(); err != nil {
+		t.Fatalf("default config: %v", err)
+	}
+
+	cases := []struct {
+		name   string
+		modify func(c *Config)
+	}{
+		{"unknown backend", func(c *Config) { c.Backend = "redis" }},
+		{"zero rate", func(c *Config) { c.SignupPerMinute = 0 }},
+		{"negative rate", func(c *Config) { c.LoginPerMinute = -1 }},
+		{"nan rate", func(c *Config) { c.APIPerMinute = math.NaN() }},
+		{"infinite rate", func(c *Config) { c.APIPerMinute = math.Inf(1) }},
+		{"zero burst", func(c *Config) { c.LoginBurst = 0 }},
+		{"negative burst", func(c *Config) { c.APIBurst = -1 }},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := valid()
+			c.modify(cfg)
+			if err := cfg.Validate(); err == nil {
+				t.Error("invalid config accepted")
+			}
+		})
+	}
+}
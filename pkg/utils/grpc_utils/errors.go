@@ -0,0 +1,62 @@
+package grpc_utils
+
+import (
+	"context"
+
+	"github.com/Ollub/user_service/pkg/i18n"
+	"github.com/Ollub/user_service/pkg/utils/http_utils"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// ErrorDomain is the domain of errdetails.ErrorInfo, its reason holds the same stable codes as the HTTP problems.
+const ErrorDomain = "user_service"
+
+// Error builds a status error with the stable code in errdetails.ErrorInfo.
+// The message is translated to the language from the `accept-language` metadata.
+func Error(ctx context.Context, c codes.Code, code, details string) error {
+	lang := language(ctx)
+	st, err := status.New(c, i18n.Translate(lang, code, nil, details)).
+		WithDetails(&errdetails.ErrorInfo{Reason: code, Domain: ErrorDomain})
+	if err != nil {
+		return status.Error(c, details)
+	}
+	return st.Err()
+}
+
+// ValidationError builds InvalidArgument status error listing all the invalid fields in errdetails.BadRequest.
+func ValidationError(ctx context.Context, errs []*http_utils.FieldError) error {
+	lang := language(ctx)
+	violations := make([]*errdetails.BadRequest_FieldViolation, len(errs))
+	for i, e := range errs {
+		violations[i] = &errdetails.BadRequest_FieldViolation{
+			Field:       e.Field,
+			Description: i18n.Translate(lang, e.Code, e.Params, e.Message),
+		}
+	}
+	// field codes go to the metadata as "field: code1,code2", BadRequest has no place for them
+	info := &errdetails.ErrorInfo{Reason: http_utils.CodeValidationFailed, Domain: ErrorDomain, Metadata: map[string]string{}}
+	for _, e := range errs {
+		if prev := info.Metadata[e.Field]; prev != "" {
+			info.Metadata[e.Field] = prev + "," + e.Code
+		} else {
+			info.Metadata[e.Field] = e.Code
+		}
+	}
+	msg := i18n.Translate(lang, http_utils.CodeValidationFailed, nil, "Request validation failed")
+	st, err := status.New(codes.InvalidArgument, msg).WithDetails(info, &errdetails.BadRequest{FieldViolations: violations})
+	if err != nil {
+		return status.Error(codes.InvalidArgument, msg)
+	}
+	return st.Err()
+}
+
+func language(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get("accept-language"); len(values) > 0 {
+		return i18n.Match(values[0])
+	}
+	return i18n.DefaultLanguage
+}
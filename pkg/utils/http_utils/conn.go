@@ -0,0 +1,28 @@
+package http_utils
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+type connKey struct{}
+
+// ConnContext is the http.Server.ConnContext hook keeping the connection in the request context,
+// so the handlers can change its deadlines.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// DisableWriteDeadline lifts the WriteTimeout of the server for the current response, it is meant
+// for the handlers streaming responses that take longer than the timeout. The server sets the
+// deadline again before the next request on the connection.
+func DisableWriteDeadline(r *http.Request) error {
+	c, ok := r.Context().Value(connKey{}).(net.Conn)
+	if !ok {
+		return errors.New("connection is not in the request context, is ConnContext set?")
+	}
+	return c.SetWriteDeadline(time.Time{})
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strconv"
 )
 
 func FromBody[T interface{}](r *http.Request) (*T, error) {
@@ -16,3 +18,38 @@ func FromBody[T interface{}](r *http.Request) (*T, error) {
 	}
 	return out, nil
 }
+
+// PageFromQuery reads `limit` and `offset` query params, missing ones are returned as 0.
+func PageFromQuery(r *http.Request) (limit, offset int, fieldErr *FieldError) {
+	query := r.URL.Query()
+	var err error
+	if v := query.Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 0 {
+			return 0, 0, NewFieldError("limit", FieldInvalid, "should be a non negative integer")
+		}
+	}
+	if v := query.Get("offset"); v != "" {
+		offset, err = strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return 0, 0, NewFieldError("offset", FieldInvalid, "should be a non negative integer")
+		}
+	}
+	return limit, offset, nil
+}
+
+// ClientBasicAuth returns the OAuth client credentials of the Basic auth, they are form encoded before
+// the base64 encoding, see RFC 6749 section 2.3.1. ok reports whether the request has the Basic auth,
+// the credentials which can't be decoded are returned empty.
+func ClientBasicAuth(r *http.Request) (id, secret string, ok bool) {
+	id, secret, ok = r.BasicAuth()
+	if !ok {
+		return "", "", false
+	}
+	id, errID := url.QueryUnescape(id)
+	secret, errSecret := url.QueryUnescape(secret)
+	if errID != nil || errSecret != nil {
+		return "", "", true
+	}
+	return id, secret, true
+}
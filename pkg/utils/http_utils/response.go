@@ -3,23 +3,96 @@ package http_utils
 import (
 	"encoding/json"
 	"net/http"
+
+	"github.com/Ollub/user_service/pkg/i18n"
 )
 
-type ErrorResp struct {
+const ProblemContentType = "application/problem+json"
+
+// Stable error codes shared by all handlers, clients may rely on them.
+const (
+	CodeInvalidPayload   = "invalid_payload"
+	CodeValidationFailed = "validation_failed"
+	CodeUnauthorized     = "unauthorized"
+	CodeForbidden        = "forbidden"
+	CodeNotFound         = "not_found"
+	CodeRateLimited      = "rate_limited"
+	CodeServiceBusy      = "service_busy"
+	CodeInternal         = "internal_error"
+)
+
+// Stable codes of field errors, domain specific ones are defined next to the validators.
+const (
+	FieldRequired = "required"
+	FieldInvalid  = "invalid"
+)
+
+// FieldError is a problem with a single input field or query param.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
 	Message string `json:"message"`
+	// Values used in the message, e.g. the minimal length
+	Params map[string]interface{} `json:"params,omitempty"`
+}
+
+func NewFieldError(field, code, message string) *FieldError {
+	return &FieldError{Field: field, Code: code, Message: message}
+}
+
+// Problem is the RFC 7807 problem details object extended with the error code and field errors.
+type Problem struct {
+	Type   string        `json:"type"`
+	Title  string        `json:"title"`
+	Status int           `json:"status"`
+	Detail string        `json:"detail,omitempty"`
+	Code   string        `json:"code"`
+	Errors []*FieldError `json:"errors,omitempty"`
 }
 
-func HttpError(w http.ResponseWriter, details string, code int) {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+// WriteProblem responds with the problem, its messages are translated to the language from the request Accept-Language.
+// The request may be nil, then messages are not translated.
+func WriteProblem(w http.ResponseWriter, r *http.Request, p *Problem) {
+	if p.Type == "" {
+		p.Type = "about:blank"
+	}
+	if p.Title == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+	if r != nil {
+		lang := i18n.Match(r.Header.Get("Accept-Language"))
+		p.Detail = i18n.Translate(lang, p.Code, nil, p.Detail)
+		for _, e := range p.Errors {
+			e.Message = i18n.Translate(lang, e.Code, e.Params, e.Message)
+		}
+		w.Header().Set("Content-Language", lang)
+		w.Header().Add("Vary", "Accept-Language")
+	}
+	w.Header().Set("Content-Type", ProblemContentType)
 	w.Header().Set("X-Content-Type-Options", "nosniff")
-	w.WriteHeader(code)
-	json.NewEncoder(w).Encode(ErrorResp{details})
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// HttpError responds with a problem, code is a stable identifier of the error and details is a human readable text.
+func HttpError(w http.ResponseWriter, r *http.Request, code, details string, status int) {
+	WriteProblem(w, r, &Problem{Status: status, Detail: details, Code: code})
+}
+
+// ValidationError responds with a problem listing all the invalid fields.
+func ValidationError(w http.ResponseWriter, r *http.Request, errs []*FieldError, status int) {
+	WriteProblem(w, r, &Problem{
+		Status: status,
+		Detail: "Request validation failed",
+		Code:   CodeValidationFailed,
+		Errors: errs,
+	})
 }
 
 func JsonResp(w http.ResponseWriter, v interface{}, code int) {
 	resp, err := json.Marshal(v)
 	if err != nil {
-		http.Error(w, "Marshaling error", http.StatusInternalServerError)
+		HttpError(w, nil, CodeInternal, "Marshaling error", http.StatusInternalServerError)
 		return
 	}
 	w.WriteHeader(code)
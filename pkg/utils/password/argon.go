@@ -12,11 +12,11 @@ import (
 )
 
 type ArgonParams struct {
-	Memory      uint32
-	Iterations  uint32
-	Parallelism uint8
-	SaltLength  uint32
-	KeyLength   uint32
+	Memory      uint32 `envconfig:"ARGON_MEMORY_KB" default:"65536"`
+	Iterations  uint32 `envconfig:"ARGON_ITERATIONS" default:"3"`
+	Parallelism uint8  `envconfig:"ARGON_PARALLELISM" default:"1"`
+	SaltLength  uint32 `envconfig:"ARGON_SALT_LENGTH" default:"16"`
+	KeyLength   uint32 `envconfig:"ARGON_KEY_LENGTH" default:"32"`
 }
 
 func GenerateHash(password string, p *ArgonParams) (encodedHash string, err error) {
@@ -25,14 +25,25 @@ func GenerateHash(password string, p *ArgonParams) (encodedHash string, err erro
 		return "", err
 	}
 
-	hash := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	pepperID := currentPepperID()
+	peppered, err := applyPepper(password, pepperID)
+	if err != nil {
+		return "", err
+	}
+
+	hash := argon2.IDKey(peppered, salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
 
 	// Base64 encode the salt and hashed password.
 	b64Salt := base64.RawStdEncoding.EncodeToString(salt)
 	b64Hash := base64.RawStdEncoding.EncodeToString(hash)
 
-	// Return a string using the standard encoded hash representation.
-	encodedHash = fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.Memory, p.Iterations, p.Parallelism, b64Salt, b64Hash)
+	// Return a string using the standard encoded hash representation,
+	// the pepper id is added to the parameters if the password was peppered.
+	params := fmt.Sprintf("m=%d,t=%d,p=%d", p.Memory, p.Iterations, p.Parallelism)
+	if pepperID != "" {
+		params += ",pid=" + pepperID
+	}
+	encodedHash = fmt.Sprintf("$argon2id$v=%d$%s$%s$%s", argon2.Version, params, b64Salt, b64Hash)
 
 	return encodedHash, nil
 }
@@ -47,16 +58,39 @@ func generateRandomBytes(n uint32) ([]byte, error) {
 	return b, nil
 }
 
-func VerifyPassword(password, encodedHash string) (match bool, err error) {
+const argon2idPrefix = "$argon2id$"
+
+// Limits of the parameters of the stored hashes, above the ones the service can be reasonably configured with.
+const (
+	maxArgonMemory     = 1 << 20 // KB
+	maxArgonIterations = 32
+)
+
+func checkArgon2id(encodedHash string) error {
+	p, _, _, _, err := decodeHash(encodedHash)
+	if err != nil {
+		return err
+	}
+	if p.Memory > maxArgonMemory || p.Iterations > maxArgonIterations {
+		return fmt.Errorf("argon2id cost m=%d,t=%d is above m=%d,t=%d", p.Memory, p.Iterations, maxArgonMemory, maxArgonIterations)
+	}
+	return nil
+}
+
+func verifyArgon2id(password, encodedHash string) (match bool, err error) {
 	// Extract the parameters, salt and derived key from the encoded password
 	// hash.
-	p, salt, hash, err := decodeHash(encodedHash)
+	p, pepperID, salt, hash, err := decodeHash(encodedHash)
+	if err != nil {
+		return false, err
+	}
+	peppered, err := applyPepper(password, pepperID)
 	if err != nil {
 		return false, err
 	}
 
 	// Derive the key from the other password using the same parameters.
-	otherHash := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	otherHash := argon2.IDKey(peppered, salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
 
 	// Check that the contents of the hashed passwords are identical. Note
 	// that we are using the subtle.ConstantTimeCompare() function for this
@@ -67,38 +101,68 @@ func VerifyPassword(password, encodedHash string) (match bool, err error) {
 	return false, nil
 }
 
-func decodeHash(encodedHash string) (p *ArgonParams, salt, hash []byte, err error) {
+// NeedsRehash reports whether the hash was made with a legacy algorithm, with not the current pepper
+// or with weaker parameters than p. Hashes which can not be decoded are reported as not needing rehash.
+func NeedsRehash(encodedHash string, p *ArgonParams) bool {
+	if !strings.HasPrefix(encodedHash, argon2idPrefix) {
+		return IsSupported(encodedHash)
+	}
+	hp, pepperID, _, _, err := decodeHash(encodedHash)
+	if err != nil {
+		return false
+	}
+	return pepperID != currentPepperID() ||
+		hp.Memory < p.Memory ||
+		hp.Iterations < p.Iterations ||
+		hp.Parallelism < p.Parallelism ||
+		hp.SaltLength < p.SaltLength ||
+		hp.KeyLength < p.KeyLength
+}
+
+func decodeHash(encodedHash string) (p *ArgonParams, pepperID string, salt, hash []byte, err error) {
 	vals := strings.Split(encodedHash, "$")
 	if len(vals) != 6 {
-		return nil, nil, nil, errors.New("the encoded hash is not in the correct format")
+		return nil, "", nil, nil, errors.New("the encoded hash is not in the correct format")
 	}
 
 	var version int
 	_, err = fmt.Sscanf(vals[2], "v=%d", &version)
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, "", nil, nil, err
 	}
 	if version != argon2.Version {
-		return nil, nil, nil, errors.New("incompatible version of argon2")
+		return nil, "", nil, nil, errors.New("incompatible version of argon2")
 	}
 
+	// m=65536,t=3,p=1 optionally followed by ,pid=<pepper id>
+	params := strings.SplitN(vals[3], ",pid=", 2)
+	if len(params) == 2 {
+		pepperID = params[1]
+	}
 	p = &ArgonParams{}
-	_, err = fmt.Sscanf(vals[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism)
+	_, err = fmt.Sscanf(params[0], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism)
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, "", nil, nil, err
+	}
+	// argon2.IDKey panics with zero iterations or parallelism, the spec requires 8 KB of memory per lane
+	if p.Iterations < 1 || p.Parallelism < 1 || p.Memory < 8*uint32(p.Parallelism) {
+		return nil, "", nil, nil, fmt.Errorf("invalid argon2id cost m=%d,t=%d,p=%d", p.Memory, p.Iterations, p.Parallelism)
 	}
 
 	salt, err = base64.RawStdEncoding.Strict().DecodeString(vals[4])
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, "", nil, nil, err
 	}
 	p.SaltLength = uint32(len(salt))
 
 	hash, err = base64.RawStdEncoding.Strict().DecodeString(vals[5])
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, "", nil, nil, err
 	}
 	p.KeyLength = uint32(len(hash))
+	if err = checkSaltAndDigest(salt, hash); err != nil {
+		return nil, "", nil, nil, err
+	}
 
-	return p, salt, hash, nil
+	return p, pepperID, salt, hash, nil
 }
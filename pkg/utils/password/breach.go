@@ -0,0 +1,172 @@
+package password
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// BreachChecker tells whether the password is known from data breaches.
+type BreachChecker interface {
+	IsBreached(password string) (bool, error)
+}
+
+type BreachCfg struct {
+	// Path to the "pwned passwords" dataset, either a directory of range files
+	// or a single file ordered by hash. Empty disables the check.
+	Path string `envconfig:"PWNED_PASSWORDS_PATH"`
+	// Passwords seen in breaches fewer times are accepted
+	MinCount int `envconfig:"PWNED_PASSWORDS_MIN_COUNT" default:"1"`
+}
+
+// NewBreachChecker opens the dataset from cfg, it returns nil if the check is disabled.
+//
+// Two layouts of the HaveIBeenPwned dataset are supported, both are looked up without loading them in memory:
+//   - a directory of range files named by the first 5 hex chars of SHA-1 (00000.txt ... FFFFF.txt)
+//     holding "<35 hex chars suffix>:<count>" lines, as made by the PwnedPasswordsDownloader
+//   - a single file of "<40 hex chars SHA-1>:<count>" lines ordered by hash, searched with binary search
+func NewBreachChecker(cfg *BreachCfg) (BreachChecker, error) {
+	if cfg.Path == "" {
+		return nil, nil
+	}
+	info, err := os.Stat(cfg.Path)
+	if err != nil {
+		return nil, fmt.Errorf("open pwned passwords: %w", err)
+	}
+	if info.IsDir() {
+		return &pwnedRangeDir{dir: cfg.Path, minCount: cfg.MinCount}, nil
+	}
+	return &pwnedOrderedFile{path: cfg.Path, size: info.Size(), minCount: cfg.MinCount}, nil
+}
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// parseLine splits "<hash>:<count>" line.
+func parseLine(line []byte) (string, int) {
+	line = bytes.TrimSpace(line)
+	sep := bytes.IndexByte(line, ':')
+	if sep < 0 {
+		return strings.ToUpper(string(line)), 1
+	}
+	count, err := strconv.Atoi(string(line[sep+1:]))
+	if err != nil {
+		count = 1
+	}
+	return strings.ToUpper(string(line[:sep])), count
+}
+
+type pwnedRangeDir struct {
+	dir      string
+	minCount int
+}
+
+func (p *pwnedRangeDir) IsBreached(password string) (bool, error) {
+	hash := sha1Hex(password)
+	prefix, suffix := hash[:5], hash[5:]
+
+	f, err := os.Open(filepath.Join(p.dir, prefix+".txt"))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if h, count := parseLine(scanner.Bytes()); h == suffix {
+			return count >= p.minCount, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+type pwnedOrderedFile struct {
+	path     string
+	size     int64
+	minCount int
+}
+
+// maxLineLength is more than enough for "<40 hex>:<count>\r\n".
+const maxLineLength = 128
+
+func (p *pwnedOrderedFile) IsBreached(password string) (bool, error) {
+	hash := sha1Hex(password)
+
+	f, err := os.Open(p.path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	// Binary search over byte offsets: every probe reads the first full line after the offset.
+	lo, hi := int64(0), p.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		line, next, err := lineAfter(f, mid)
+		if err != nil {
+			return false, err
+		}
+		if line == nil {
+			hi = mid
+			continue
+		}
+		h, count := parseLine(line)
+		switch {
+		case h == hash:
+			return count >= p.minCount, nil
+		case h < hash:
+			lo = next
+		default:
+			hi = mid
+		}
+	}
+	return false, nil
+}
+
+// lineAfter returns the first line starting after offset and the offset following it.
+// Offset 0 is treated as the start of the first line.
+func lineAfter(f *os.File, offset int64) ([]byte, int64, error) {
+	if offset == 0 {
+		return lineAt(f, 0)
+	}
+	buf := make([]byte, 2*maxLineLength)
+	n, err := f.ReadAt(buf, offset-1)
+	if err != nil && err != io.EOF {
+		return nil, 0, err
+	}
+	buf = buf[:n]
+	nl := bytes.IndexByte(buf, '\n')
+	if nl < 0 || nl+1 >= len(buf) {
+		return nil, 0, nil
+	}
+	start := offset - 1 + int64(nl) + 1
+	return lineAt(f, start)
+}
+
+func lineAt(f *os.File, offset int64) ([]byte, int64, error) {
+	buf := make([]byte, maxLineLength)
+	n, err := f.ReadAt(buf, offset)
+	if err != nil && err != io.EOF {
+		return nil, 0, err
+	}
+	buf = buf[:n]
+	if len(buf) == 0 {
+		return nil, 0, nil
+	}
+	if nl := bytes.IndexByte(buf, '\n'); nl >= 0 {
+		return buf[:nl], offset + int64(nl) + 1, nil
+	}
+	return buf, offset + int64(len(buf)), nil
+}
@@ -0,0 +1,93 @@
+package password
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The fixtures hold the passwords breached00 ... breached19 seen i+1 times, made by:
+// sha1 of every password, "<hash>:<count>\r\n" lines ordered by hash, split by the 5 chars prefix for the ranges.
+const (
+	// the lowest and the highest hash of the fixtures
+	firstBreached = "breached10" // 01B7BABC..., seen 11 times
+	lastBreached  = "breached07" // F1F6B5F2..., seen 8 times
+)
+
+func breachedPasswords() []string {
+	items := make([]string, 20)
+	for i := range items {
+		items[i] = fmt.Sprintf("breached%02d", i)
+	}
+	return items
+}
+
+func openChecker(t *testing.T, path string, minCount int) BreachChecker {
+	c, err := NewBreachChecker(&BreachCfg{Path: path, MinCount: minCount})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func assertBreached(t *testing.T, c BreachChecker, password string, want bool) {
+	t.Helper()
+	got, err := c.IsBreached(password)
+	if err != nil {
+		t.Fatalf("%s: %v", password, err)
+	}
+	if got != want {
+		t.Errorf("%s: breached %v, want %v", password, got, want)
+	}
+}
+
+func testBreachChecker(t *testing.T, path string) {
+	c := openChecker(t, path, 1)
+	assertBreached(t, c, firstBreached, true)
+	assertBreached(t, c, lastBreached, true)
+	for _, p := range breachedPasswords() {
+		assertBreached(t, c, p, true)
+	}
+	for _, p := range []string{"", "breached20", "not breached", "0", strings.Repeat("z", 200)} {
+		assertBreached(t, c, p, false)
+	}
+
+	// breached10 is seen 11 times, breached11 is seen 12 times
+	c = openChecker(t, path, 12)
+	assertBreached(t, c, "breached10", false)
+	assertBreached(t, c, "breached11", true)
+}
+
+func TestPwnedOrderedFile(t *testing.T) {
+	testBreachChecker(t, filepath.Join("testdata", "pwned-ordered.txt"))
+}
+
+// The search doesn't depend on the line endings and the trailing newline.
+func TestPwnedOrderedFileLF(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "pwned-ordered.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lf := strings.TrimSuffix(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	path := filepath.Join(t.TempDir(), "pwned.txt")
+	if err := os.WriteFile(path, []byte(lf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	testBreachChecker(t, path)
+}
+
+func TestPwnedOrderedFileSingleLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pwned.txt")
+	if err := os.WriteFile(path, []byte(sha1Hex(firstBreached)+":1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c := openChecker(t, path, 1)
+	assertBreached(t, c, firstBreached, true)
+	assertBreached(t, c, lastBreached, false)
+}
+
+func TestPwnedRangeDir(t *testing.T) {
+	testBreachChecker(t, filepath.Join("testdata", "pwned-ranges"))
+}
@@ -0,0 +1,195 @@
+package password
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Limits of the parameters stored in the imported hashes, every login pays for them.
+// They are well above the defaults of the libraries the hashes come from.
+const (
+	maxBcryptCost       = 16
+	maxPBKDF2Iterations = 2000000
+	// scrypt uses 128*N*r bytes of memory
+	maxScryptMemory   = 256 << 20
+	maxScryptParallel = 16
+	// an empty digest matches any password, the key derivation yields an empty key for it
+	minDigestLength = 16
+)
+
+// passlibEncoding is the "adapted base64" used by passlib: standard alphabet with '.' instead of '+', no padding.
+var passlibEncoding = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789./").WithPadding(base64.NoPadding)
+
+// checkSaltAndDigest rejects the hashes which would match any password or are unsalted.
+func checkSaltAndDigest(salt, hash []byte) error {
+	if len(salt) == 0 {
+		return errors.New("empty salt")
+	}
+	if len(hash) < minDigestLength {
+		return fmt.Errorf("digest of %d bytes is shorter than %d", len(hash), minDigestLength)
+	}
+	return nil
+}
+
+func checkBcrypt(encodedHash string) error {
+	cost, err := bcrypt.Cost([]byte(encodedHash))
+	if err != nil {
+		return err
+	}
+	if cost > maxBcryptCost {
+		return fmt.Errorf("bcrypt cost %d is above %d", cost, maxBcryptCost)
+	}
+	return nil
+}
+
+func verifyBcrypt(password, encodedHash string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encodedHash), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func pbkdf2Iterations(raw string) (int, error) {
+	iterations, err := strconv.Atoi(raw)
+	if err != nil || iterations < 1 {
+		return 0, errors.New("invalid pbkdf2 iterations")
+	}
+	if iterations > maxPBKDF2Iterations {
+		return 0, fmt.Errorf("pbkdf2 iterations %d are above %d", iterations, maxPBKDF2Iterations)
+	}
+	return iterations, nil
+}
+
+// decodePassLibPBKDF2 decodes passlib format: $pbkdf2-sha256$<rounds>$<salt>$<hash>
+func decodePassLibPBKDF2(encodedHash string) (rounds int, salt, hash []byte, err error) {
+	vals := strings.Split(encodedHash, "$")
+	if len(vals) != 5 {
+		return 0, nil, nil, errors.New("the encoded pbkdf2 hash is not in the correct format")
+	}
+	if rounds, err = pbkdf2Iterations(vals[2]); err != nil {
+		return 0, nil, nil, err
+	}
+	if salt, err = passlibEncoding.DecodeString(vals[3]); err != nil {
+		return 0, nil, nil, err
+	}
+	if hash, err = passlibEncoding.DecodeString(vals[4]); err != nil {
+		return 0, nil, nil, err
+	}
+	if err = checkSaltAndDigest(salt, hash); err != nil {
+		return 0, nil, nil, err
+	}
+	return rounds, salt, hash, nil
+}
+
+func checkPassLibPBKDF2(encodedHash string) error {
+	_, _, _, err := decodePassLibPBKDF2(encodedHash)
+	return err
+}
+
+func verifyPassLibPBKDF2(password, encodedHash string) (bool, error) {
+	rounds, salt, hash, err := decodePassLibPBKDF2(encodedHash)
+	if err != nil {
+		return false, err
+	}
+	otherHash := pbkdf2.Key([]byte(password), salt, rounds, len(hash), sha256.New)
+	return subtle.ConstantTimeCompare(hash, otherHash) == 1, nil
+}
+
+// decodeDjangoPBKDF2 decodes django format: pbkdf2_sha256$<iterations>$<salt>$<base64 hash>
+func decodeDjangoPBKDF2(encodedHash string) (iterations int, salt, hash []byte, err error) {
+	vals := strings.Split(encodedHash, "$")
+	if len(vals) != 4 {
+		return 0, nil, nil, errors.New("the encoded pbkdf2 hash is not in the correct format")
+	}
+	if iterations, err = pbkdf2Iterations(vals[1]); err != nil {
+		return 0, nil, nil, err
+	}
+	if hash, err = base64.StdEncoding.DecodeString(vals[3]); err != nil {
+		return 0, nil, nil, err
+	}
+	salt = []byte(vals[2])
+	if err = checkSaltAndDigest(salt, hash); err != nil {
+		return 0, nil, nil, err
+	}
+	return iterations, salt, hash, nil
+}
+
+func checkDjangoPBKDF2(encodedHash string) error {
+	_, _, _, err := decodeDjangoPBKDF2(encodedHash)
+	return err
+}
+
+func verifyDjangoPBKDF2(password, encodedHash string) (bool, error) {
+	iterations, salt, hash, err := decodeDjangoPBKDF2(encodedHash)
+	if err != nil {
+		return false, err
+	}
+	otherHash := pbkdf2.Key([]byte(password), salt, iterations, len(hash), sha256.New)
+	return subtle.ConstantTimeCompare(hash, otherHash) == 1, nil
+}
+
+type scryptParams struct {
+	n, r, p int
+}
+
+// decodePassLibScrypt decodes passlib format: $scrypt$ln=<log2 N>,r=<r>,p=<p>$<salt>$<hash>
+func decodePassLibScrypt(encodedHash string) (params scryptParams, salt, hash []byte, err error) {
+	vals := strings.Split(encodedHash, "$")
+	if len(vals) != 5 {
+		return params, nil, nil, errors.New("the encoded scrypt hash is not in the correct format")
+	}
+	var ln int
+	if _, err = fmt.Sscanf(vals[2], "ln=%d,r=%d,p=%d", &ln, &params.r, &params.p); err != nil {
+		return params, nil, nil, err
+	}
+	if ln < 1 || ln > 30 || params.r < 1 || params.p < 1 {
+		return params, nil, nil, errors.New("invalid scrypt cost")
+	}
+	params.n = 1 << ln
+	if params.r > maxScryptMemory/(128*params.n) || params.p > maxScryptParallel {
+		return params, nil, nil, fmt.Errorf(
+			"scrypt cost ln=%d,r=%d,p=%d is above %d MB of memory or parallelism %d",
+			ln, params.r, params.p, maxScryptMemory>>20, maxScryptParallel,
+		)
+	}
+	if salt, err = passlibEncoding.DecodeString(vals[3]); err != nil {
+		return params, nil, nil, err
+	}
+	if hash, err = passlibEncoding.DecodeString(vals[4]); err != nil {
+		return params, nil, nil, err
+	}
+	if err = checkSaltAndDigest(salt, hash); err != nil {
+		return params, nil, nil, err
+	}
+	return params, salt, hash, nil
+}
+
+func checkPassLibScrypt(encodedHash string) error {
+	_, _, _, err := decodePassLibScrypt(encodedHash)
+	return err
+}
+
+func verifyPassLibScrypt(password, encodedHash string) (bool, error) {
+	params, salt, hash, err := decodePassLibScrypt(encodedHash)
+	if err != nil {
+		return false, err
+	}
+	otherHash, err := scrypt.Key([]byte(password), salt, params.n, params.r, params.p, len(hash))
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(hash, otherHash) == 1, nil
+}
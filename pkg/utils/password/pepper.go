@@ -0,0 +1,73 @@
+package password
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+var UnknownPepperError = errors.New("password hash made with unknown pepper")
+
+type PepperCfg struct {
+	// Secrets by pepper id, e.g. "1:old-secret,2:new-secret". Keep them outside the database.
+	Secrets map[string]string `envconfig:"PASSWORD_PEPPERS"`
+	// Id of the pepper used for new hashes, empty disables peppering
+	CurrentID string `envconfig:"PASSWORD_PEPPER_ID"`
+}
+
+var peppers = struct {
+	sync.RWMutex
+	current string
+	secrets map[string][]byte
+}{}
+
+// SetupPeppers configures peppers applied to the password before argon2id.
+// The id of the pepper is stored in the hash, so old peppers have to be kept
+// until all the hashes made with them are upgraded on login.
+func SetupPeppers(cfg *PepperCfg) error {
+	secrets := make(map[string][]byte, len(cfg.Secrets))
+	for id, secret := range cfg.Secrets {
+		if id == "" || strings.ContainsAny(id, ",$=") {
+			return fmt.Errorf("invalid pepper id %q", id)
+		}
+		if secret == "" {
+			return fmt.Errorf("empty secret of pepper %q", id)
+		}
+		secrets[id] = []byte(secret)
+	}
+	if _, ok := secrets[cfg.CurrentID]; cfg.CurrentID != "" && !ok {
+		return fmt.Errorf("no secret for the current pepper %q", cfg.CurrentID)
+	}
+
+	peppers.Lock()
+	defer peppers.Unlock()
+	peppers.current = cfg.CurrentID
+	peppers.secrets = secrets
+	return nil
+}
+
+func currentPepperID() string {
+	peppers.RLock()
+	defer peppers.RUnlock()
+	return peppers.current
+}
+
+// applyPepper returns HMAC-SHA256 of the password keyed with the pepper,
+// or the password itself if pepperID is empty.
+func applyPepper(password, pepperID string) ([]byte, error) {
+	if pepperID == "" {
+		return []byte(password), nil
+	}
+	peppers.RLock()
+	secret, ok := peppers.secrets[pepperID]
+	peppers.RUnlock()
+	if !ok {
+		return nil, UnknownPepperError
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(password))
+	return mac.Sum(nil), nil
+}
@@ -0,0 +1,162 @@
+package password
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Ollub/user_service/pkg/log"
+)
+
+// Stable codes of password policy violations, clients may rely on them.
+const (
+	CodeTooShort        = "password_too_short"
+	CodeTooLong         = "password_too_long"
+	CodeMissingLower    = "password_missing_lowercase"
+	CodeMissingUpper    = "password_missing_uppercase"
+	CodeMissingDigit    = "password_missing_digit"
+	CodeMissingSpecial  = "password_missing_special"
+	CodeTooWeak         = "password_too_weak"
+	CodePersonalInfo    = "password_contains_personal_info"
+	CodeBreached        = "password_breached"
+	minPersonalInfoSize = 3
+)
+
+type Policy struct {
+	// Length limits are in characters (runes), not bytes
+	MinLength      int  `envconfig:"PASSWORD_MIN_LENGTH" default:"6"`
+	MaxLength      int  `envconfig:"PASSWORD_MAX_LENGTH" default:"128"`
+	RequireLower   bool `envconfig:"PASSWORD_REQUIRE_LOWER" default:"true"`
+	RequireUpper   bool `envconfig:"PASSWORD_REQUIRE_UPPER" default:"true"`
+	RequireDigit   bool `envconfig:"PASSWORD_REQUIRE_DIGIT" default:"true"`
+	RequireSpecial bool `envconfig:"PASSWORD_REQUIRE_SPECIAL" default:"true"`
+	// Minimal Strength score from 0 (very weak) to 4 (very strong)
+	MinScore int `envconfig:"PASSWORD_MIN_SCORE" default:"1"`
+	// Reject passwords containing the user email or name
+	RejectPersonalInfo bool `envconfig:"PASSWORD_REJECT_PERSONAL_INFO" default:"true"`
+	// Reject passwords found in data breaches, nil disables the check
+	Breached BreachChecker `ignored:"true"`
+}
+
+// Violation is a single broken policy rule.
+type Violation struct {
+	Code    string
+	Message string
+	// Values used in the message, so it can be translated
+	Params map[string]interface{}
+}
+
+// Check returns all the rules the password breaks, nil if it is acceptable.
+// personalInfo are user attributes like email or name which must not be a part of the password.
+func (p *Policy) Check(password string, personalInfo ...string) []Violation {
+	var violations []Violation
+	add := func(code, msg string) {
+		violations = append(violations, Violation{Code: code, Message: msg})
+	}
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		violations = append(violations, Violation{
+			Code:    CodeTooShort,
+			Message: fmt.Sprintf("should be at least %d characters long", p.MinLength),
+			Params:  map[string]interface{}{"min": p.MinLength},
+		})
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, Violation{
+			Code:    CodeTooLong,
+			Message: fmt.Sprintf("should be at most %d characters long", p.MaxLength),
+			Params:  map[string]interface{}{"max": p.MaxLength},
+		})
+	}
+
+	classes := classify(password)
+	if p.RequireLower && !classes.lower {
+		add(CodeMissingLower, "should contain lower case letters")
+	}
+	if p.RequireUpper && !classes.upper {
+		add(CodeMissingUpper, "should contain upper case letters")
+	}
+	if p.RequireDigit && !classes.digit {
+		add(CodeMissingDigit, "should contain numbers")
+	}
+	if p.RequireSpecial && !classes.special {
+		add(CodeMissingSpecial, "should contain special characters")
+	}
+
+	if p.RejectPersonalInfo && containsPersonalInfo(password, personalInfo) {
+		add(CodePersonalInfo, "should not contain your email or name")
+	}
+	if Strength(password, personalInfo...) < p.MinScore {
+		add(CodeTooWeak, "is too easy to guess")
+	}
+	if p.Breached != nil {
+		breached, err := p.Breached.IsBreached(password)
+		if err != nil {
+			// the dataset is an extra protection, signups should not fail because of it
+			log.Error("Breached passwords check failed", log.Fields{"error": err.Error()})
+		}
+		if breached {
+			add(CodeBreached, "has appeared in a data breach, choose another one")
+		}
+	}
+	return violations
+}
+
+type charClasses struct {
+	lower, upper, digit, special bool
+}
+
+func classify(password string) charClasses {
+	var c charClasses
+	for _, r := range password {
+		switch {
+		case unicode.IsNumber(r):
+			c.digit = true
+		case unicode.IsUpper(r):
+			c.upper = true
+		// letters of scripts without case count as lower case ones
+		case unicode.IsLetter(r):
+			c.lower = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			c.special = true
+		}
+	}
+	return c
+}
+
+// personalTokens splits emails and names into parts worth checking, e.g. "john.doe@mail.com" -> john, doe, john.doe.
+func personalTokens(personalInfo []string) []string {
+	var tokens []string
+	for _, info := range personalInfo {
+		info = strings.ToLower(strings.TrimSpace(info))
+		if at := strings.Index(info, "@"); at >= 0 {
+			info = info[:at]
+		}
+		parts := strings.FieldsFunc(info, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+		})
+		tokens = append(tokens, info)
+		if len(parts) > 1 {
+			tokens = append(tokens, parts...)
+		}
+	}
+	var result []string
+	for _, t := range tokens {
+		if utf8.RuneCountInString(t) >= minPersonalInfoSize {
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+func containsPersonalInfo(password string, personalInfo []string) bool {
+	lower := strings.ToLower(password)
+	for _, token := range personalTokens(personalInfo) {
+		if strings.Contains(lower, token) {
+			return true
+		}
+	}
+	return false
+}
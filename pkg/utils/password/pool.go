@@ -0,0 +1,135 @@
+package password
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var PoolSaturatedError = errors.New("password hashing pool is saturated")
+
+// latencyBuckets are upper bounds of the hash latency histogram in milliseconds.
+var latencyBuckets = []int64{50, 100, 250, 500, 1000, 2500, 5000}
+
+// Pool bounds the number of concurrent hash computations.
+// Every argon2id computation allocates ArgonParams.Memory, so unbounded parallel
+// signups and logins can exhaust the service memory.
+type Pool struct {
+	slots        chan struct{}
+	queueTimeout time.Duration
+	metrics      *poolMetrics
+}
+
+// NewPool creates a pool running up to concurrency hash computations at once.
+// Callers wait for a free slot up to queueTimeout and get PoolSaturatedError after that.
+// Pool metrics are published with expvar under the given name.
+func NewPool(name string, concurrency int, queueTimeout time.Duration) *Pool {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	p := &Pool{
+		slots:        make(chan struct{}, concurrency),
+		queueTimeout: queueTimeout,
+		metrics:      &poolMetrics{buckets: make([]int64, len(latencyBuckets)+1)},
+	}
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return p.metrics.snapshot(len(p.slots), cap(p.slots))
+	}))
+	return p
+}
+
+func (p *Pool) GenerateHash(ctx context.Context, password string, params *ArgonParams) (hash string, err error) {
+	runErr := p.run(ctx, func() {
+		hash, err = GenerateHash(password, params)
+	})
+	if runErr != nil {
+		return "", runErr
+	}
+	return hash, err
+}
+
+func (p *Pool) VerifyPassword(ctx context.Context, password, encodedHash string) (match bool, err error) {
+	runErr := p.run(ctx, func() {
+		match, err = VerifyPassword(password, encodedHash)
+	})
+	if runErr != nil {
+		return false, runErr
+	}
+	return match, err
+}
+
+func (p *Pool) run(ctx context.Context, fn func()) error {
+	atomic.AddInt64(&p.metrics.queued, 1)
+	timer := time.NewTimer(p.queueTimeout)
+	defer timer.Stop()
+
+	select {
+	case p.slots <- struct{}{}:
+		atomic.AddInt64(&p.metrics.queued, -1)
+	case <-timer.C:
+		atomic.AddInt64(&p.metrics.queued, -1)
+		atomic.AddInt64(&p.metrics.rejected, 1)
+		return PoolSaturatedError
+	case <-ctx.Done():
+		atomic.AddInt64(&p.metrics.queued, -1)
+		return ctx.Err()
+	}
+	defer func() { <-p.slots }()
+
+	start := time.Now()
+	fn()
+	p.metrics.observe(time.Since(start))
+	return nil
+}
+
+type poolMetrics struct {
+	queued   int64
+	rejected int64
+
+	mu      sync.Mutex
+	count   int64
+	totalMs int64
+	buckets []int64
+}
+
+func (m *poolMetrics) observe(d time.Duration) {
+	ms := d.Milliseconds()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.count++
+	m.totalMs += ms
+	for i, le := range latencyBuckets {
+		if ms <= le {
+			m.buckets[i]++
+			return
+		}
+	}
+	m.buckets[len(latencyBuckets)]++
+}
+
+func (m *poolMetrics) snapshot(inFlight, concurrency int) map[string]interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// cumulative histogram, the same way prometheus exposes it
+	histogram := map[string]int64{}
+	var cumulative int64
+	for i, le := range latencyBuckets {
+		cumulative += m.buckets[i]
+		histogram[time.Duration(le*int64(time.Millisecond)).String()] = cumulative
+	}
+	histogram["+Inf"] = cumulative + m.buckets[len(latencyBuckets)]
+
+	return map[string]interface{}{
+		"concurrency":       concurrency,
+		"in_flight":         inFlight,
+		"queue_depth":       atomic.LoadInt64(&m.queued),
+		"rejected_total":    atomic.LoadInt64(&m.rejected),
+		"hashes_total":      m.count,
+		"latency_ms_total":  m.totalMs,
+		"latency_histogram": histogram,
+	}
+}
@@ -0,0 +1,93 @@
+package password
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+var UnsupportedHashError = errors.New("unsupported password hash format")
+
+// VerifyFunc checks the password against the encoded hash of a specific algorithm.
+type VerifyFunc func(password, encodedHash string) (bool, error)
+
+type verifier struct {
+	prefix string
+	verify VerifyFunc
+	// check rejects hashes with malformed or too expensive parameters without hashing, optional
+	check func(encodedHash string) error
+}
+
+// verifiers are matched by the hash prefix which identifies the algorithm, the longest matching
+// prefix wins. New hashes are always argon2id, the others are accepted only to verify hashes
+// imported from legacy systems until they are rehashed on login.
+var verifiers = sortVerifiers([]*verifier{
+	{prefix: argon2idPrefix, verify: verifyArgon2id, check: checkArgon2id},
+	{prefix: "$2a$", verify: verifyBcrypt, check: checkBcrypt},
+	{prefix: "$2b$", verify: verifyBcrypt, check: checkBcrypt},
+	{prefix: "$2y$", verify: verifyBcrypt, check: checkBcrypt},
+	{prefix: "$pbkdf2-sha256$", verify: verifyPassLibPBKDF2, check: checkPassLibPBKDF2},
+	{prefix: "pbkdf2_sha256$", verify: verifyDjangoPBKDF2, check: checkDjangoPBKDF2},
+	{prefix: "$scrypt$", verify: verifyPassLibScrypt, check: checkPassLibScrypt},
+})
+
+func sortVerifiers(items []*verifier) []*verifier {
+	sort.SliceStable(items, func(i, j int) bool {
+		return len(items[i].prefix) > len(items[j].prefix)
+	})
+	return items
+}
+
+// Register adds a verifier for hashes starting with prefix, it replaces the verifier of the same prefix.
+// It is not safe to call concurrently with the verification, register the verifiers on start.
+func Register(prefix string, fn VerifyFunc) {
+	for _, v := range verifiers {
+		if v.prefix == prefix {
+			v.verify, v.check = fn, nil
+			return
+		}
+	}
+	verifiers = sortVerifiers(append(verifiers, &verifier{prefix: prefix, verify: fn}))
+}
+
+func verifierFor(encodedHash string) *verifier {
+	for _, v := range verifiers {
+		if strings.HasPrefix(encodedHash, v.prefix) {
+			return v
+		}
+	}
+	return nil
+}
+
+// IsSupported reports whether there is a verifier for the hash format and the hash parameters
+// are within the limits, see CheckHash.
+func IsSupported(encodedHash string) bool {
+	return CheckHash(encodedHash) == nil
+}
+
+// CheckHash validates the hash format and rejects the parameters making the verification too
+// expensive, hashes come from the admin import and are verified on every login.
+func CheckHash(encodedHash string) error {
+	v := verifierFor(encodedHash)
+	if v == nil {
+		return UnsupportedHashError
+	}
+	if v.check == nil {
+		return nil
+	}
+	return v.check(encodedHash)
+}
+
+// VerifyPassword checks the password against the hash using the algorithm identified by the hash prefix.
+func VerifyPassword(password, encodedHash string) (match bool, err error) {
+	v := verifierFor(encodedHash)
+	if v == nil {
+		return false, UnsupportedHashError
+	}
+	if v.check != nil {
+		if err := v.check(encodedHash); err != nil {
+			return false, err
+		}
+	}
+	return v.verify(password, encodedHash)
+}
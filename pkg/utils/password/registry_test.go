@@ -0,0 +1,100 @@
+package password
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+func djangoHash(password string, iterations int) string {
+	key := pbkdf2.Key([]byte(password), []byte("salt"), iterations, 32, sha256.New)
+	return fmt.Sprintf("pbkdf2_sha256$%d$salt$%s", iterations, base64.StdEncoding.EncodeToString(key))
+}
+
+func TestVerifierLongestPrefix(t *testing.T) {
+	saved := verifiers
+	verifiers = sortVerifiers(append([]*verifier{}, verifiers...))
+	t.Cleanup(func() { verifiers = saved })
+
+	// a verifier of a shorter overlapping prefix doesn't shadow bcrypt, whatever the order
+	Register("$2", func(password, encodedHash string) (bool, error) {
+		return false, fmt.Errorf("shadowed")
+	})
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		ok, err := VerifyPassword("secret", string(hash))
+		if err != nil || !ok {
+			t.Fatalf("bcrypt hash: ok %v, err %v", ok, err)
+		}
+	}
+	if _, err := VerifyPassword("secret", "$2x$unknown"); err == nil || err.Error() != "shadowed" {
+		t.Fatalf("registered prefix is not used, err %v", err)
+	}
+}
+
+func TestVerifyDjangoPBKDF2(t *testing.T) {
+	hash := djangoHash("secret", 1000)
+	if ok, err := VerifyPassword("secret", hash); err != nil || !ok {
+		t.Fatalf("right password: ok %v, err %v", ok, err)
+	}
+	if ok, err := VerifyPassword("wrong", hash); err != nil || ok {
+		t.Fatalf("wrong password: ok %v, err %v", ok, err)
+	}
+}
+
+func TestCheckHashLimits(t *testing.T) {
+	salt := passlibEncoding.EncodeToString([]byte("saltsalt"))
+	key := passlibEncoding.EncodeToString(make([]byte, 32))
+	argonSalt := base64.RawStdEncoding.EncodeToString([]byte("saltsaltsaltsalt"))
+	argonKey := base64.RawStdEncoding.EncodeToString(make([]byte, 32))
+	cases := []struct {
+		name string
+		hash string
+		ok   bool
+	}{
+		{"django pbkdf2", djangoHash("secret", 1000), true},
+		{"django pbkdf2 iterations", "pbkdf2_sha256$1000000000$salt$" + base64.StdEncoding.EncodeToString(make([]byte, 32)), false},
+		{"passlib pbkdf2", "$pbkdf2-sha256$29000$" + salt + "$" + key, true},
+		{"passlib pbkdf2 rounds", "$pbkdf2-sha256$2000001$" + salt + "$" + key, false},
+		{"scrypt", "$scrypt$ln=16,r=8,p=1$" + salt + "$" + key, true},
+		{"scrypt memory", "$scrypt$ln=20,r=8,p=1$" + salt + "$" + key, false},
+		{"scrypt overflow", "$scrypt$ln=30,r=9223372036854775807,p=1$" + salt + "$" + key, false},
+		{"scrypt parallelism", "$scrypt$ln=14,r=8,p=64$" + salt + "$" + key, false},
+		{"bcrypt cost", "$2b$31$" + strings.Repeat("a", 53), false},
+		{"argon2id", "$argon2id$v=19$m=65536,t=3,p=1$" + argonSalt + "$" + argonKey, true},
+		{"argon2id memory", "$argon2id$v=19$m=4194304,t=3,p=1$" + argonSalt + "$" + argonKey, false},
+		{"django pbkdf2 empty digest", "pbkdf2_sha256$1000$salt$", false},
+		{"django pbkdf2 short digest", "pbkdf2_sha256$1000$salt$" + base64.StdEncoding.EncodeToString(make([]byte, 8)), false},
+		{"django pbkdf2 empty salt", "pbkdf2_sha256$1000$$" + base64.StdEncoding.EncodeToString(make([]byte, 32)), false},
+		{"passlib pbkdf2 empty digest", "$pbkdf2-sha256$1000$" + salt + "$", false},
+		{"passlib pbkdf2 empty salt", "$pbkdf2-sha256$1000$$" + key, false},
+		{"scrypt empty digest", "$scrypt$ln=4,r=8,p=1$" + salt + "$", false},
+		{"scrypt empty salt", "$scrypt$ln=4,r=8,p=1$$" + key, false},
+		{"argon2id zero iterations", "$argon2id$v=19$m=64,t=0,p=1$" + argonSalt + "$" + argonKey, false},
+		{"argon2id zero parallelism", "$argon2id$v=19$m=64,t=1,p=0$" + argonSalt + "$" + argonKey, false},
+		{"argon2id memory below lanes", "$argon2id$v=19$m=8,t=1,p=4$" + argonSalt + "$" + argonKey, false},
+		{"argon2id empty salt", "$argon2id$v=19$m=64,t=1,p=1$$" + argonKey, false},
+		{"argon2id empty key", "$argon2id$v=19$m=64,t=1,p=1$" + argonSalt + "$", false},
+		{"unknown", "md5$abc", false},
+	}
+	for _, c := range cases {
+		err := CheckHash(c.hash)
+		if (err == nil) != c.ok {
+			t.Errorf("%s: err %v, want ok %v", c.name, err, c.ok)
+		}
+		if !c.ok && err != nil && c.name != "unknown" {
+			// VerifyPassword applies the same limits before hashing
+			if _, verr := VerifyPassword("secret", c.hash); verr == nil {
+				t.Errorf("%s: verified a hash rejected by CheckHash", c.name)
+			}
+		}
+	}
+}
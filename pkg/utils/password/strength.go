@@ -0,0 +1,133 @@
+package password
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// commonWords are the most frequent parts of leaked passwords.
+var commonWords = []string{
+	"password", "passw", "qwerty", "letmein", "welcome", "admin", "login", "iloveyou", "monkey",
+	"dragon", "football", "baseball", "master", "sunshine", "princess", "shadow", "trustno",
+	"superman", "batman", "starwars", "secret", "hello", "freedom", "whatever", "qazwsx",
+	"michael", "charlie", "jordan", "hunter", "summer", "winter", "spring", "autumn", "love",
+}
+
+// keyboardRows are used to find "qwerty"-like sequences.
+var keyboardRows = []string{
+	"1234567890", "qwertyuiop", "asdfghjkl", "zxcvbnm",
+}
+
+var leet = strings.NewReplacer("0", "o", "1", "i", "3", "e", "4", "a", "5", "s", "7", "t", "@", "a", "$", "s", "!", "i")
+
+// Strength estimates how hard the password is to guess with a score from 0 (very weak) to 4 (very strong).
+// It is a rough estimation of the entropy: repeated characters, alphabet and keyboard sequences,
+// common words and personal info add almost nothing to it.
+func Strength(password string, personalInfo ...string) int {
+	runes := []rune(password)
+	if len(runes) == 0 {
+		return 0
+	}
+	weights := make([]float64, len(runes))
+	for i := range weights {
+		weights[i] = 1
+	}
+
+	// predictable runs cost a fraction of a character
+	lower := []rune(strings.ToLower(password))
+	for i := 1; i < len(lower); i++ {
+		prev, cur := lower[i-1], lower[i]
+		if cur == prev || cur-prev == 1 || prev-cur == 1 || keyboardAdjacent(prev, cur) {
+			weights[i] = 0.25
+		}
+	}
+
+	// known words count as a single character
+	dictionary := append(personalTokens(personalInfo), commonWords...)
+	normalized := []rune(leet.Replace(strings.ToLower(password)))
+	if len(normalized) == len(runes) {
+		text := string(normalized)
+		for _, word := range dictionary {
+			for start := 0; ; {
+				idx := strings.Index(text[start:], word)
+				if idx < 0 {
+					break
+				}
+				from := len([]rune(text[:start+idx]))
+				to := from + len([]rune(word))
+				weights[from] = 1
+				for j := from + 1; j < to; j++ {
+					weights[j] = 0
+				}
+				start += idx + len(word)
+			}
+		}
+	}
+
+	length := 0.0
+	for _, w := range weights {
+		length += w
+	}
+	bits := length * math.Log2(float64(charsetSize(runes)))
+
+	switch {
+	case bits < 25:
+		return 0
+	case bits < 40:
+		return 1
+	case bits < 60:
+		return 2
+	case bits < 80:
+		return 3
+	default:
+		return 4
+	}
+}
+
+func keyboardAdjacent(a, b rune) bool {
+	for _, row := range keyboardRows {
+		if i := strings.IndexRune(row, a); i >= 0 && i+1 < len(row) && rune(row[i+1]) == b {
+			return true
+		}
+	}
+	return false
+}
+
+func charsetSize(runes []rune) int {
+	var lower, upper, digit, special, other bool
+	for _, r := range runes {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII:
+			special = true
+		default:
+			other = true
+		}
+	}
+	size := 0
+	if lower {
+		size += 26
+	}
+	if upper {
+		size += 26
+	}
+	if digit {
+		size += 10
+	}
+	if special {
+		size += 33
+	}
+	if other {
+		size += 100
+	}
+	if size < 2 {
+		size = 2
+	}
+	return size
+}